@@ -0,0 +1,19 @@
+package api
+
+import (
+	"darkoo/models"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+
+type OpenDirectGroupPayload struct {
+	ParticipantIds 	[]uint 	`json:"participantIds"`
+}
+
+
+func (p OpenDirectGroupPayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.ParticipantIds, validation.Required, validation.Length(1, models.MaxDirectParticipants - 1)),
+	)
+}
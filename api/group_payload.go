@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"strings"
 	"darkoo/models"
 
@@ -9,9 +10,21 @@ import (
 )
 
 
+// maxEditWindowMinutes caps a group's edit window at one week. Zero means no window.
+const maxEditWindowMinutes = 60 * 24 * 7
+
+
+// directGroupPrefix is reserved for the generated names of direct conversations
+const directGroupPrefix = "direct:"
+
+
 type CreateGroupPayload struct {
 	Name 		string 	`json:"name"`
 	Description string	`json:"description"`
+	Visibility  string  `json:"visibility"`
+	RequiresApproval bool `json:"requiresApproval"`
+	EditWindowMinutes *int `json:"editWindowMinutes"`
+	Anonymous   bool    `json:"anonymous"`
 }
 
 
@@ -22,8 +35,10 @@ func (p CreateGroupPayload) Sanitize() {
 
 func (p CreateGroupPayload) Validate() error {
 	return validation.ValidateStruct(&p, 
-		validation.Field(&p.Name, validation.Required, validation.Length(3, 30)),
+		validation.Field(&p.Name, validation.Required, validation.Length(3, 30), validation.By(notReservedGroupName)),
 		validation.Field(&p.Description, validation.Length(3, 160)),
+		validation.Field(&p.Visibility, validation.In("public", "private", "hidden")),
+		validation.Field(&p.EditWindowMinutes, validation.Min(0), validation.Max(maxEditWindowMinutes)),
 	)
 }
 
@@ -31,18 +46,21 @@ func (p CreateGroupPayload) Validate() error {
 type UpdateGroupPayload struct {
 	Name 		string 		`json:"name"`
 	Description string 		`json:"description"`
+	Visibility  string 		`json:"visibility"`
+	RequiresApproval *bool  `json:"requiresApproval"`
+	EditWindowMinutes *int  `json:"editWindowMinutes"`
 }
 
 
 func (p UpdateGroupPayload) Validate() error {
-	if p.Name != "" {
-		return validation.Validate(p.Name, validation.Required, validation.Length(3, 30))
-	}
-
-	if p.Description != "" {
-		return validation.Validate(p.Description, validation.Length(3, 160))
-	}
-	return nil
+	// Fields left empty are not being changed, and the rules below skip empty values
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.EditWindowMinutes, validation.Min(0), validation.Max(maxEditWindowMinutes)),
+		validation.Field(&p.Name, validation.Length(3, 30), validation.By(notReservedGroupName)),
+		validation.Field(&p.Description, validation.Length(3, 160)),
+		validation.Field(&p.Visibility, validation.In(string(models.PublicGroupVisibility),
+			string(models.PrivateGroupVisibility), string(models.HiddenGroupVisibility))),
+	)
 }
 
 
@@ -62,5 +80,85 @@ func (p UpdateGroupPayload) ToEntity() models.Group {
 		group.Description = p.Description
 	}
 
+	if p.Visibility != "" {
+		group.Visibility = models.GroupVisibility(p.Visibility)
+	}
+
+	group.RequiresApproval = p.RequiresApproval
+	group.EditWindowMinutes = p.EditWindowMinutes
+
 	return group
-}
\ No newline at end of file
+}
+
+
+func notReservedGroupName(value interface{}) error {
+	name, _ := value.(string)
+	if strings.HasPrefix(strings.ToLower(name), directGroupPrefix) {
+		return errors.New("is reserved")
+	}
+
+	return nil
+}
+
+
+type ResolvePseudonymPayload struct {
+	Pseudonym 	string 		`json:"pseudonym"`
+	Reason 		string 		`json:"reason"`
+}
+
+
+func (p ResolvePseudonymPayload) Sanitize() {
+	p.Pseudonym = strings.TrimSpace(p.Pseudonym)
+}
+
+
+// Validate requires a reason, since every lookup ends up in the moderation log
+func (p ResolvePseudonymPayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Pseudonym, validation.Required, validation.Length(3, 64)),
+		validation.Field(&p.Reason, validation.Required, validation.Length(3, 500)),
+	)
+}
+
+
+type UpdateMemberRolePayload struct {
+	Role 		string 		`json:"role"`
+}
+
+
+func (p UpdateMemberRolePayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Role, validation.Required, validation.In("admin", "moderator", "member")),
+	)
+}
+
+
+type TransferOwnershipPayload struct {
+	UserId 		uint 		`json:"userId"`
+}
+
+
+func (p TransferOwnershipPayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.UserId, validation.Required),
+	)
+}
+
+
+type SanctionPayload struct {
+	DurationMinutes 	int 		`json:"durationMinutes"`
+	Reason 				string 		`json:"reason"`
+}
+
+
+func (p SanctionPayload) Sanitize() {
+	p.Reason = strings.TrimSpace(p.Reason)
+}
+
+
+func (p SanctionPayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.DurationMinutes, validation.Min(0), validation.Max(60 * 24 * 365)),
+		validation.Field(&p.Reason, validation.Length(0, 500)),
+	)
+}
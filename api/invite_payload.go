@@ -0,0 +1,19 @@
+package api
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+
+type CreateInvitePayload struct {
+	ExpiresInMinutes 	int 	`json:"expiresInMinutes"`
+	MaxUses 			int 	`json:"maxUses"`
+}
+
+
+func (p CreateInvitePayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.ExpiresInMinutes, validation.Required, validation.Min(1), validation.Max(60 * 24 * 30)),
+		validation.Field(&p.MaxUses, validation.Min(0)),
+	)
+}
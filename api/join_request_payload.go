@@ -0,0 +1,24 @@
+package api
+
+import (
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+
+type ReviewJoinRequestPayload struct {
+	Message 	string 		`json:"message"`
+}
+
+
+func (p ReviewJoinRequestPayload) Sanitize() {
+	p.Message = strings.TrimSpace(p.Message)
+}
+
+
+func (p ReviewJoinRequestPayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Message, validation.Length(0, 500)),
+	)
+}
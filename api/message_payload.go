@@ -2,6 +2,7 @@ package api
 
 import (
 	"strings"
+	"time"
 	"darkoo/models"
 
 	validation "github.com/go-ozzo/ozzo-validation"
@@ -12,7 +13,9 @@ import (
 type SendMessagePayload struct {
 	Content 		string 		`json:"content"`
 	ContentType 	string 		`json:"contentType"`
-	AttachmentUrl  *string		`json:"attachmentUrl"`
+	AttachmentId   *uint		`json:"attachmentId"`
+	ParentId       *uint        `json:"parentId"`
+	Payload         models.JSON `json:"payload"`
 }
 
 
@@ -21,11 +24,27 @@ func (p SendMessagePayload) Sanitize() {
 }
 
 
+// Validate checks the message against the schema of its content type
 func (p SendMessagePayload) Validate() error {
-	return validation.ValidateStruct(&p,
-		validation.Field(&p.Content, validation.Length(1, 500)),
+	if err := validation.ValidateStruct(&p,
 		validation.Field(&p.ContentType, validation.Required),
-	)
+	); err != nil {
+		return err
+	}
+
+	message := p.ToEntity()
+	return models.ValidateUserContent(&message)
+}
+
+
+func (p SendMessagePayload) ToEntity() models.Message {
+	return models.Message{
+		Content: p.Content,
+		ContentType: p.ContentType,
+		AttachmentId: p.AttachmentId,
+		ParentId: p.ParentId,
+		Payload: p.Payload,
+	}
 }
 
 
@@ -34,10 +53,9 @@ type UpdateMessagePayload struct {
 }
 
 func (p UpdateMessagePayload) Validate() error {
-	if p.Content != "" {
-		return validation.Validate(p.Content, validation.Required, validation.Length(1, 500))
-	}
-	return nil
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Content, validation.RuneLength(0, models.MaxContentLength)),
+	)
 }
 
 
@@ -49,4 +67,89 @@ func (p UpdateMessagePayload) ToEntity() models.Message {
 	}
 
 	return message
-}
\ No newline at end of file
+}
+
+
+type RemoveMessagePayload struct {
+	Reason string `json:"reason"`
+}
+
+
+func (p RemoveMessagePayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Reason, validation.Length(0, 500)),
+	)
+}
+
+
+// ScheduleMessagePayload is a message to send to a group later, at SendAt
+type ScheduleMessagePayload struct {
+	SendMessagePayload
+	GroupId uint       `json:"groupId"`
+	SendAt  *time.Time `json:"sendAt"`
+}
+
+
+func (p ScheduleMessagePayload) Validate() error {
+	if err := validation.ValidateStruct(&p,
+		validation.Field(&p.GroupId, validation.Required),
+		validation.Field(&p.SendAt, validation.NotNil),
+	); err != nil {
+		return err
+	}
+
+	return p.SendMessagePayload.Validate()
+}
+
+
+func (p ScheduleMessagePayload) ToEntity() models.ScheduledMessage {
+	scheduled := models.ScheduledMessage{
+		Content: p.Content,
+		ContentType: p.ContentType,
+		AttachmentId: p.AttachmentId,
+		ParentId: p.ParentId,
+		Payload: p.Payload,
+		GroupId: p.GroupId,
+	}
+
+	if p.SendAt != nil {
+		scheduled.SendAt = *p.SendAt
+	}
+
+	return scheduled
+}
+
+
+// UpdateScheduledMessagePayload replaces a pending message's content and time.
+// Which group and thread it goes to can't be changed.
+type UpdateScheduledMessagePayload struct {
+	Content 		string 		`json:"content"`
+	ContentType 	string 		`json:"contentType"`
+	AttachmentId   *uint		`json:"attachmentId"`
+	Payload         models.JSON `json:"payload"`
+	SendAt         *time.Time   `json:"sendAt"`
+}
+
+
+func (p UpdateScheduledMessagePayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.ContentType, validation.Required),
+		validation.Field(&p.SendAt, validation.NotNil),
+	)
+}
+
+
+func (p UpdateScheduledMessagePayload) ToEntity() models.ScheduledMessage {
+	scheduled := models.ScheduledMessage{
+		Content: p.Content,
+		ContentType: p.ContentType,
+		AttachmentId: p.AttachmentId,
+		Payload: p.Payload,
+	}
+
+	if p.SendAt != nil {
+		scheduled.SendAt = *p.SendAt
+	}
+
+	return scheduled
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"strconv"
+
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+
+// ParsePageQuery reads the before, after and limit query params of a cursor-paginated endpoint
+func ParsePageQuery(c *gin.Context) (models.PageQuery, error) {
+	var query models.PageQuery
+	var err error
+
+	query.Limit, _ = strconv.Atoi(c.Query("limit"))
+
+	before := c.Query("before")
+	after := c.Query("after")
+
+	if before != "" && after != "" {
+		return query, apperrors.NewBadRequest("Only one of before and after can be set")
+	}
+
+	if before != "" {
+		if query.Before, err = models.DecodeCursor(before); err != nil {
+			return query, apperrors.NewBadRequest("Invalid before cursor")
+		}
+	}
+
+	if after != "" {
+		if query.After, err = models.DecodeCursor(after); err != nil {
+			return query, apperrors.NewBadRequest("Invalid after cursor")
+		}
+	}
+
+	return query, nil
+}
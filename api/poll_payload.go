@@ -0,0 +1,17 @@
+package api
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+
+type PollVotePayload struct {
+	OptionIds []uint `json:"optionIds"`
+}
+
+
+func (p PollVotePayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.OptionIds, validation.Required, validation.Length(1, 10)),
+	)
+}
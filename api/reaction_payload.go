@@ -0,0 +1,17 @@
+package api
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+
+type ReactionPayload struct {
+	Emoji 	string 	`json:"emoji"`
+}
+
+
+func (p ReactionPayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Emoji, validation.Required, validation.Length(1, 32)),
+	)
+}
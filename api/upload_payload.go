@@ -0,0 +1,36 @@
+package api
+
+import (
+	"darkoo/models"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+
+type CreateUploadPayload struct {
+	FileName 	string 	`json:"fileName"`
+	ContentType string 	`json:"contentType"`
+	MimeType 	string 	`json:"mimeType"`
+	Size 		int64 	`json:"size"`
+}
+
+
+func (p CreateUploadPayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.FileName, validation.Required, validation.Length(1, 255)),
+		validation.Field(&p.ContentType, validation.Required),
+		validation.Field(&p.MimeType, validation.Length(0, 255)),
+		validation.Field(&p.Size, validation.Required, validation.Min(int64(1))),
+	)
+}
+
+
+// ToEntity describes the file the session will receive
+func (p CreateUploadPayload) ToEntity() models.Upload {
+	return models.Upload{
+		FileName: p.FileName,
+		Kind: p.ContentType,
+		DeclaredType: p.MimeType,
+		Size: p.Size,
+	}
+}
@@ -111,6 +111,14 @@ func NewConflict(name string, value string) *Error {
 	}
 }
 
+// NewConflictWithMessage for 409 errors that aren't about a duplicate resource
+func NewConflictWithMessage(message string) *Error {
+	return &Error{
+		Type:    Conflict,
+		Message: message,
+	}
+}
+
 // NewInternal for 500 errors and unknown errors
 func NewInternal() *Error {
 	return &Error{
@@ -41,10 +41,23 @@ func InitDS() (*Ds, error) {
 
 	if err := db.AutoMigrate(
 		&models.Group{}, &models.Message{}, &models.User{}, &models.UserGroup{},
+		&models.GroupInvite{}, &models.InviteRedemption{}, &models.JoinRequest{},
+		&models.MembershipEvent{}, &models.Sanction{},
+		&models.ModerationLog{}, &models.Reaction{}, &models.Notification{},
+		&models.PinnedMessage{}, &models.MessageRevision{}, &models.UserBlock{},
+		&models.Blob{}, &models.Attachment{}, &models.AttachmentThumbnail{},
+		&models.UploadSession{}, &models.UploadChunk{},
+		&models.Poll{}, &models.PollOption{}, &models.PollVote{}, &models.ScheduledMessage{},
 	); err != nil {
 		log.Print("Error migrating models")
 		return nil, fmt.Errorf("Error migrating models: %w", err)
 	}
+
+	if err := runMigrations(db); err != nil {
+		log.Print("Error running migrations")
+		return nil, err
+	}
+
 	return &Ds{
 		DB: db,
 	}, nil
@@ -0,0 +1,161 @@
+package datasources
+
+import (
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+
+// migrations holds schema changes AutoMigrate cannot express. They run in order
+// after AutoMigrate on every start, so each one must be safe to repeat, unless it
+// is marked once and is recorded in schema_migrations the first time it runs.
+var migrations = []struct {
+	name      string
+	statement string
+	once      bool
+}{
+	{
+		// Joins that raced before memberships were unique may have left duplicates,
+		// of which the first is kept
+		name: "delete duplicate user_groups",
+		statement: `DELETE FROM user_groups WHERE id IN (
+				SELECT later.id FROM user_groups later
+				JOIN user_groups earlier ON earlier.user_id = later.user_id
+					AND earlier.group_id = later.group_id AND earlier.id < later.id
+			)`,
+	},
+	{
+		name:      "unique user_groups membership",
+		statement: `CREATE UNIQUE INDEX IF NOT EXISTS idx_user_groups_membership ON user_groups (user_id, group_id)`,
+	},
+	{
+		// Groups created before roles existed have no owner, so their earliest
+		// remaining member who isn't banned becomes it. Direct conversations have none.
+		name: "backfill group owners",
+		statement: `UPDATE user_groups SET role = 'owner'
+			WHERE id IN (
+				SELECT DISTINCT ON (user_groups.group_id) user_groups.id
+				FROM user_groups
+				JOIN groups ON groups.id = user_groups.group_id
+				WHERE groups.kind <> 'direct' AND user_groups.banned = false
+					AND user_groups.deleted_at IS NULL AND groups.deleted_at IS NULL
+					AND user_groups.group_id NOT IN (SELECT group_id FROM user_groups WHERE role = 'owner')
+				ORDER BY user_groups.group_id, user_groups.created_at, user_groups.id
+			)`,
+	},
+	{
+		// Number messages written before groups had a sequence counter
+		name: "backfill message seq",
+		statement: `UPDATE messages SET seq = numbered.seq
+			FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY group_id ORDER BY id) AS seq
+				FROM messages
+				WHERE group_id IN (SELECT group_id FROM messages GROUP BY group_id HAVING MAX(seq) = 0)
+			) AS numbered
+			WHERE messages.id = numbered.id`,
+	},
+	{
+		name: "sync group message_seq",
+		statement: `UPDATE groups SET message_seq = latest.seq
+			FROM (SELECT group_id, MAX(seq) AS seq FROM messages GROUP BY group_id) AS latest
+			WHERE groups.id = latest.group_id AND groups.message_seq < latest.seq`,
+	},
+	{
+		name: "backfill group last_message_id",
+		statement: `UPDATE groups SET last_message_id = latest.id
+			FROM (SELECT group_id, MAX(id) AS id FROM messages GROUP BY group_id) AS latest
+			WHERE groups.id = latest.group_id AND groups.last_message_id IS NULL`,
+	},
+	{
+		name:      "index messages by group and seq",
+		statement: `CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_group_seq ON messages (group_id, seq)`,
+	},
+	{
+		// Postgres recomputes the vector whenever content changes
+		name: "add messages search_vector",
+		statement: `ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector
+			GENERATED ALWAYS AS (to_tsvector('english', coalesce(content, ''))) STORED`,
+	},
+	{
+		name:      "index messages search_vector",
+		statement: `CREATE INDEX IF NOT EXISTS idx_messages_search_vector ON messages USING GIN (search_vector)`,
+	},
+	{
+		// Groups created before pseudonyms existed still need a salt of their own
+		name:      "backfill group pseudonym_salt",
+		statement: `UPDATE groups SET pseudonym_salt = md5(random()::text || id::text) WHERE pseudonym_salt IS NULL OR pseudonym_salt = ''`,
+	},
+	{
+		name:      "index user_groups by group",
+		statement: `CREATE INDEX IF NOT EXISTS idx_user_groups_group_user ON user_groups (group_id, user_id)`,
+	},
+	{
+		name:      "index user_groups by user",
+		statement: `CREATE INDEX IF NOT EXISTS idx_user_groups_user_group ON user_groups (user_id, group_id)`,
+	},
+	{
+		// Messages removed before payloads were cleared still hold theirs
+		name:      "clear payload of removed messages",
+		statement: `UPDATE messages SET payload = NULL WHERE removed_at IS NOT NULL AND payload IS NOT NULL`,
+	},
+	{
+		// Pins left behind by messages deleted before deleting unpinned them
+		name:      "delete pins of deleted messages",
+		statement: `DELETE FROM pinned_messages WHERE message_id IN
+			(SELECT id FROM messages WHERE deleted_at IS NOT NULL OR removed_at IS NOT NULL)`,
+	},
+	{
+		// Members who joined before joined_seq was recorded joined after whatever
+		// their group had sent by then
+		name: "backfill user_groups joined_seq",
+		statement: `UPDATE user_groups SET joined_seq = joined.seq
+			FROM (
+				SELECT user_groups.id, MAX(messages.seq) AS seq
+				FROM user_groups
+				JOIN messages ON messages.group_id = user_groups.group_id AND messages.created_at < user_groups.created_at
+				WHERE user_groups.joined_seq = 0
+				GROUP BY user_groups.id
+			) AS joined
+			WHERE user_groups.id = joined.id`,
+	},
+}
+
+
+func runMigrations(db *gorm.DB) error {
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+			name text PRIMARY KEY,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		)`).Error; err != nil {
+		log.Print("Could not create schema_migrations")
+		return fmt.Errorf("Could not create schema_migrations: %w", err)
+	}
+
+	for _, migration := range migrations {
+		if err := runMigration(db, migration.name, migration.statement, migration.once); err != nil {
+			log.Printf("Migration %q failed\n", migration.name)
+			return fmt.Errorf("Migration %q failed: %w", migration.name, err)
+		}
+	}
+
+	return nil
+}
+
+
+// runMigration executes a statement, recording it in the same transaction when it
+// must only run once so it is skipped from then on
+func runMigration(db *gorm.DB, name, statement string, once bool) error {
+	if !once {
+		return db.Exec(statement).Error
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		recorded := tx.Exec(`INSERT INTO schema_migrations (name) VALUES (?) ON CONFLICT DO NOTHING`, name)
+		if recorded.Error != nil || recorded.RowsAffected == 0 {
+			return recorded.Error
+		}
+
+		return tx.Exec(statement).Error
+	})
+}
@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"darkoo/api"
+	"darkoo/apperrors"
+	"darkoo/middleware"
+	"darkoo/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+
+// multipartOverhead leaves room for form fields and part headers around the file
+const multipartOverhead = 1 << 20
+
+
+type AttachmentHandler struct {
+	attachmentService models.IAttachmentService
+	maxSize           int64
+}
+
+
+func NewAttachmentHandler(AttachmentService models.IAttachmentService, maxSize int64) *AttachmentHandler {
+	h := &AttachmentHandler{ attachmentService: AttachmentService, maxSize: maxSize }
+	return h
+}
+
+
+func (h *AttachmentHandler) UploadAttachment(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+
+	if c.Request.ContentLength > h.maxSize+multipartOverhead {
+		e := apperrors.NewPayloadTooLarge(h.maxSize, c.Request.ContentLength)
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxSize+multipartOverhead)
+
+	fileHeader, err := c.FormFile("file")
+
+	if err != nil {
+		log.Printf("Could not read uploaded file: %v\n", err)
+		e := apperrors.NewBadRequest("A file is required")
+		if isBodyTooLarge(err) {
+			e = apperrors.NewPayloadTooLarge(h.maxSize, c.Request.ContentLength)
+		}
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	file, err := fileHeader.Open()
+
+	if err != nil {
+		log.Print("Could not open uploaded file")
+		c.JSON(http.StatusBadRequest, api.NewResponse(http.StatusBadRequest, "Could not read uploaded file", nil))
+		return
+	}
+	defer file.Close()
+
+	upload := models.Upload{
+		FileName: fileHeader.Filename,
+		Kind: strings.TrimSpace(c.PostForm("contentType")),
+		DeclaredType: fileHeader.Header.Get("Content-Type"),
+		Size: fileHeader.Size,
+		Body: file,
+	}
+
+	attachment, err := h.attachmentService.UploadAttachment(userId, upload)
+
+	if err != nil {
+		log.Print("Unable to upload attachment")
+		e := apperrors.GetAppError(err, "Unable to upload attachment")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusCreated, api.NewResponse(http.StatusCreated, "Successful", attachment))
+}
+
+
+func (h *AttachmentHandler) GetAttachment(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	attachmentId, _ := strconv.Atoi(id)
+
+	attachment, err := h.attachmentService.GetAttachment(userId, attachmentId)
+
+	if err != nil {
+		log.Print("Unable to get attachment")
+		e := apperrors.GetAppError(err, "Unable to get attachment")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", attachment))
+}
+
+
+// DownloadAttachment serves a signed link without a JWT so it works in <img> and
+// <video> tags. Range requests and conditional requests are handled by http.ServeContent.
+func (h *AttachmentHandler) DownloadAttachment(c *gin.Context) {
+	id := c.Param("id")
+	attachmentId, _ := strconv.Atoi(id)
+	expires := c.Query("expires")
+	variant := c.Query("variant")
+
+	attachment, body, err := h.attachmentService.OpenSignedAttachment(attachmentId, variant, expires, c.Query("sig"))
+
+	if err != nil {
+		log.Print("Unable to download attachment")
+		e := apperrors.GetAppError(err, "Unable to download attachment")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+	defer body.Close()
+
+	for name, value := range attachmentHeaders(attachment) {
+		c.Header(name, value)
+	}
+
+	// Stored files never change, so they can be cached for as long as the link lives
+	maxAge := int64(0)
+	if unix, err := strconv.ParseInt(expires, 10, 64); err == nil {
+		if remaining := unix - time.Now().Unix(); remaining > 0 {
+			maxAge = remaining
+		}
+	}
+
+	_, mimeType, _ := attachment.Variant(variant)
+	etag := attachment.UUID.String()
+	if variant != "" {
+		etag += "-" + variant
+	}
+
+	c.Header("Content-Type", mimeType)
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d, immutable", maxAge))
+	c.Header("ETag", `"`+etag+`"`)
+
+	http.ServeContent(c.Writer, c.Request, attachment.FileName, attachment.CreatedAt, body)
+}
+
+
+func (h *AttachmentHandler) GetGroupStorageUsage(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(id)
+
+	usage, err := h.attachmentService.GetGroupStorageUsage(userId, groupId)
+
+	if err != nil {
+		log.Print("Unable to get storage usage of group")
+		e := apperrors.GetAppError(err, "Unable to get storage usage of group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", usage))
+}
+
+
+func (h *AttachmentHandler) GetUserStorageUsage(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+
+	usage, err := h.attachmentService.GetUserStorageUsage(userId)
+
+	if err != nil {
+		log.Print("Unable to get storage usage")
+		e := apperrors.GetAppError(err, "Unable to get storage usage")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", usage))
+}
+
+
+// attachmentHeaders stop browsers from second-guessing the stored type, and only
+// let media render inline
+func attachmentHeaders(attachment *models.Attachment) map[string]string {
+	disposition := "attachment"
+	if attachment.Kind != "file" {
+		disposition = "inline"
+	}
+
+	return map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"Content-Disposition": mime.FormatMediaType(disposition, map[string]string{ "filename": attachment.FileName }),
+	}
+}
+
+
+// isBodyTooLarge reports whether reading the request hit the http.MaxBytesReader limit
+func isBodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "request body too large")
+}
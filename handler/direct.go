@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"darkoo/api"
+	"darkoo/apperrors"
+	"darkoo/middleware"
+	"darkoo/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+
+type DirectHandler struct {
+	directService models.IDirectService
+}
+
+
+func NewDirectHandler(DirectService models.IDirectService) *DirectHandler {
+	h := &DirectHandler{ directService: DirectService }
+	return h
+}
+
+
+func (h *DirectHandler) OpenDirectGroup(c *gin.Context) {
+	var request api.OpenDirectGroupPayload
+	userDetails, _ := c.Get("id")
+
+	if ok := api.BindData(c, &request); !ok {
+		log.Print("Error deserializing json data from direct handler")
+		return
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+
+	group, err := h.directService.OpenDirectGroup(userId, request.ParticipantIds)
+
+	if err != nil {
+		log.Print("Unable to open direct conversation")
+		e := apperrors.GetAppError(err, "Unable to open direct conversation")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", group))
+}
+
+
+func (h *DirectHandler) GetDirectGroups(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	query, err := api.ParsePageQuery(c)
+
+	if err != nil {
+		log.Print("Invalid pagination query")
+		e := apperrors.GetAppError(err, "Invalid pagination query")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+
+	groups, err := h.directService.GetDirectGroups(userId, query)
+
+	if err != nil {
+		log.Print("Unable to get direct conversations")
+		e := apperrors.GetAppError(err, "Unable to get direct conversations")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", groups))
+}
+
+
+func (h *DirectHandler) BlockUser(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	blockedId, _ := strconv.Atoi(id)
+
+	block, err := h.directService.BlockUser(userId, blockedId)
+
+	if err != nil {
+		log.Print("Unable to block user")
+		e := apperrors.GetAppError(err, "Unable to block user")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusCreated, api.NewResponse(http.StatusCreated, "Successful", block))
+}
+
+
+func (h *DirectHandler) UnblockUser(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	blockedId, _ := strconv.Atoi(id)
+
+	if err := h.directService.UnblockUser(userId, blockedId); err != nil {
+		log.Print("Unable to unblock user")
+		e := apperrors.GetAppError(err, "Unable to unblock user")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
+}
+
+
+func (h *DirectHandler) GetBlockedUsers(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+
+	blocks, err := h.directService.GetBlockedUsers(userId)
+
+	if err != nil {
+		log.Print("Unable to get blocked users")
+		e := apperrors.GetAppError(err, "Unable to get blocked users")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", blocks))
+}
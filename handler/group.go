@@ -4,11 +4,13 @@ import (
 	"net/http"
 	"log"
 	"strconv"
+	"time"
 
 	"darkoo/api"
 	"darkoo/models"
 	"darkoo/apperrors"
 	"darkoo/middleware"
+	"darkoo/websocket"
 
 	"github.com/gin-gonic/gin"
 )
@@ -16,30 +18,44 @@ import (
 
 type GroupHandler struct {
 	groupService models.IGroupService
+	hub          *websocket.Hub
 }
 
 
-func NewGroupHandler(GroupService models.IGroupService) *GroupHandler {
-	h := &GroupHandler{ groupService : GroupService }
+func NewGroupHandler(GroupService models.IGroupService, hub *websocket.Hub) *GroupHandler {
+	h := &GroupHandler{ groupService : GroupService, hub: hub }
 	return h
 }
 
 
 func (h *GroupHandler) CreateGroup(c *gin.Context) {
 	var request api.CreateGroupPayload
+	userDetails, _ := c.Get("id")
 
 	if ok := api.BindData(c, &request); !ok {
 		log.Print("Error deserializing json data from group handler")
 		return
 	}
 
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+
 	request.Sanitize()
 	createGroupPayload := &models.Group{
 		Name: request.Name,
 		Description: request.Description,
+		Visibility: models.GroupVisibility(request.Visibility),
+		RequiresApproval: &request.RequiresApproval,
+		EditWindowMinutes: request.EditWindowMinutes,
+		Anonymous: &request.Anonymous,
 	}
 
-	group, err := h.groupService.CreateGroup(createGroupPayload)
+	group, err := h.groupService.CreateGroup(userId, createGroupPayload)
 
 	if err != nil {
 		log.Print("Error creating group")
@@ -53,6 +69,7 @@ func (h *GroupHandler) CreateGroup(c *gin.Context) {
 
 func (h *GroupHandler) UpdateGroup(c *gin.Context) {
 	var request api.UpdateGroupPayload
+	userDetails, _ := c.Get("id")
 	id := c.Param("id")
 	groupId, _ := strconv.Atoi(id)
 
@@ -61,11 +78,19 @@ func (h *GroupHandler) UpdateGroup(c *gin.Context) {
 		return
 	}
 
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+
 	request.Sanitize()
 	group := request.ToEntity()
 	group.ID = uint(groupId)
 
-	err := h.groupService.UpdateGroup(group)
+	err := h.groupService.UpdateGroup(userId, group)
 
 	if err != nil {
 		log.Print("Update group failed!")
@@ -79,10 +104,19 @@ func (h *GroupHandler) UpdateGroup(c *gin.Context) {
 
 
 func (h *GroupHandler) GetGroupById(c *gin.Context) {
+	userDetails, _ := c.Get("id")
 	id := c.Param("id")
 	groupId, _ := strconv.Atoi(id)
 
-	group, err := h.groupService.GetGroupById(groupId)
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+
+	group, err := h.groupService.GetGroupById(userId, groupId)
 
 	if err != nil {
 		log.Print("Error retrieving group by provided ID")
@@ -99,8 +133,14 @@ func (h *GroupHandler) GetGroupById(c *gin.Context) {
 
 func (h *GroupHandler) GetGroupsByUserId(c *gin.Context) {
 	userDetails, _ := c.Get("id")
-	limit := c.Query("limit")
-	page := c.Query("page")
+	query, err := api.ParsePageQuery(c)
+
+	if err != nil {
+		log.Print("Invalid pagination query")
+		e := apperrors.GetAppError(err, "Invalid pagination query")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
 
 	if userDetails == nil {
 		log.Print("User not authenticated")
@@ -109,10 +149,8 @@ func (h *GroupHandler) GetGroupsByUserId(c *gin.Context) {
 	}
 
 	userId := int(userDetails.(*middleware.User).ID)
-	limitValue, _ := strconv.Atoi(limit)
-	pageValue, _ := strconv.Atoi(page)
 
-	groups, err := h.groupService.GetGroupsByUserId(userId, limitValue, pageValue)
+	groups, err := h.groupService.GetGroupsByUserId(userId, query)
 
 	if err != nil {
 		log.Print("Unable to get groups this user belongs to")
@@ -125,14 +163,24 @@ func (h *GroupHandler) GetGroupsByUserId(c *gin.Context) {
 
 
 func (h *GroupHandler) DeleteGroupById(c *gin.Context) {
+	userDetails, _ := c.Get("id")
 	id := c.Param("id")
 	groupId, _ := strconv.Atoi(id)
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
 	
-	err := h.groupService.DeleteGroupById(groupId)
+	err := h.groupService.DeleteGroupById(userId, groupId)
 
 	if err != nil {
 		log.Print("Failed to delete group")
-		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "Failed to delete group", nil))
+		e := apperrors.GetAppError(err, "Failed to delete group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
 		return
 	}
 
@@ -141,17 +189,31 @@ func (h *GroupHandler) DeleteGroupById(c *gin.Context) {
 
 
 func (h *GroupHandler) BanUserFromGroup(c *gin.Context) {
+	h.sanctionMember(c, c.Param("group_id"), h.groupService.BanUserFromGroup, "Unable to ban user from this group")
+}
+
+
+func (h *GroupHandler) UnBanUserFromGroup(c *gin.Context) {
+	userDetails, _ := c.Get("id")
 	gid := c.Param("group_id")
 	uid := c.Param("user_id")
 
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	actorId := int(userDetails.(*middleware.User).ID)
 	groupId, _ := strconv.Atoi(gid)
 	userId, _ := strconv.Atoi(uid)
 
-	err := h.groupService.BanUserFromGroup(groupId, userId)
+	err := h.groupService.UnBanUserFromGroup(actorId, groupId, userId)
 
 	if err != nil {
-		log.Print("Unable to ban user from this group")
-		c.JSON(http.StatusBadRequest, api.NewResponse(http.StatusBadRequest, "Unable to ban user from this group", nil))
+		log.Print("Unable to unban user from this group")
+		e := apperrors.GetAppError(err, "Unable to unban user from this group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
 		return
 	}
 
@@ -159,20 +221,327 @@ func (h *GroupHandler) BanUserFromGroup(c *gin.Context) {
 }
 
 
-func (h *GroupHandler) UnBanUserFromGroup(c *gin.Context) {
-	gid := c.Param("group_id")
+func (h *GroupHandler) MuteUserInGroup(c *gin.Context) {
+	h.sanctionMember(c, c.Param("id"), h.groupService.MuteUserInGroup, "Unable to mute user in this group")
+}
+
+
+func (h *GroupHandler) UnMuteUserInGroup(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	gid := c.Param("id")
 	uid := c.Param("user_id")
 
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	actorId := int(userDetails.(*middleware.User).ID)
 	groupId, _ := strconv.Atoi(gid)
 	userId, _ := strconv.Atoi(uid)
 
-	err := h.groupService.UnBanUserFromGroup(groupId, userId)
+	err := h.groupService.UnMuteUserInGroup(actorId, groupId, userId)
 
 	if err != nil {
-		log.Print("Unable to ban user from this group")
-		c.JSON(http.StatusBadRequest, api.NewResponse(http.StatusBadRequest, "Unable to ban user from this group", nil))
+		log.Print("Unable to unmute user in this group")
+		e := apperrors.GetAppError(err, "Unable to unmute user in this group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
 		return
 	}
 
 	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
-}
\ No newline at end of file
+}
+
+
+func (h *GroupHandler) GetActiveSanctions(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(id)
+
+	sanctions, err := h.groupService.GetActiveSanctions(userId, groupId)
+
+	if err != nil {
+		log.Print("Unable to get sanctions for this group")
+		e := apperrors.GetAppError(err, "Unable to get sanctions for this group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", sanctions))
+}
+
+
+// sanctionMember binds the optional duration and reason shared by bans and mutes
+func (h *GroupHandler) sanctionMember(c *gin.Context, gid string,
+	sanction func(actorId, groupId, userId int, duration time.Duration, reason string) (*models.Sanction, error), failure string) {
+	var request api.SanctionPayload
+	userDetails, _ := c.Get("id")
+	uid := c.Param("user_id")
+
+	if c.Request.ContentLength != 0 {
+		if ok := api.BindData(c, &request); !ok {
+			log.Print("Error deserializing json data from group handler")
+			return
+		}
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	actorId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(gid)
+	userId, _ := strconv.Atoi(uid)
+	duration := time.Duration(request.DurationMinutes) * time.Minute
+
+	request.Sanitize()
+	result, err := sanction(actorId, groupId, userId, duration, request.Reason)
+
+	if err != nil {
+		log.Print(failure)
+		e := apperrors.GetAppError(err, failure)
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	if result.Type == models.BanSanction {
+		h.hub.RemoveFromGroup(uint(userId), uint(groupId))
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", result))
+}
+
+
+func (h *GroupHandler) UpdateMemberRole(c *gin.Context) {
+	var request api.UpdateMemberRolePayload
+	userDetails, _ := c.Get("id")
+	gid := c.Param("id")
+	uid := c.Param("user_id")
+
+	if ok := api.BindData(c, &request); !ok {
+		log.Print("Error deserializing json data from group handler")
+		return
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	actorId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(gid)
+	userId, _ := strconv.Atoi(uid)
+
+	err := h.groupService.UpdateMemberRole(actorId, groupId, userId, models.GroupRole(request.Role))
+
+	if err != nil {
+		log.Print("Unable to update member role")
+		e := apperrors.GetAppError(err, "Unable to update member role")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
+}
+
+
+func (h *GroupHandler) KickUserFromGroup(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	gid := c.Param("id")
+	uid := c.Param("user_id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	actorId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(gid)
+	userId, _ := strconv.Atoi(uid)
+
+	err := h.groupService.KickUserFromGroup(actorId, groupId, userId)
+
+	if err != nil {
+		log.Print("Unable to remove user from this group")
+		e := apperrors.GetAppError(err, "Unable to remove user from this group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	h.hub.RemoveFromGroup(uint(userId), uint(groupId))
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
+}
+
+
+func (h *GroupHandler) TransferOwnership(c *gin.Context) {
+	var request api.TransferOwnershipPayload
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if ok := api.BindData(c, &request); !ok {
+		log.Print("Error deserializing json data from group handler")
+		return
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	actorId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(id)
+
+	err := h.groupService.TransferOwnership(actorId, groupId, int(request.UserId))
+
+	if err != nil {
+		log.Print("Unable to transfer group ownership")
+		e := apperrors.GetAppError(err, "Unable to transfer group ownership")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
+}
+
+
+func (h *GroupHandler) GetMembershipEvents(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+	limit := c.Query("limit")
+	page := c.Query("page")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(id)
+	limitValue, _ := strconv.Atoi(limit)
+	pageValue, _ := strconv.Atoi(page)
+
+	events, err := h.groupService.GetMembershipEvents(userId, groupId, limitValue, pageValue)
+
+	if err != nil {
+		log.Print("Unable to get membership events for this group")
+		e := apperrors.GetAppError(err, "Unable to get membership events for this group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", events))
+}
+
+
+func (h *GroupHandler) GetModerationLogs(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+	limit := c.Query("limit")
+	page := c.Query("page")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(id)
+	limitValue, _ := strconv.Atoi(limit)
+	pageValue, _ := strconv.Atoi(page)
+
+	filter, err := moderationLogFilter(c)
+
+	if err != nil {
+		log.Print("Invalid moderation log filter")
+		c.JSON(http.StatusBadRequest, api.NewResponse(http.StatusBadRequest, err.Error(), nil))
+		return
+	}
+
+	logs, err := h.groupService.GetModerationLogs(userId, groupId, filter, limitValue, pageValue)
+
+	if err != nil {
+		log.Print("Unable to get moderation log for this group")
+		e := apperrors.GetAppError(err, "Unable to get moderation log for this group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", logs))
+}
+
+
+// moderationLogFilter reads the action, actorId, targetUserId, from and to query params.
+// Dates are RFC 3339 timestamps.
+func moderationLogFilter(c *gin.Context) (models.ModerationLogFilter, error) {
+	filter := models.ModerationLogFilter{
+		Action: models.ModerationAction(c.Query("action")),
+	}
+
+	filter.ActorId, _ = strconv.Atoi(c.Query("actorId"))
+	filter.TargetUserId, _ = strconv.Atoi(c.Query("targetUserId"))
+
+	for param, target := range map[string]**time.Time{ "from": &filter.From, "to": &filter.To } {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, apperrors.NewBadRequest("Query param " + param + " must be an RFC 3339 timestamp")
+		}
+		*target = &parsed
+	}
+
+	return filter, nil
+}
+
+
+func (h *GroupHandler) ResolvePseudonym(c *gin.Context) {
+	var request api.ResolvePseudonymPayload
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if ok := api.BindData(c, &request); !ok {
+		log.Print("Error deserializing json data from group handler")
+		return
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	actorId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(id)
+
+	request.Sanitize()
+	author, err := h.groupService.ResolvePseudonym(actorId, groupId, request.Pseudonym, request.Reason)
+
+	if err != nil {
+		log.Print("Unable to resolve pseudonym")
+		e := apperrors.GetAppError(err, "Unable to resolve pseudonym")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", author))
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"darkoo/api"
+	"darkoo/apperrors"
+	"darkoo/middleware"
+	"darkoo/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+
+type InviteHandler struct {
+	inviteService models.IInviteService
+}
+
+
+func NewInviteHandler(InviteService models.IInviteService) *InviteHandler {
+	h := &InviteHandler{ inviteService: InviteService }
+	return h
+}
+
+
+func (h *InviteHandler) CreateInvite(c *gin.Context) {
+	var request api.CreateInvitePayload
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if ok := api.BindData(c, &request); !ok {
+		log.Print("Error deserializing json data from invite handler")
+		return
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(id)
+	expiresIn := time.Duration(request.ExpiresInMinutes) * time.Minute
+
+	invite, err := h.inviteService.CreateInvite(userId, groupId, expiresIn, request.MaxUses)
+
+	if err != nil {
+		log.Print("Unable to create invite")
+		e := apperrors.GetAppError(err, "Unable to create invite")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusCreated, api.NewResponse(http.StatusCreated, "Successful", invite))
+}
+
+
+func (h *InviteHandler) GetInvitesByGroupId(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(id)
+
+	invites, err := h.inviteService.GetInvitesByGroupId(userId, groupId)
+
+	if err != nil {
+		log.Print("Unable to get invites for this group")
+		e := apperrors.GetAppError(err, "Unable to get invites for this group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", invites))
+}
+
+
+func (h *InviteHandler) RevokeInvite(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	gid := c.Param("id")
+	iid := c.Param("invite_id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(gid)
+	inviteId, _ := strconv.Atoi(iid)
+
+	err := h.inviteService.RevokeInvite(userId, groupId, inviteId)
+
+	if err != nil {
+		log.Print("Unable to revoke invite")
+		e := apperrors.GetAppError(err, "Unable to revoke invite")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
+}
+
+
+func (h *InviteHandler) GetRedemptionsByInviteId(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	gid := c.Param("id")
+	iid := c.Param("invite_id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(gid)
+	inviteId, _ := strconv.Atoi(iid)
+
+	redemptions, err := h.inviteService.GetRedemptionsByInviteId(userId, groupId, inviteId)
+
+	if err != nil {
+		log.Print("Unable to get invite redemptions")
+		e := apperrors.GetAppError(err, "Unable to get invite redemptions")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", redemptions))
+}
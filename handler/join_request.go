@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"darkoo/api"
+	"darkoo/apperrors"
+	"darkoo/middleware"
+	"darkoo/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+
+type JoinRequestHandler struct {
+	joinRequestService models.IJoinRequestService
+}
+
+
+func NewJoinRequestHandler(JoinRequestService models.IJoinRequestService) *JoinRequestHandler {
+	h := &JoinRequestHandler{ joinRequestService: JoinRequestService }
+	return h
+}
+
+
+func (h *JoinRequestHandler) GetJoinRequestsByGroupId(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+	status := c.Query("status")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(id)
+
+	requests, err := h.joinRequestService.GetJoinRequestsByGroupId(userId, groupId, models.JoinRequestStatus(status))
+
+	if err != nil {
+		log.Print("Unable to get join requests for this group")
+		e := apperrors.GetAppError(err, "Unable to get join requests for this group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", requests))
+}
+
+
+func (h *JoinRequestHandler) ApproveJoinRequest(c *gin.Context) {
+	h.reviewJoinRequest(c, h.joinRequestService.ApproveJoinRequest, "Unable to approve join request")
+}
+
+
+func (h *JoinRequestHandler) RejectJoinRequest(c *gin.Context) {
+	h.reviewJoinRequest(c, h.joinRequestService.RejectJoinRequest, "Unable to reject join request")
+}
+
+
+func (h *JoinRequestHandler) reviewJoinRequest(c *gin.Context, review func(userId, groupId, requestId int, message string) error, failure string) {
+	var request api.ReviewJoinRequestPayload
+	userDetails, _ := c.Get("id")
+	gid := c.Param("id")
+	rid := c.Param("request_id")
+
+	// The review message is optional, so an empty body is allowed
+	if c.Request.ContentLength != 0 {
+		if ok := api.BindData(c, &request); !ok {
+			log.Print("Error deserializing json data from join request handler")
+			return
+		}
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(gid)
+	requestId, _ := strconv.Atoi(rid)
+
+	request.Sanitize()
+	err := review(userId, groupId, requestId, request.Message)
+
+	if err != nil {
+		log.Print(failure)
+		e := apperrors.GetAppError(err, failure)
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
+}
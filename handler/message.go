@@ -4,11 +4,13 @@ import (
 	"net/http"
 	"log"
 	"strconv"
+	"time"
 
 	"darkoo/api"
 	"darkoo/middleware"
 	"darkoo/models"
 	"darkoo/apperrors"
+	"darkoo/websocket"
 
 	"github.com/gin-gonic/gin"
 )
@@ -16,11 +18,12 @@ import (
 
 type MessageHandler struct {
 	messageService models.IMessageService
+	hub            *websocket.Hub
 }
 
 
-func NewMessageHandler(MessageService models.IMessageService) *MessageHandler {
-	h := &MessageHandler{ messageService: MessageService }
+func NewMessageHandler(MessageService models.IMessageService, hub *websocket.Hub) *MessageHandler {
+	h := &MessageHandler{ messageService: MessageService, hub: hub }
 	return h
 }
 
@@ -45,42 +48,53 @@ func (h *MessageHandler) SendMessage(c *gin.Context) {
 	groupId, _ := strconv.Atoi(id)
 
 	request.Sanitize()
-	request.Validate()
 
-	sendMessagePayload := &models.Message{
-		Content: request.Content,
-		ContentType: request.ContentType,
-		AttachmentUrl: request.AttachmentUrl,
-		GroupId: uint(groupId),
-		UserId: userId,
-	}
+	sendMessagePayload := request.ToEntity()
+	sendMessagePayload.GroupId = uint(groupId)
+	sendMessagePayload.UserId = userId
 
-	message, err := h.messageService.SendMessage(sendMessagePayload)
+	message, err := h.messageService.SendMessage(&sendMessagePayload)
 
 	if err != nil {
 		log.Print("Error sending message")
-		c.JSON(http.StatusBadRequest, api.NewResponse(http.StatusBadRequest, "Error sending message", nil))
+		e := apperrors.GetAppError(err, "Error sending message")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
 		return
 	}
 
+	h.hub.PublishMessage(message)
+
 	c.JSON(http.StatusCreated, api.NewResponse(http.StatusCreated, "Successful", message))
 }
 
 
 func (h *MessageHandler) GetMessagesInGroup(c *gin.Context) {
+	userDetails, _ := c.Get("id")
 	id := c.Param("id")
-	limit := c.Query("limit")
-	page := c.Query("page")
+	query, err := api.ParsePageQuery(c)
+
+	if err != nil {
+		log.Print("Invalid pagination query")
+		e := apperrors.GetAppError(err, "Invalid pagination query")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
 
+	userId := int(userDetails.(*middleware.User).ID)
 	groupId, _ := strconv.Atoi(id)
-	limitValue, _ := strconv.Atoi(limit)
-	pageValue, _ := strconv.Atoi(page)
 
-	messages, err := h.messageService.GetMessagesInGroup(groupId, limitValue, pageValue)
+	messages, err := h.messageService.GetMessagesInGroup(userId, groupId, query)
 
 	if err != nil {
 		log.Print("Unable to get messages in this group")
-		c.JSON(http.StatusBadRequest, api.NewResponse(http.StatusBadRequest, "Unable to get messages in this group", nil))
+		e := apperrors.GetAppError(err, "Unable to get messages in this group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
 		return
 	}
 
@@ -91,8 +105,14 @@ func (h *MessageHandler) GetMessagesInGroup(c *gin.Context) {
 func (h *MessageHandler) GetUserMessagesInGroup(c *gin.Context) {
 	userDetails, _ := c.Get("id")
 	id := c.Param("id")
-	limit := c.Query("limit")
-	page := c.Query("page")
+	query, err := api.ParsePageQuery(c)
+
+	if err != nil {
+		log.Print("Invalid pagination query")
+		e := apperrors.GetAppError(err, "Invalid pagination query")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
 
 	if userDetails == nil {
 		log.Print("User not authenticated")
@@ -102,14 +122,13 @@ func (h *MessageHandler) GetUserMessagesInGroup(c *gin.Context) {
 
 	userId := int(userDetails.(*middleware.User).ID)
 	groupId, _ := strconv.Atoi(id)
-	limitValue, _ := strconv.Atoi(limit)
-	pageValue, _ := strconv.Atoi(page)
 
-	messages, err := h.messageService.GetUserMessagesInGroup(userId, groupId, limitValue, pageValue)
+	messages, err := h.messageService.GetUserMessagesInGroup(userId, groupId, query)
 
 	if err != nil {
 		log.Print("Unable to get user messages in this group")
-		c.JSON(http.StatusBadRequest, api.NewResponse(http.StatusBadRequest, "Unable to get user messages in this group", nil))
+		e := apperrors.GetAppError(err, "Unable to get user messages in this group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
 		return
 	}
 
@@ -118,10 +137,18 @@ func (h *MessageHandler) GetUserMessagesInGroup(c *gin.Context) {
 
 
 func (h *MessageHandler) DeleteMessage(c *gin.Context) {
+	var request api.RemoveMessagePayload
 	userDetails, _ := c.Get("id")
 	mId := c.Param("message_id")
 	gId := c.Param("group_id")
 
+	if c.Request.ContentLength != 0 {
+		if ok := api.BindData(c, &request); !ok {
+			log.Print("Error deserializing json data from message handler")
+			return
+		}
+	}
+
 	if userDetails == nil {
 		log.Print("User not authenticated")
 		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
@@ -132,14 +159,22 @@ func (h *MessageHandler) DeleteMessage(c *gin.Context) {
 	groupId, _ := strconv.Atoi(gId)
 	userId := int(userDetails.(*middleware.User).ID)
 
-	err := h.messageService.DeleteMessage(messageId, userId, groupId)
+	message, err := h.messageService.DeleteMessage(messageId, userId, groupId, request.Reason)
 
 	if err != nil {
 		log.Print("Failed to delete message")
-		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "Failed to delete message", nil))
+		e := apperrors.GetAppError(err, "Failed to delete message")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
 		return
 	}
 
+	if message.IsRemoved() {
+		h.hub.BroadcastToGroup(message.GroupId, "messageRemoved", message)
+		c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", message))
+		return
+	}
+
+	h.hub.BroadcastToGroup(message.GroupId, "messageDeleted", gin.H{ "id": message.ID })
 	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
 }
 
@@ -171,14 +206,21 @@ func (h *MessageHandler) UpdateMessage(c *gin.Context) {
 	message := request.ToEntity()
 	message.ID = uint(messageId)
 
-	foundMessage, _ := h.messageService.GetMessageById(messageId)
+	foundMessage, err := h.messageService.GetMessageById(int(userId), messageId)
+
+	if err != nil {
+		log.Print("Update message failed!")
+		e := apperrors.GetAppError(err, "Update message failed!")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
 
 	if foundMessage.UserId != userId {
 		c.JSON(http.StatusBadRequest, api.NewResponse(http.StatusBadRequest, "You can only edit your own message", nil))
 		return
 	}
 
-	err := h.messageService.UpdateMessage(message)
+	err = h.messageService.UpdateMessage(message)
 
 	if err != nil {
 		log.Print("Update message failed!")
@@ -192,14 +234,23 @@ func (h *MessageHandler) UpdateMessage(c *gin.Context) {
 
 
 func (h *MessageHandler) GetMessageById(c *gin.Context) {
+	userDetails, _ := c.Get("id")
 	id := c.Param("id")
 	messageId, _ := strconv.Atoi(id)
 
-	message, err := h.messageService.GetMessageById(messageId)
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+
+	message, err := h.messageService.GetMessageById(userId, messageId)
 
 	if err != nil {
 		log.Print("Error getting message by ID")
-		e := apperrors.NewNotFound("Unable to find message with ID %v\n", id)
+		e := apperrors.GetAppError(err, "Unable to find message")
 		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Message, gin.H {
 			"error": e,
 		}))
@@ -207,4 +258,188 @@ func (h *MessageHandler) GetMessageById(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", message))
-}
\ No newline at end of file
+}
+
+
+func (h *MessageHandler) GetThread(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+	query, err := api.ParsePageQuery(c)
+
+	if err != nil {
+		log.Print("Invalid pagination query")
+		e := apperrors.GetAppError(err, "Invalid pagination query")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	messageId, _ := strconv.Atoi(id)
+
+	replies, err := h.messageService.GetThread(userId, messageId, query)
+
+	if err != nil {
+		log.Print("Unable to get thread for this message")
+		e := apperrors.GetAppError(err, "Unable to get thread for this message")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", replies))
+}
+
+
+func (h *MessageHandler) MarkRead(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	messageId, _ := strconv.Atoi(id)
+
+	receipt, err := h.messageService.MarkRead(userId, messageId)
+
+	if err != nil {
+		log.Print("Unable to mark message as read")
+		e := apperrors.GetAppError(err, "Unable to mark message as read")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	h.hub.PublishReadReceipt(receipt)
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
+}
+
+
+func (h *MessageHandler) GetSeenCount(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	messageId, _ := strconv.Atoi(id)
+
+	count, err := h.messageService.GetSeenCount(userId, messageId)
+
+	if err != nil {
+		log.Print("Unable to get read receipts for this message")
+		e := apperrors.GetAppError(err, "Unable to get read receipts for this message")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", gin.H{ "messageId": messageId, "seenBy": count }))
+}
+
+
+func (h *MessageHandler) GetRevisions(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	messageId, _ := strconv.Atoi(id)
+
+	revisions, err := h.messageService.GetRevisions(userId, messageId)
+
+	if err != nil {
+		log.Print("Unable to get revisions for this message")
+		e := apperrors.GetAppError(err, "Unable to get revisions for this message")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", revisions))
+}
+
+
+func (h *MessageHandler) SearchMessages(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	search, err := messageSearch(c)
+
+	if err != nil {
+		log.Print("Invalid message search")
+		c.JSON(http.StatusBadRequest, api.NewResponse(http.StatusBadRequest, err.Error(), nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+
+	results, err := h.messageService.SearchMessages(userId, search)
+
+	if err != nil {
+		log.Print("Unable to search messages")
+		e := apperrors.GetAppError(err, "Unable to search messages")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", results))
+}
+
+
+// messageSearch reads the q, groupId, authorId, contentType, from, to, hasAttachment,
+// limit and page query params. Dates are RFC 3339 timestamps.
+func messageSearch(c *gin.Context) (models.MessageSearch, error) {
+	search := models.MessageSearch{
+		Query: c.Query("q"),
+		ContentType: c.Query("contentType"),
+	}
+
+	search.GroupId, _ = strconv.Atoi(c.Query("groupId"))
+	search.AuthorId, _ = strconv.Atoi(c.Query("authorId"))
+	search.Limit, _ = strconv.Atoi(c.Query("limit"))
+	search.Page, _ = strconv.Atoi(c.Query("page"))
+
+	if value := c.Query("hasAttachment"); value != "" {
+		hasAttachment, err := strconv.ParseBool(value)
+		if err != nil {
+			return search, apperrors.NewBadRequest("Query param hasAttachment must be true or false")
+		}
+		search.HasAttachment = &hasAttachment
+	}
+
+	for param, target := range map[string]**time.Time{ "from": &search.From, "to": &search.To } {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return search, apperrors.NewBadRequest("Query param " + param + " must be an RFC 3339 timestamp")
+		}
+		*target = &parsed
+	}
+
+	return search, nil
+}
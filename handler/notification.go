@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"darkoo/api"
+	"darkoo/apperrors"
+	"darkoo/middleware"
+	"darkoo/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+
+type NotificationHandler struct {
+	notificationService models.INotificationService
+}
+
+
+func NewNotificationHandler(NotificationService models.INotificationService) *NotificationHandler {
+	h := &NotificationHandler{ notificationService: NotificationService }
+	return h
+}
+
+
+func (h *NotificationHandler) GetNotifications(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	unreadOnly := c.Query("unread") == "true"
+	query, err := api.ParsePageQuery(c)
+
+	if err != nil {
+		log.Print("Invalid pagination query")
+		e := apperrors.GetAppError(err, "Invalid pagination query")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+
+	notifications, err := h.notificationService.GetNotifications(userId, unreadOnly, query)
+
+	if err != nil {
+		log.Print("Unable to get notifications")
+		e := apperrors.GetAppError(err, "Unable to get notifications")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", notifications))
+}
+
+
+func (h *NotificationHandler) GetUnreadCount(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+
+	count, err := h.notificationService.GetUnreadCount(userId)
+
+	if err != nil {
+		log.Print("Unable to count unread notifications")
+		e := apperrors.GetAppError(err, "Unable to count unread notifications")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", gin.H{ "unread": count }))
+}
+
+
+func (h *NotificationHandler) MarkRead(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	notificationId, _ := strconv.Atoi(id)
+
+	if err := h.notificationService.MarkRead(userId, notificationId); err != nil {
+		log.Print("Unable to mark notification as read")
+		e := apperrors.GetAppError(err, "Unable to mark notification as read")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
+}
+
+
+func (h *NotificationHandler) MarkAllRead(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+
+	if err := h.notificationService.MarkAllRead(userId); err != nil {
+		log.Print("Unable to mark notifications as read")
+		e := apperrors.GetAppError(err, "Unable to mark notifications as read")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"darkoo/api"
+	"darkoo/apperrors"
+	"darkoo/middleware"
+	"darkoo/models"
+	"darkoo/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+
+type PinHandler struct {
+	pinService models.IPinService
+	hub        *websocket.Hub
+}
+
+
+func NewPinHandler(PinService models.IPinService, hub *websocket.Hub) *PinHandler {
+	h := &PinHandler{ pinService: PinService, hub: hub }
+	return h
+}
+
+
+func (h *PinHandler) PinMessage(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	messageId, _ := strconv.Atoi(id)
+
+	pin, err := h.pinService.PinMessage(userId, messageId)
+
+	if err != nil {
+		log.Print("Unable to pin message")
+		e := apperrors.GetAppError(err, "Unable to pin message")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	h.hub.BroadcastToGroup(pin.GroupId, "messagePinned", pin)
+
+	c.JSON(http.StatusCreated, api.NewResponse(http.StatusCreated, "Successful", pin))
+}
+
+
+func (h *PinHandler) UnpinMessage(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("message_id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	messageId, _ := strconv.Atoi(id)
+
+	message, err := h.pinService.UnpinMessage(userId, messageId)
+
+	if err != nil {
+		log.Print("Unable to unpin message")
+		e := apperrors.GetAppError(err, "Unable to unpin message")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	h.hub.BroadcastToGroup(message.GroupId, "messageUnpinned", gin.H{ "messageId": message.ID })
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
+}
+
+
+func (h *PinHandler) GetPinnedMessages(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	groupId, _ := strconv.Atoi(id)
+
+	pins, err := h.pinService.GetPinnedMessages(userId, groupId)
+
+	if err != nil {
+		log.Print("Unable to get pinned messages for this group")
+		e := apperrors.GetAppError(err, "Unable to get pinned messages for this group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", pins))
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"darkoo/api"
+	"darkoo/apperrors"
+	"darkoo/middleware"
+	"darkoo/models"
+	"darkoo/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+
+type PollHandler struct {
+	pollService models.IPollService
+	hub         *websocket.Hub
+}
+
+
+func NewPollHandler(PollService models.IPollService, hub *websocket.Hub) *PollHandler {
+	h := &PollHandler{ pollService: PollService, hub: hub }
+	return h
+}
+
+
+func (h *PollHandler) GetPoll(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	messageId, _ := strconv.Atoi(id)
+
+	poll, err := h.pollService.GetPoll(userId, messageId)
+
+	if err != nil {
+		log.Print("Unable to get poll")
+		e := apperrors.GetAppError(err, "Unable to get poll")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", poll))
+}
+
+
+// Vote casts the caller's vote, or changes it if they already voted
+func (h *PollHandler) Vote(c *gin.Context) {
+	var request api.PollVotePayload
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if ok := api.BindData(c, &request); !ok {
+		log.Print("Error deserializing json data from poll handler")
+		return
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	messageId, _ := strconv.Atoi(id)
+
+	poll, err := h.pollService.Vote(userId, messageId, request.OptionIds)
+
+	if err != nil {
+		log.Print("Unable to vote in poll")
+		e := apperrors.GetAppError(err, "Unable to vote in poll")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	h.hub.PublishPollResults(poll)
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", poll))
+}
+
+
+func (h *PollHandler) RetractVote(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("message_id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	messageId, _ := strconv.Atoi(id)
+
+	poll, err := h.pollService.RetractVote(userId, messageId)
+
+	if err != nil {
+		log.Print("Unable to retract vote")
+		e := apperrors.GetAppError(err, "Unable to retract vote")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	h.hub.PublishPollResults(poll)
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", poll))
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"darkoo/api"
+	"darkoo/apperrors"
+	"darkoo/middleware"
+	"darkoo/models"
+	"darkoo/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+
+type ReactionHandler struct {
+	reactionService models.IReactionService
+	hub             *websocket.Hub
+}
+
+
+func NewReactionHandler(ReactionService models.IReactionService, hub *websocket.Hub) *ReactionHandler {
+	h := &ReactionHandler{ reactionService: ReactionService, hub: hub }
+	return h
+}
+
+
+func (h *ReactionHandler) AddReaction(c *gin.Context) {
+	var request api.ReactionPayload
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if ok := api.BindData(c, &request); !ok {
+		log.Print("Error deserializing json data from reaction handler")
+		return
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	messageId, _ := strconv.Atoi(id)
+
+	message, err := h.reactionService.AddReaction(userId, messageId, request.Emoji)
+
+	if err != nil {
+		log.Print("Unable to add reaction")
+		e := apperrors.GetAppError(err, "Unable to add reaction")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	h.broadcastReactions(message, userId, request.Emoji, true)
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", message.Reactions))
+}
+
+
+func (h *ReactionHandler) RemoveReaction(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("message_id")
+	emoji := c.Param("emoji")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	messageId, _ := strconv.Atoi(id)
+
+	message, err := h.reactionService.RemoveReaction(userId, messageId, emoji)
+
+	if err != nil {
+		log.Print("Unable to remove reaction")
+		e := apperrors.GetAppError(err, "Unable to remove reaction")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	h.broadcastReactions(message, userId, emoji, false)
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", message.Reactions))
+}
+
+
+// broadcastReactions pushes the new counts to the group. The reacted flags are the
+// actor's, so they are cleared and clients compare the reactor against themselves
+// instead. In anonymous groups the reactor is only a pseudonym, without a userId.
+func (h *ReactionHandler) broadcastReactions(message *models.Message, userId int, emoji string, added bool) {
+	reactor, err := h.hub.MessageService.GetAuthor(int(message.GroupId), userId)
+	if err != nil {
+		log.Printf("Could not broadcast reactions to message %d: %v\n", message.ID, err)
+		return
+	}
+
+	reactions := make([]models.ReactionSummary, len(message.Reactions))
+	for i, reaction := range message.Reactions {
+		reactions[i] = models.ReactionSummary{ Emoji: reaction.Emoji, Count: reaction.Count }
+	}
+
+	update := gin.H{
+		"messageId": message.ID,
+		"reactor": reactor,
+		"emoji": emoji,
+		"added": added,
+		"reactions": reactions,
+	}
+
+	if reactor.UserId != nil {
+		update["userId"] = *reactor.UserId
+	}
+
+	h.hub.BroadcastToGroup(message.GroupId, "reactionUpdated", update)
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"darkoo/api"
+	"darkoo/apperrors"
+	"darkoo/middleware"
+	"darkoo/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+
+type ScheduledMessageHandler struct {
+	scheduledMessageService models.IScheduledMessageService
+}
+
+
+func NewScheduledMessageHandler(ScheduledMessageService models.IScheduledMessageService) *ScheduledMessageHandler {
+	h := &ScheduledMessageHandler{ scheduledMessageService: ScheduledMessageService }
+	return h
+}
+
+
+func (h *ScheduledMessageHandler) ScheduleMessage(c *gin.Context) {
+	var request api.ScheduleMessagePayload
+	userDetails, _ := c.Get("id")
+
+	if ok := api.BindData(c, &request); !ok {
+		log.Print("Error deserializing json data from scheduled message handler")
+		return
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	scheduled := request.ToEntity()
+	scheduled.UserId = userDetails.(*middleware.User).ID
+
+	result, err := h.scheduledMessageService.ScheduleMessage(&scheduled)
+
+	if err != nil {
+		log.Print("Unable to schedule message")
+		e := apperrors.GetAppError(err, "Unable to schedule message")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusCreated, api.NewResponse(http.StatusCreated, "Successful", result))
+}
+
+
+// GetScheduledMessages lists the caller's pending messages, optionally only those
+// for the group in the groupId query parameter
+func (h *ScheduledMessageHandler) GetScheduledMessages(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	groupId := 0
+	if value := c.Query("groupId"); value != "" {
+		var err error
+		if groupId, err = strconv.Atoi(value); err != nil || groupId <= 0 {
+			e := apperrors.NewBadRequest("groupId must be a positive integer")
+			c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+			return
+		}
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+
+	scheduled, err := h.scheduledMessageService.GetScheduledMessages(userId, groupId)
+
+	if err != nil {
+		log.Print("Unable to get scheduled messages")
+		e := apperrors.GetAppError(err, "Unable to get scheduled messages")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", scheduled))
+}
+
+
+func (h *ScheduledMessageHandler) GetScheduledMessage(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	scheduledId, _ := strconv.Atoi(id)
+
+	scheduled, err := h.scheduledMessageService.GetScheduledMessage(userId, scheduledId)
+
+	if err != nil {
+		log.Print("Unable to get scheduled message")
+		e := apperrors.GetAppError(err, "Unable to get scheduled message")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", scheduled))
+}
+
+
+func (h *ScheduledMessageHandler) UpdateScheduledMessage(c *gin.Context) {
+	var request api.UpdateScheduledMessagePayload
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if ok := api.BindData(c, &request); !ok {
+		log.Print("Error deserializing json data from scheduled message handler")
+		return
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	scheduledId, _ := strconv.Atoi(id)
+
+	scheduled, err := h.scheduledMessageService.UpdateScheduledMessage(userId, scheduledId, request.ToEntity())
+
+	if err != nil {
+		log.Print("Unable to update scheduled message")
+		e := apperrors.GetAppError(err, "Unable to update scheduled message")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", scheduled))
+}
+
+
+func (h *ScheduledMessageHandler) CancelScheduledMessage(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	scheduledId, _ := strconv.Atoi(id)
+
+	if err := h.scheduledMessageService.CancelScheduledMessage(userId, scheduledId); err != nil {
+		log.Print("Unable to cancel scheduled message")
+		e := apperrors.GetAppError(err, "Unable to cancel scheduled message")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"darkoo/api"
+	"darkoo/apperrors"
+	"darkoo/middleware"
+	"darkoo/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+
+// offsetContentType is what chunks are sent as, the same as in the tus protocol
+const offsetContentType = "application/offset+octet-stream"
+
+
+type UploadHandler struct {
+	uploadService models.IUploadService
+}
+
+
+func NewUploadHandler(UploadService models.IUploadService) *UploadHandler {
+	h := &UploadHandler{ uploadService: UploadService }
+	return h
+}
+
+
+func (h *UploadHandler) CreateUpload(c *gin.Context) {
+	var request api.CreateUploadPayload
+	userDetails, _ := c.Get("id")
+
+	if ok := api.BindData(c, &request); !ok {
+		log.Print("Error deserializing json data from upload handler")
+		return
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+
+	session, err := h.uploadService.CreateUpload(userId, request.ToEntity())
+
+	if err != nil {
+		log.Print("Unable to create upload")
+		e := apperrors.GetAppError(err, "Unable to create upload")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	setUploadHeaders(c, session)
+	c.Header("Location", "/api/uploads/"+strconv.Itoa(int(session.ID)))
+	c.JSON(http.StatusCreated, api.NewResponse(http.StatusCreated, "Successful", session))
+}
+
+
+func (h *UploadHandler) GetUpload(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	uploadId, _ := strconv.Atoi(id)
+
+	session, err := h.uploadService.GetUpload(userId, uploadId)
+
+	if err != nil {
+		log.Print("Unable to get upload")
+		e := apperrors.GetAppError(err, "Unable to get upload")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	setUploadHeaders(c, session)
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", session))
+}
+
+
+// GetUploadOffset answers HEAD requests, which clients use to find where to resume
+func (h *UploadHandler) GetUploadOffset(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	uploadId, _ := strconv.Atoi(id)
+
+	session, err := h.uploadService.GetUpload(userId, uploadId)
+
+	if err != nil {
+		log.Print("Unable to get upload")
+		c.Status(apperrors.Status(err))
+		return
+	}
+
+	setUploadHeaders(c, session)
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+
+// AppendChunk takes the raw bytes of the next chunk, with Upload-Offset saying
+// where they start
+func (h *UploadHandler) AppendChunk(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	if contentType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type")); contentType != offsetContentType {
+		e := apperrors.NewUnsupportedMediaType("Chunks must be sent as " + offsetContentType)
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+
+	if err != nil || offset < 0 {
+		e := apperrors.NewBadRequest("Upload-Offset header is required")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	uploadId, _ := strconv.Atoi(id)
+
+	session, err := h.uploadService.AppendChunk(userId, uploadId, offset, c.Request.Body)
+
+	if err != nil {
+		log.Print("Unable to append to upload")
+		e := apperrors.GetAppError(err, "Unable to append to upload")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	setUploadHeaders(c, session)
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", session))
+}
+
+
+func (h *UploadHandler) CancelUpload(c *gin.Context) {
+	userDetails, _ := c.Get("id")
+	id := c.Param("id")
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
+
+	userId := int(userDetails.(*middleware.User).ID)
+	uploadId, _ := strconv.Atoi(id)
+
+	if err := h.uploadService.CancelUpload(userId, uploadId); err != nil {
+		log.Print("Unable to cancel upload")
+		e := apperrors.GetAppError(err, "Unable to cancel upload")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
+}
+
+
+// setUploadHeaders reports progress the way tus clients expect to find it
+func setUploadHeaders(c *gin.Context, session *models.UploadSession) {
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(session.Size, 10))
+	c.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
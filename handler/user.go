@@ -127,20 +127,32 @@ func (h *UserHandler) GetUserByEmailOrUserName(c *gin.Context) {
 
 
 func (h *UserHandler) GetUsersByGroupId(c *gin.Context) {
+	userDetails, _ := c.Get("id")
 	id := c.Param("id")
-	limit := c.Query("limit")
-	page := c.Query("page")
-
 	groupId, _ := strconv.Atoi(id)
-	limitValue, _ := strconv.Atoi(limit)
-	pageValue, _ := strconv.Atoi(page)
+	query, err := api.ParsePageQuery(c)
+
+	if err != nil {
+		log.Print("Invalid pagination query")
+		e := apperrors.GetAppError(err, "Invalid pagination query")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	if userDetails == nil {
+		log.Print("User not authenticated")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, "User not authenticated", nil))
+		return
+	}
 
+	userId := int(userDetails.(*middleware.User).ID)
 
-	users, err := h.userService.GetUsersByGroupId(groupId, limitValue, pageValue)
+	users, err := h.userService.GetUsersByGroupId(userId, groupId, query)
 
 	if err != nil {
 		log.Print("Unable to get users in group")
-		c.JSON(http.StatusBadRequest, api.NewResponse(http.StatusBadRequest, "Unable to get users in group", nil))
+		e := apperrors.GetAppError(err, "Unable to get users in group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
 		return
 	}
 
@@ -384,17 +396,52 @@ func (h *UserHandler) JoinGroup(c *gin.Context) {
 	}
 
 	id := c.Param("id")
+	inviteToken := c.Query("invite")
 
 	groupId, _ := strconv.Atoi(id) 
 	userId := userDetails.(*middleware.User).ID
 
-	err := h.userService.JoinGroup(int(userId), groupId)
+	request, err := h.userService.JoinGroup(int(userId), groupId, inviteToken)
 
 	if err != nil {
 		log.Print("Join group unsuccessful")
-		c.JSON(http.StatusBadRequest, api.NewResponse(http.StatusBadRequest, "Unable to join group", nil))
+		e := apperrors.GetAppError(err, "Unable to join group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
+		return
+	}
+
+	if request != nil {
+		c.JSON(http.StatusAccepted, api.NewResponse(http.StatusAccepted, "Join request submitted", request))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
+}
+
+
+func (h *UserHandler) LeaveGroup(c *gin.Context) {
+	userDetails, _ := c.Get("id");
+
+	if userDetails == nil {
+		log.Print("Error getting user details")
+		c.JSON(http.StatusInternalServerError, api.NewResponse(http.StatusInternalServerError, 
+			"Error getting user details", nil))
+		return
+	}
+
+	id := c.Param("id")
+
+	groupId, _ := strconv.Atoi(id) 
+	userId := userDetails.(*middleware.User).ID
+
+	err := h.userService.LeaveGroup(int(userId), groupId)
+
+	if err != nil {
+		log.Print("Leave group unsuccessful")
+		e := apperrors.GetAppError(err, "Unable to leave group")
+		c.JSON(e.Status(), api.NewResponse(e.Status(), e.Error(), nil))
 		return
 	}
 
 	c.JSON(http.StatusOK, api.NewResponse(http.StatusOK, "Successful", nil))
-}
\ No newline at end of file
+}
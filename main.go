@@ -1,13 +1,18 @@
 package main
 
 import (
+	"crypto/rand"
 	"log"
 	"os"
 	"net/http"
+	"strconv"
+	"time"
 
 	"darkoo/middleware"
+	"darkoo/models"
 	"darkoo/repository"
 	services "darkoo/services"
+	"darkoo/storage"
 	"darkoo/websocket"
 
 
@@ -37,17 +42,65 @@ func main() {
 		log.Printf("Error on application startup: %v\n", err)
 	}
 
+	fileStorage, err := newStorage()
+
+	if err != nil {
+		log.Fatalf("Storage error: %v\n", err)
+	}
+
+	maxAttachmentSize := int64(envInt("MAX_ATTACHMENT_BYTES", 25 << 20))
+	urlSigner := storage.NewURLSigner(urlSigningSecret(), time.Duration(envInt("ATTACHMENT_URL_TTL_SECONDS", 300)) * time.Second)
+
 	userRepository := repository.NewUserRepository(darkooDB.DB)
 	groupRepository := repository.NewGroupRepository(darkooDB.DB)
 	messageRepository := repository.NewMessageRepository(darkooDB.DB)
-
-	userService := services.NewUserService(userRepository)
+	inviteRepository := repository.NewInviteRepository(darkooDB.DB)
+	joinRequestRepository := repository.NewJoinRequestRepository(darkooDB.DB)
+	reactionRepository := repository.NewReactionRepository(darkooDB.DB)
+	pollRepository := repository.NewPollRepository(darkooDB.DB)
+	notificationRepository := repository.NewNotificationRepository(darkooDB.DB)
+	pinRepository := repository.NewPinRepository(darkooDB.DB)
+	directRepository := repository.NewDirectRepository(darkooDB.DB)
+	attachmentRepository := repository.NewAttachmentRepository(darkooDB.DB)
+	uploadRepository := repository.NewUploadRepository(darkooDB.DB)
+	scheduledMessageRepository := repository.NewScheduledMessageRepository(darkooDB.DB)
+
+	userService := services.NewUserService(userRepository, groupRepository, inviteRepository, joinRequestRepository)
 	groupService := services.NewGroupService(groupRepository)
-	messageService := services.NewMessageService(messageRepository)
+	messageService := services.NewMessageService(messageRepository, groupRepository, reactionRepository, pollRepository, notificationRepository, urlSigner)
+	inviteService := services.NewInviteService(inviteRepository, groupRepository)
+	joinRequestService := services.NewJoinRequestService(joinRequestRepository, groupRepository)
+	reactionService := services.NewReactionService(reactionRepository, messageRepository, groupRepository, urlSigner)
+	pollService := services.NewPollService(pollRepository, messageRepository, groupRepository)
+	notificationService := services.NewNotificationService(notificationRepository)
+	pinService := services.NewPinService(pinRepository, messageRepository, groupRepository, reactionRepository,
+		pollRepository, urlSigner, envInt("MAX_PINNED_MESSAGES", 50))
+	directService := services.NewDirectService(directRepository, userRepository, groupRepository)
+	attachmentService := services.NewAttachmentService(attachmentRepository, groupRepository, fileStorage, urlSigner, maxAttachmentSize,
+		time.Duration(envInt("UNSENT_ATTACHMENT_TTL_SECONDS", 7 * 24 * 60 * 60)) * time.Second)
+	uploadService := services.NewUploadService(uploadRepository, attachmentService, fileStorage, urlSigner, maxAttachmentSize,
+		time.Duration(envInt("UPLOAD_SESSION_TTL_SECONDS", 24 * 60 * 60)) * time.Second)
+	scheduledMessageService := services.NewScheduledMessageService(scheduledMessageRepository, groupRepository, messageService)
+
+	hub := websocket.NewHub(messageService, userService)
+	go hub.Start()
+
+	go collectStorage(uploadService, attachmentService, time.Duration(envInt("STORAGE_COLLECT_INTERVAL_SECONDS", 10 * 60)) * time.Second)
+	go dispatchScheduledMessages(scheduledMessageService, hub, time.Duration(envInt("SCHEDULED_DISPATCH_INTERVAL_SECONDS", 15)) * time.Second)
 
 	userHandler := dhandlers.NewUserHandler(userService)
-	groupHandler := dhandlers.NewGroupHandler(groupService)
-	messageHandler := dhandlers.NewMessageHandler(messageService)
+	groupHandler := dhandlers.NewGroupHandler(groupService, hub)
+	messageHandler := dhandlers.NewMessageHandler(messageService, hub)
+	inviteHandler := dhandlers.NewInviteHandler(inviteService)
+	joinRequestHandler := dhandlers.NewJoinRequestHandler(joinRequestService)
+	reactionHandler := dhandlers.NewReactionHandler(reactionService, hub)
+	pollHandler := dhandlers.NewPollHandler(pollService, hub)
+	notificationHandler := dhandlers.NewNotificationHandler(notificationService)
+	pinHandler := dhandlers.NewPinHandler(pinService, hub)
+	directHandler := dhandlers.NewDirectHandler(directService)
+	attachmentHandler := dhandlers.NewAttachmentHandler(attachmentService, maxAttachmentSize)
+	uploadHandler := dhandlers.NewUploadHandler(uploadService)
+	scheduledMessageHandler := dhandlers.NewScheduledMessageHandler(scheduledMessageService)
 
 
 	jwtMiddleware, err := middleware.MiddleWare(userService)
@@ -77,6 +130,7 @@ func main() {
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "*")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Location, Upload-Offset, Upload-Length, Upload-Expires")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -107,44 +161,116 @@ func main() {
 	userAuthRoutes.POST("/verify/totp", userHandler.VerifyTOTP)
 	userAuthRoutes.POST("/disable/totp", userHandler.DisableTOTP)
 	userAuthRoutes.GET("/self", userHandler.GetLoggedInUser)
+	userAuthRoutes.GET("/self/storage", attachmentHandler.GetUserStorageUsage)
 	userAuthRoutes.PUT("/image-num", userHandler.UpdateUserImageNum)
 	userAuthRoutes.PUT("/join-group/:id", userHandler.JoinGroup)
+	userAuthRoutes.PUT("/leave-group/:id", userHandler.LeaveGroup)
+	userAuthRoutes.GET("/blocks", directHandler.GetBlockedUsers)
+	userAuthRoutes.POST("/blocks/:id", directHandler.BlockUser)
+	userAuthRoutes.DELETE("/blocks/:id", directHandler.UnblockUser)
 
 
 	groupGroup := ginEngine.Group("/api/groups").Use(jwtMiddleware.MiddlewareFunc())
 	groupGroup.POST("/create", groupHandler.CreateGroup)
-	groupGroup.PUT("/update", groupHandler.UpdateGroup)
+	groupGroup.PUT("/update/:id", groupHandler.UpdateGroup)
 	groupGroup.GET("/:id", groupHandler.GetGroupById)
 	groupGroup.GET("/self", groupHandler.GetGroupsByUserId)
 	groupGroup.DELETE("/:id", groupHandler.DeleteGroupById)
 	groupGroup.PUT("/ban/:group_id/users/:user_id", groupHandler.BanUserFromGroup)
 	groupGroup.PUT("/unban/:group_id/users/:user_id", groupHandler.UnBanUserFromGroup)
+	groupGroup.PUT("/:id/users/:user_id/role", groupHandler.UpdateMemberRole)
+	groupGroup.DELETE("/:id/users/:user_id", groupHandler.KickUserFromGroup)
+	groupGroup.PUT("/:id/users/:user_id/mute", groupHandler.MuteUserInGroup)
+	groupGroup.PUT("/:id/users/:user_id/unmute", groupHandler.UnMuteUserInGroup)
+	groupGroup.GET("/:id/sanctions", groupHandler.GetActiveSanctions)
+	groupGroup.PUT("/:id/transfer-ownership", groupHandler.TransferOwnership)
+	groupGroup.GET("/:id/membership-events", groupHandler.GetMembershipEvents)
+	groupGroup.GET("/:id/moderation-log", groupHandler.GetModerationLogs)
+	groupGroup.POST("/:id/invites", inviteHandler.CreateInvite)
+	groupGroup.GET("/:id/invites", inviteHandler.GetInvitesByGroupId)
+	groupGroup.DELETE("/:id/invites/:invite_id", inviteHandler.RevokeInvite)
+	groupGroup.GET("/:id/invites/:invite_id/redemptions", inviteHandler.GetRedemptionsByInviteId)
+	groupGroup.GET("/:id/join-requests", joinRequestHandler.GetJoinRequestsByGroupId)
+	groupGroup.PUT("/:id/join-requests/:request_id/approve", joinRequestHandler.ApproveJoinRequest)
+	groupGroup.PUT("/:id/join-requests/:request_id/reject", joinRequestHandler.RejectJoinRequest)
+	groupGroup.GET("/:id/pins", pinHandler.GetPinnedMessages)
+	groupGroup.POST("/:id/pseudonyms/resolve", groupHandler.ResolvePseudonym)
+	groupGroup.GET("/:id/storage", attachmentHandler.GetGroupStorageUsage)
 
 	
 	messageGroup := ginEngine.Group("/api/messages").Use(jwtMiddleware.MiddlewareFunc())
 	messageGroup.POST("/send/groups/:id", messageHandler.SendMessage)
 	messageGroup.GET("/groups/:id", messageHandler.GetMessagesInGroup)
 	messageGroup.GET("/groups/user/:id", messageHandler.GetUserMessagesInGroup)
+	messageGroup.GET("/search", messageHandler.SearchMessages)
 	messageGroup.DELETE("/:message_id/groups/:group_id", messageHandler.DeleteMessage)
 	messageGroup.PUT("/:id", messageHandler.UpdateMessage)
 	messageGroup.GET("/:id", messageHandler.GetMessageById)
+	messageGroup.GET("/:id/thread", messageHandler.GetThread)
+	messageGroup.PUT("/:id/read", messageHandler.MarkRead)
+	messageGroup.GET("/:id/receipts", messageHandler.GetSeenCount)
+	messageGroup.GET("/:id/revisions", messageHandler.GetRevisions)
+	messageGroup.PUT("/:id/pin", pinHandler.PinMessage)
+	messageGroup.DELETE("/:message_id/pin", pinHandler.UnpinMessage)
+	messageGroup.POST("/:id/reactions", reactionHandler.AddReaction)
+	messageGroup.DELETE("/:message_id/reactions/:emoji", reactionHandler.RemoveReaction)
+	messageGroup.GET("/:id/poll", pollHandler.GetPoll)
+	messageGroup.PUT("/:id/poll/vote", pollHandler.Vote)
+	messageGroup.DELETE("/:message_id/poll/vote", pollHandler.RetractVote)
+
+	directGroup := ginEngine.Group("/api/direct-messages").Use(jwtMiddleware.MiddlewareFunc())
+	directGroup.GET("", directHandler.GetDirectGroups)
+	directGroup.POST("", directHandler.OpenDirectGroup)
+
+	attachmentGroup := ginEngine.Group("/api/attachments").Use(jwtMiddleware.MiddlewareFunc())
+	attachmentGroup.POST("", attachmentHandler.UploadAttachment)
+	attachmentGroup.GET("/:id", attachmentHandler.GetAttachment)
+
+	// Signed download links carry their own authorization
+	ginEngine.GET("/api/attachments/:id/download", attachmentHandler.DownloadAttachment)
+
+	uploadGroup := ginEngine.Group("/api/uploads").Use(jwtMiddleware.MiddlewareFunc())
+	uploadGroup.POST("", uploadHandler.CreateUpload)
+	uploadGroup.GET("/:id", uploadHandler.GetUpload)
+	uploadGroup.HEAD("/:id", uploadHandler.GetUploadOffset)
+	uploadGroup.PATCH("/:id", uploadHandler.AppendChunk)
+	uploadGroup.DELETE("/:id", uploadHandler.CancelUpload)
+
+	scheduledGroup := ginEngine.Group("/api/scheduled-messages").Use(jwtMiddleware.MiddlewareFunc())
+	scheduledGroup.POST("", scheduledMessageHandler.ScheduleMessage)
+	scheduledGroup.GET("", scheduledMessageHandler.GetScheduledMessages)
+	scheduledGroup.GET("/:id", scheduledMessageHandler.GetScheduledMessage)
+	scheduledGroup.PUT("/:id", scheduledMessageHandler.UpdateScheduledMessage)
+	scheduledGroup.DELETE("/:id", scheduledMessageHandler.CancelScheduledMessage)
+
+	notificationGroup := ginEngine.Group("/api/notifications").Use(jwtMiddleware.MiddlewareFunc())
+	notificationGroup.GET("", notificationHandler.GetNotifications)
+	notificationGroup.GET("/unread-count", notificationHandler.GetUnreadCount)
+	notificationGroup.PUT("/read-all", notificationHandler.MarkAllRead)
+	notificationGroup.PUT("/:id/read", notificationHandler.MarkRead)
+
+	// Register WebSocket endpoint using gin. Browsers can't set headers on
+	// websocket upgrades, so the JWT is usually passed as the token query param.
+	ginEngine.GET("/ws", jwtMiddleware.MiddlewareFunc(), func(c *gin.Context) {
+		userDetails, _ := c.Get("id")
+		groupID := c.DefaultQuery("groupId", "")
 
-	hub := websocket.NewHub(messageService, userService)
-	go hub.Start()
+		if userDetails == nil || groupID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "authenticated user and groupId are required"})
+			return
+		}
 
-	// Register WebSocket endpoint using gin
-	ginEngine.GET("/ws", func(c *gin.Context) {
-		// Extract user ID and group ID from the request
-		userID := c.MustGet("id").(string) // Assuming you've set it in middleware
-		groupID := c.DefaultQuery("groupId", "")
+		// Only active members may follow a group's live feed
+		userId := int(userDetails.(*middleware.User).ID)
+		groupId, _ := strconv.Atoi(groupID)
 
-		if userID == "" || groupID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "userId and groupId are required"})
+		if _, err := groupService.GetMembership(userId, groupId); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "You are not a member of this group"})
 			return
 		}
 
-		// Pass the user ID and group ID to the WebSocket handler
-		websocket.HandleWebSocket(hub, c.Writer, c.Request)
+		// Pass the authenticated user to the WebSocket handler
+		websocket.HandleWebSocket(hub, c.Writer, c.Request, userDetails.(*middleware.User))
 	})
 
 
@@ -152,3 +278,101 @@ func main() {
 	ginEngine.Run(":" + os.Getenv("PORT"))
 		
 }
+
+
+// envInt reads a positive integer setting from the environment, falling back when unset or invalid
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+
+	return value
+}
+
+
+// collectStorage periodically removes upload sessions that were abandoned partway,
+// attachments that were never sent and content no message refers to anymore, so
+// none of them pile up in storage
+func collectStorage(uploadService models.IUploadService, attachmentService models.IAttachmentService, interval time.Duration) {
+	for range time.Tick(interval) {
+		uploads, err := uploadService.CollectExpiredUploads()
+		if err != nil {
+			log.Printf("Could not collect expired uploads: %v\n", err)
+		}
+
+		attachments, err := attachmentService.CollectUnsentAttachments()
+		if err != nil {
+			log.Printf("Could not collect unsent attachments: %v\n", err)
+		}
+
+		blobs, err := attachmentService.CollectOrphanedBlobs()
+		if err != nil {
+			log.Printf("Could not collect unreferenced blobs: %v\n", err)
+		}
+
+		if uploads > 0 || attachments > 0 || blobs > 0 {
+			log.Printf("Collected %d expired uploads, %d unsent attachments and %d unreferenced blobs\n", uploads, attachments, blobs)
+		}
+	}
+}
+
+
+// dispatchScheduledMessages periodically sends scheduled messages that have come
+// due and publishes them like any other new message. Messages that came due while
+// the server was down go out on the first round.
+func dispatchScheduledMessages(scheduledMessageService models.IScheduledMessageService, hub *websocket.Hub, interval time.Duration) {
+	for {
+		sent, err := scheduledMessageService.DispatchDue(hub.PublishMessage)
+		if err != nil {
+			log.Printf("Could not dispatch scheduled messages: %v\n", err)
+		}
+
+		if sent > 0 {
+			log.Printf("Sent %d scheduled messages\n", sent)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+
+// urlSigningSecret returns the key for signing attachment links. Without one set,
+// links are signed with a random key and stop working when the server restarts.
+func urlSigningSecret() []byte {
+	if secret := os.Getenv("ATTACHMENT_URL_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+
+	log.Print("ATTACHMENT_URL_SECRET is not set, using a random key for attachment links")
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("Could not generate attachment link key: %v\n", err)
+	}
+
+	return secret
+}
+
+
+// newStorage picks where attachments are kept. STORAGE_BACKEND=s3 targets any S3
+// compatible service, otherwise files go to a local directory.
+func newStorage() (storage.Storage, error) {
+	if os.Getenv("STORAGE_BACKEND") == "s3" {
+		return storage.NewS3(storage.S3Config{
+			Endpoint: os.Getenv("S3_ENDPOINT"),
+			Region: os.Getenv("S3_REGION"),
+			Bucket: os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PathStyle: os.Getenv("S3_PATH_STYLE") == "true",
+		})
+	}
+
+	dir := os.Getenv("STORAGE_DIR")
+	if dir == "" {
+		dir = "uploads"
+	}
+
+	return storage.NewLocal(dir)
+}
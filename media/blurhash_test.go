@@ -0,0 +1,105 @@
+package media
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+
+func decodeBase83(value string) int {
+	n := 0
+	for _, c := range value {
+		n = n*83 + strings.IndexRune(base83Characters, c)
+	}
+
+	return n
+}
+
+
+func solidImage(width, height int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	return img
+}
+
+
+func TestBlurhashLength(t *testing.T) {
+	img := testImage()
+
+	for _, size := range [][2]int{ { 1, 1 }, { 4, 3 }, { 9, 9 } } {
+		hash := Blurhash(img, size[0], size[1])
+
+		if want := 6 + 2*(size[0]*size[1]-1); len(hash) != want {
+			t.Errorf("%dx%d components: hash %q has length %d, want %d", size[0], size[1], hash, len(hash), want)
+		}
+
+		if got := decodeBase83(hash[:1]); got != (size[0]-1)+(size[1]-1)*9 {
+			t.Errorf("%dx%d components: size flag %d", size[0], size[1], got)
+		}
+	}
+}
+
+
+func TestBlurhashSolidColour(t *testing.T) {
+	c := color.RGBA{ 200, 40, 90, 255 }
+	hash := Blurhash(solidImage(16, 12, c), 4, 3)
+
+	dc := decodeBase83(hash[2:6])
+	if r, g, b := uint8(dc>>16), uint8(dc>>8), uint8(dc); r != c.R || g != c.G || b != c.B {
+		t.Errorf("average colour %d,%d,%d, want %d,%d,%d", r, g, b, c.R, c.G, c.B)
+	}
+
+	// The colour is the same all over, so no component stands out from the rest
+	if maximum := decodeBase83(hash[1:2]); maximum > 20 {
+		t.Errorf("solid colour has a component of strength %d", maximum)
+	}
+}
+
+
+func TestBlurhashGradient(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			v := uint8(x * 255 / 31)
+			img.SetRGBA(x, y, color.RGBA{ v, v, v, 255 })
+		}
+	}
+
+	hash := Blurhash(img, 2, 2)
+
+	// Components run along x first, so the first AC one is the horizontal change.
+	// Dark to light is a negative cosine weight, quantised below the midpoint of 9.
+	horizontal := decodeBase83(hash[6:8]) / (19 * 19)
+	vertical := decodeBase83(hash[8:10]) / (19 * 19)
+
+	if horizontal >= 9 {
+		t.Errorf("horizontal component %d does not show the gradient", horizontal)
+	}
+
+	if 9-horizontal <= abs(vertical-9) {
+		t.Errorf("horizontal component %d is no stronger than vertical component %d", horizontal, vertical)
+	}
+}
+
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
+
+
+func TestBlurhashEmpty(t *testing.T) {
+	if hash := Blurhash(image.NewRGBA(image.Rect(0, 0, 0, 0)), 4, 3); hash != "" {
+		t.Errorf("empty image hashed to %q", hash)
+	}
+}
@@ -0,0 +1,270 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+
+var ErrMalformed = errors.New("media: malformed image")
+
+
+const (
+	jpegSOI  = 0xD8
+	jpegSOS  = 0xDA
+	jpegAPP1 = 0xE1
+	// APP13 holds Photoshop IPTC records, which can include the place a photo was taken
+	jpegAPP13 = 0xED
+)
+
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	pngHeader  = []byte("\x89PNG\r\n\x1a\n")
+)
+
+
+// StripMetadata removes EXIF, XMP and IPTC metadata, which is where cameras record
+// location, from JPEG, PNG and WebP images. Other formats are returned unchanged.
+// A JPEG's orientation is kept, in a minimal EXIF block, and returned so
+// thumbnails can be turned the same way.
+func StripMetadata(data []byte, mimeType string) ([]byte, int, error) {
+	switch mimeType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		out, err := stripPNG(data)
+		return out, 1, err
+	case "image/webp":
+		out, err := stripWebP(data)
+		return out, 1, err
+	}
+
+	return data, 1, nil
+}
+
+
+func stripJPEG(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != jpegSOI {
+		return nil, 1, ErrMalformed
+	}
+
+	orientation := 1
+	var segments bytes.Buffer
+	pos := 2
+
+	for pos < len(data) {
+		if data[pos] != 0xFF || pos+1 >= len(data) {
+			return nil, 1, ErrMalformed
+		}
+
+		marker := data[pos+1]
+
+		// Fill bytes and markers without a length
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			segments.Write(data[pos : pos+2])
+			pos += 2
+			continue
+		}
+
+		// Everything from the start of scan onwards is image data
+		if marker == jpegSOS {
+			segments.Write(data[pos:])
+			break
+		}
+
+		if pos+4 > len(data) {
+			return nil, 1, ErrMalformed
+		}
+
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:pos+4]))
+		if end > len(data) || end < pos+4 {
+			return nil, 1, ErrMalformed
+		}
+
+		payload := data[pos+4 : end]
+
+		switch {
+		case marker == jpegAPP1 && bytes.HasPrefix(payload, exifHeader):
+			orientation = exifOrientation(payload[len(exifHeader):])
+		case marker == jpegAPP1 && bytes.HasPrefix(payload, xmpHeader):
+		case marker == jpegAPP13:
+		default:
+			segments.Write(data[pos:end])
+		}
+
+		pos = end
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+	out.Write([]byte{ 0xFF, jpegSOI })
+
+	if orientation > 1 {
+		out.Write(orientationSegment(orientation))
+	}
+
+	out.Write(segments.Bytes())
+	return out.Bytes(), orientation, nil
+}
+
+
+// exifOrientation reads the orientation tag from the first IFD of a TIFF block
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			value := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+
+	return 1
+}
+
+
+// orientationSegment builds an APP1 EXIF segment holding nothing but the orientation
+func orientationSegment(orientation int) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // header, first IFD at offset 8
+		0x00, 0x01, // one entry
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, // orientation, SHORT, count 1
+		0x00, byte(orientation), 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+
+	payload := append(append([]byte{}, exifHeader...), tiff...)
+	segment := []byte{ 0xFF, jpegAPP1, 0, 0 }
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+
+	return append(segment, payload...)
+}
+
+
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngHeader) {
+		return nil, ErrMalformed
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+	out.Write(pngHeader)
+
+	pos := len(pngHeader)
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, ErrMalformed
+		}
+
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, ErrMalformed
+		}
+
+		chunkType := string(data[pos+4 : pos+8])
+		chunk := data[pos+8 : pos+8+length]
+
+		if !isPNGMetadataChunk(chunkType, chunk) {
+			out.Write(data[pos:end])
+		}
+
+		pos = end
+	}
+
+	return out.Bytes(), nil
+}
+
+
+func isPNGMetadataChunk(chunkType string, chunk []byte) bool {
+	switch chunkType {
+	case "eXIf":
+		return true
+	case "iTXt", "tEXt", "zTXt":
+		keyword := chunk
+		if i := bytes.IndexByte(chunk, 0); i >= 0 {
+			keyword = chunk[:i]
+		}
+		return string(keyword) == "XML:com.adobe.xmp"
+	}
+
+	return false
+}
+
+
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrMalformed
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+	out.Write(data[:12])
+
+	pos := 12
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, ErrMalformed
+		}
+
+		fourCC := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		end := pos + 8 + size + size%2
+		if size < 0 || end > len(data) {
+			return nil, ErrMalformed
+		}
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte{}, data[pos:end]...)
+			if len(chunk) > 8 {
+				// Clear the flags announcing EXIF and XMP chunks
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out.Write(chunk)
+		default:
+			out.Write(data[pos:end])
+		}
+
+		pos = end
+	}
+
+	stripped := out.Bytes()
+	binary.LittleEndian.PutUint32(stripped[4:8], uint32(len(stripped)-8))
+
+	return stripped, nil
+}
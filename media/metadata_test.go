@@ -0,0 +1,254 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+
+// secret stands in for the location a camera records in its metadata
+var secret = []byte("GPS 51.5007N 0.1246W")
+
+
+func testImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.RGBA{ uint8(x * 30), uint8(y * 40), 128, 255 })
+		}
+	}
+
+	return img
+}
+
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{ 0xFF, marker, 0, 0 }
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+
+// exifPayload is a little-endian EXIF block with an orientation tag followed by
+// the secret, the way a camera stores GPS data after the first IFD
+func exifPayload(orientation int) []byte {
+	tiff := []byte{
+		'I', 'I', 0x2A, 0x00, 0x08, 0x00, 0x00, 0x00,
+		0x01, 0x00,
+		0x12, 0x01, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00,
+		byte(orientation), 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+
+	return append(append(append([]byte{}, exifHeader...), tiff...), secret...)
+}
+
+
+// jpegWithMetadata encodes a real JPEG and slips EXIF, XMP and IPTC segments in
+// after its start marker
+func jpegWithMetadata(t *testing.T, orientation int) []byte {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data := encoded.Bytes()
+
+	var out bytes.Buffer
+	out.Write(data[:2])
+	out.Write(jpegSegment(jpegAPP1, exifPayload(orientation)))
+	out.Write(jpegSegment(jpegAPP1, append(append([]byte{}, xmpHeader...), secret...)))
+	out.Write(jpegSegment(jpegAPP13, append([]byte("Photoshop 3.0\x00"), secret...)))
+	out.Write(data[2:])
+
+	return out.Bytes()
+}
+
+
+func TestStripJPEG(t *testing.T) {
+	data := jpegWithMetadata(t, 6)
+
+	stripped, orientation, err := StripMetadata(data, "image/jpeg")
+	if err != nil {
+		t.Fatalf("StripMetadata: %v", err)
+	}
+
+	if orientation != 6 {
+		t.Errorf("orientation %d, want 6", orientation)
+	}
+
+	if bytes.Contains(stripped, secret) {
+		t.Error("metadata is still in the stripped JPEG")
+	}
+
+	if !bytes.Equal(stripped[2:], append(orientationSegment(6), stripped[2+len(orientationSegment(6)):]...)) {
+		t.Error("the orientation is not kept right after the start marker")
+	}
+
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped JPEG does not decode: %v", err)
+	}
+
+	if got, _, _ := StripMetadata(stripped, "image/jpeg"); !bytes.Equal(got, stripped) {
+		t.Error("stripping twice changes the image")
+	}
+}
+
+
+func TestStripJPEGUpright(t *testing.T) {
+	stripped, orientation, err := StripMetadata(jpegWithMetadata(t, 1), "image/jpeg")
+	if err != nil {
+		t.Fatalf("StripMetadata: %v", err)
+	}
+
+	if orientation != 1 {
+		t.Errorf("orientation %d, want 1", orientation)
+	}
+
+	if bytes.Contains(stripped, exifHeader) {
+		t.Error("an upright JPEG keeps an EXIF block")
+	}
+}
+
+
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk[:4], uint32(len(data)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, data...)
+
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	return append(chunk, crc...)
+}
+
+
+func TestStripPNG(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, testImage()); err != nil {
+		t.Fatal(err)
+	}
+
+	data := encoded.Bytes()
+	// The signature and IHDR chunk come first
+	ihdrEnd := len(pngHeader) + 12 + int(binary.BigEndian.Uint32(data[len(pngHeader):]))
+	comment := pngChunk("tEXt", []byte("Comment\x00drawn by hand"))
+
+	var withMetadata bytes.Buffer
+	withMetadata.Write(data[:ihdrEnd])
+	withMetadata.Write(pngChunk("eXIf", exifPayload(1)[len(exifHeader):]))
+	withMetadata.Write(pngChunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), secret...)))
+	withMetadata.Write(comment)
+	withMetadata.Write(data[ihdrEnd:])
+
+	stripped, orientation, err := StripMetadata(withMetadata.Bytes(), "image/png")
+	if err != nil {
+		t.Fatalf("StripMetadata: %v", err)
+	}
+
+	if orientation != 1 {
+		t.Errorf("orientation %d, want 1", orientation)
+	}
+
+	if bytes.Contains(stripped, secret) {
+		t.Error("metadata is still in the stripped PNG")
+	}
+
+	if !bytes.Contains(stripped, comment) {
+		t.Error("a chunk that isn't metadata was removed")
+	}
+
+	if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped PNG does not decode: %v", err)
+	}
+}
+
+
+func webpChunk(fourCC string, data []byte) []byte {
+	chunk := make([]byte, 8, 8+len(data)+1)
+	copy(chunk, fourCC)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+
+	return chunk
+}
+
+
+func TestStripWebP(t *testing.T) {
+	// VP8X flags announce both EXIF (0x08) and XMP (0x04), alongside alpha (0x10)
+	vp8x := webpChunk("VP8X", []byte{ 0x1C, 0, 0, 0, 7, 0, 0, 5, 0, 0 })
+	image := webpChunk("VP8L", []byte{ 0x2F, 1, 2, 3, 4 })
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	body.Write(vp8x)
+	body.Write(image)
+	body.Write(webpChunk("EXIF", exifPayload(1)))
+	body.Write(webpChunk("XMP ", secret))
+
+	data := append([]byte("RIFF\x00\x00\x00\x00"), body.Bytes()...)
+	binary.LittleEndian.PutUint32(data[4:8], uint32(body.Len()))
+
+	stripped, _, err := StripMetadata(data, "image/webp")
+	if err != nil {
+		t.Fatalf("StripMetadata: %v", err)
+	}
+
+	if bytes.Contains(stripped, secret) {
+		t.Error("metadata is still in the stripped WebP")
+	}
+
+	if size := int(binary.LittleEndian.Uint32(stripped[4:8])); size != len(stripped)-8 {
+		t.Errorf("RIFF size %d, want %d", size, len(stripped)-8)
+	}
+
+	if flags := stripped[20]; flags != 0x10 {
+		t.Errorf("VP8X flags %#x, want only alpha (0x10)", flags)
+	}
+
+	if !bytes.Contains(stripped, image) {
+		t.Error("the image data was not kept")
+	}
+}
+
+
+func TestStripMalformed(t *testing.T) {
+	truncated := jpegWithMetadata(t, 1)[:40]
+
+	cases := []struct {
+		mimeType string
+		data     []byte
+	}{
+		{ "image/jpeg", []byte("not a jpeg") },
+		{ "image/jpeg", truncated },
+		{ "image/png", []byte("not a png") },
+		{ "image/png", append(append([]byte{}, pngHeader...), 0, 0, 1, 0, 'I', 'D', 'A', 'T') },
+		{ "image/webp", []byte("RIFF\x00\x00\x00\x00WAVE") },
+		{ "image/webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8L\xFF\x00\x00\x00") },
+	}
+
+	for _, c := range cases {
+		if _, _, err := StripMetadata(c.data, c.mimeType); err != ErrMalformed {
+			t.Errorf("%s %q returned %v, want ErrMalformed", c.mimeType, c.data, err)
+		}
+	}
+}
+
+
+func TestStripOtherFormats(t *testing.T) {
+	data := []byte("GIF89a and the rest")
+
+	stripped, orientation, err := StripMetadata(data, "image/gif")
+	if err != nil || orientation != 1 || !bytes.Equal(stripped, data) {
+		t.Errorf("other formats should pass through unchanged, got %q, %d, %v", stripped, orientation, err)
+	}
+}
@@ -0,0 +1,229 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	// Registered so image.Decode understands GIFs alongside JPEG and PNG
+	_ "image/gif"
+)
+
+
+// MaxPixels bounds the images that get decoded, so a small file declaring huge
+// dimensions can't exhaust memory
+const MaxPixels = 50000000
+
+
+// placeholderSize is the longest side of the image the placeholder is computed from
+const placeholderSize = 32
+
+
+var ErrTooLarge = errors.New("media: image dimensions are too large")
+
+
+// Thumbnail is a scaled down copy of an image that fits in a MaxDimension square
+type Thumbnail struct {
+	MaxDimension int
+	Width        int
+	Height       int
+	MimeType     string
+	Data         []byte
+}
+
+
+// Info describes an image as it is displayed, after applying its orientation
+type Info struct {
+	Width      int
+	Height     int
+	Blurhash   string
+	Thumbnails []Thumbnail
+}
+
+
+// Analyze measures an image and renders a thumbnail for each size smaller than it.
+// Formats the standard library can't decode return nil without an error.
+func Analyze(data []byte, orientation int, sizes []int) (*Info, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	img := orient(toRGBA(decoded), orientation)
+	bounds := img.Bounds()
+
+	info := &Info{
+		Width: bounds.Dx(),
+		Height: bounds.Dy(),
+		Blurhash: Blurhash(fit(img, placeholderSize), 4, 3),
+	}
+
+	for _, size := range sizes {
+		if size >= info.Width && size >= info.Height {
+			continue
+		}
+
+		thumbnail, err := encodeThumbnail(fit(img, size), size)
+		if err != nil {
+			return nil, err
+		}
+
+		info.Thumbnails = append(info.Thumbnails, *thumbnail)
+	}
+
+	return info, nil
+}
+
+
+// encodeThumbnail keeps transparency as PNG and uses JPEG for everything else
+func encodeThumbnail(img *image.RGBA, size int) (*Thumbnail, error) {
+	var buf bytes.Buffer
+	mimeType := "image/jpeg"
+
+	if img.Opaque() {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{ Quality: 82 }); err != nil {
+			return nil, err
+		}
+	} else {
+		mimeType = "image/png"
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Thumbnail{
+		MaxDimension: size,
+		Width: img.Bounds().Dx(),
+		Height: img.Bounds().Dy(),
+		MimeType: mimeType,
+		Data: buf.Bytes(),
+	}, nil
+}
+
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+
+	return rgba
+}
+
+
+// fit scales the image down to fit a size by size square, averaging every source
+// pixel that falls in each destination pixel
+func fit(src *image.RGBA, size int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if sw <= size && sh <= size {
+		return src
+	}
+
+	dw, dh := size, sh*size/sw
+	if sh > sw {
+		dw, dh = sw*size/sh, size
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := dy*sh/dh, (dy+1)*sh/dh
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := dx*sw/dw, (dx+1)*sw/dw
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride+x0*4 : y*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					b += uint64(row[i+2])
+					a += uint64(row[i+3])
+					n++
+				}
+			}
+
+			offset := dy*dst.Stride + dx*4
+			dst.Pix[offset] = uint8(r / n)
+			dst.Pix[offset+1] = uint8(g / n)
+			dst.Pix[offset+2] = uint8(b / n)
+			dst.Pix[offset+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+
+// orient applies an EXIF orientation, turning the image the way it is meant to be shown
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var nx, ny int
+
+			switch orientation {
+			case 2:
+				nx, ny = w-1-x, y
+			case 3:
+				nx, ny = w-1-x, h-1-y
+			case 4:
+				nx, ny = x, h-1-y
+			case 5:
+				nx, ny = y, x
+			case 6:
+				nx, ny = h-1-y, x
+			case 7:
+				nx, ny = h-1-y, w-1-x
+			case 8:
+				nx, ny = y, w-1-x
+			}
+
+			copy(dst.Pix[ny*dst.Stride+nx*4:ny*dst.Stride+nx*4+4], src.Pix[y*src.Stride+x*4:y*src.Stride+x*4+4])
+		}
+	}
+
+	return dst
+}
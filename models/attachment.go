@@ -0,0 +1,146 @@
+package models
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+
+// Attachment is an uploaded file waiting to be, or already, sent in a message.
+// Kind is the content type of the message it can be sent with. Url is a signed
+// download link filled in for whoever the attachment is being shown to.
+// Images also carry their dimensions, a blurhash placeholder and thumbnails, so
+// clients can lay out messages before anything is downloaded.
+type Attachment struct {
+	Base
+	UserId       uint                  `json:"-" gorm:"index"`
+	User         User                  `json:"-" gorm:"foreignKey:UserId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Kind         string                `json:"kind" gorm:"type:varchar(20)"`
+	MimeType     string                `json:"mimeType"`
+	FileName     string                `json:"fileName"`
+	Size         int64                 `json:"size"`
+	BlobId       *uint                 `json:"-" gorm:"index"`
+	Blob         *Blob                 `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	Width        int                   `json:"width,omitempty"`
+	Height       int                   `json:"height,omitempty"`
+	Blurhash     string                `json:"blurhash,omitempty"`
+	Thumbnails   []AttachmentThumbnail `json:"thumbnails,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	StorageKey   string                `json:"-"`
+	Url          string                `json:"url,omitempty" gorm:"-"`
+	UrlExpiresAt *time.Time            `json:"urlExpiresAt,omitempty" gorm:"-"`
+}
+
+
+// AttachmentThumbnail is a scaled down copy of an image attachment that fits in
+// a MaxDimension square. It is downloaded through its attachment's links.
+type AttachmentThumbnail struct {
+	Base
+	AttachmentId uint       `json:"-" gorm:"index"`
+	MaxDimension int        `json:"maxDimension"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	MimeType     string     `json:"mimeType"`
+	Size         int64      `json:"size"`
+	StorageKey   string     `json:"-"`
+	Url          string     `json:"url,omitempty" gorm:"-"`
+}
+
+
+// ThumbnailVariant names a thumbnail in download links
+func ThumbnailVariant(maxDimension int) string {
+	return "thumb-" + strconv.Itoa(maxDimension)
+}
+
+
+// Variant finds the stored copy a download link asks for. An empty variant is
+// the original file.
+func (a *Attachment) Variant(variant string) (key, mimeType string, ok bool) {
+	if variant == "" {
+		return a.StorageKey, a.MimeType, true
+	}
+
+	for _, thumbnail := range a.Thumbnails {
+		if ThumbnailVariant(thumbnail.MaxDimension) == variant {
+			return thumbnail.StorageKey, thumbnail.MimeType, true
+		}
+	}
+
+	return "", "", false
+}
+
+
+// Blob is stored file content, keyed by its SHA-256 so identical uploads share
+// it. RefCount is how many attachments use it, each attachment being sent in at
+// most one message. Blobs nobody references are collected along with their
+// thumbnails.
+type Blob struct {
+	Base
+	Hash       string `json:"hash" gorm:"type:char(64);uniqueIndex"`
+	Size       int64  `json:"size"`
+	StorageKey string `json:"-"`
+	RefCount   int    `json:"refCount" gorm:"index"`
+}
+
+
+// StorageUsage totals the files sent in a group or uploaded by a user. Bytes is
+// what they would take up stored separately, StoredBytes is their share of the
+// deduplicated storage, with each blob split evenly between its references.
+type StorageUsage struct {
+	Files       int64 `json:"files"`
+	Bytes       int64 `json:"bytes"`
+	StoredBytes int64 `json:"storedBytes"`
+}
+
+
+// Upload is a file as received from a client, before it is stored.
+// DeclaredType is the MIME type the client claimed for it.
+type Upload struct {
+	FileName     string
+	Kind         string
+	DeclaredType string
+	Size         int64
+	Body         io.Reader
+}
+
+
+type IAttachmentRepository interface {
+	CreateAttachment(attachment *Attachment) (*Attachment, error)
+	GetAttachmentById(id int) (*Attachment, error)
+	GetAttachmentGroupIds(id int) ([]uint, error)
+	AcquireBlob(blob *Blob, store func() error) (*Blob, error)
+	ReleaseBlob(blobId uint) error
+	GetBlobThumbnailKeys(blobId uint) ([]string, error)
+	GetUnsentAttachmentIds(before time.Time, limit int) ([]uint, error)
+	ReleaseUnsentAttachment(id uint) (bool, error)
+	GetOrphanedBlobs(limit int) ([]Blob, error)
+	DeleteBlob(blob *Blob, remove func(keys []string) error) error
+	GetGroupStorageUsage(groupId int) (*StorageUsage, error)
+	GetUserStorageUsage(userId int) (*StorageUsage, error)
+}
+
+
+type IAttachmentService interface {
+	UploadAttachment(userId int, upload Upload) (*Attachment, error)
+	GetAttachment(userId, id int) (*Attachment, error)
+	OpenSignedAttachment(id int, variant, expires, signature string) (*Attachment, io.ReadSeekCloser, error)
+	CollectUnsentAttachments() (int, error)
+	CollectOrphanedBlobs() (int, error)
+	GetGroupStorageUsage(userId, groupId int) (*StorageUsage, error)
+	GetUserStorageUsage(userId int) (*StorageUsage, error)
+}
+
+
+// IsAttachmentKind reports whether messages of the content type carry a file
+func IsAttachmentKind(kind string) bool {
+	contentType, ok := contentTypes[kind]
+	return ok && contentType.Attachment
+}
+
+
+// AcceptsMimeType reports whether a file of mimeType can be sent as kind. Plain
+// files can be anything.
+func AcceptsMimeType(kind, mimeType string) bool {
+	return IsAttachmentKind(kind) && strings.HasPrefix(mimeType, contentTypes[kind].MimeFamily)
+}
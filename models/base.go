@@ -12,7 +12,7 @@ type Base struct {
 	CreatedAt time.Time			`json:"createdAt"`
 	UpdatedAt time.Time  		`json:"updatedAt"`
 	DeletedAt gorm.DeletedAt    `gorm:"index" json:"deletedAt"`
-	UUID      uuid.UUID         `gorm:"type:uuid;uinque json:"uuid"` 
+	UUID      uuid.UUID         `gorm:"type:uuid" json:"UUID"` 
 }
 
 
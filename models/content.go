@@ -0,0 +1,340 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+
+// MaxContentLength caps the text of a message, or the caption of one carrying something else
+const MaxContentLength = 500
+
+
+// ContentPayload is the structured part of a message, such as a location's coordinates
+type ContentPayload interface {
+	Validate() error
+}
+
+
+// ContentType describes a kind of message. Attachment types carry an uploaded
+// file from MimeFamily, and System types are only ever sent by the server.
+type ContentType struct {
+	Name            string
+	ContentRequired bool
+	Attachment      bool
+	MimeFamily      string
+	System          bool
+	// NewPayload returns an empty payload to decode into, nil when the type takes none
+	NewPayload func() ContentPayload
+	// Summary is how the message is described in previews, alongside its caption
+	Summary func(message *Message, payload ContentPayload) (label, detail string)
+}
+
+
+// contentTypeList is the registry of every content type messages can be sent as
+var contentTypeList = []*ContentType{
+	{
+		Name: "text",
+		ContentRequired: true,
+		Summary: func(message *Message, _ ContentPayload) (string, string) {
+			return "", message.Content
+		},
+	},
+	attachmentContentType("image", "image/", "sent a photo"),
+	attachmentContentType("video", "video/", "sent a video"),
+	attachmentContentType("audio", "audio/", "sent an audio message"),
+	attachmentContentType("file", "", "sent a file"),
+	{
+		Name: "location",
+		NewPayload: func() ContentPayload { return &LocationPayload{} },
+		Summary: func(message *Message, payload ContentPayload) (string, string) {
+			if location, ok := payload.(*LocationPayload); ok && location.Name != "" {
+				return "shared a location", location.Name
+			}
+			return "shared a location", message.Content
+		},
+	},
+	{
+		Name: "poll",
+		NewPayload: func() ContentPayload { return &PollPayload{} },
+		Summary: func(message *Message, payload ContentPayload) (string, string) {
+			if poll, ok := payload.(*PollPayload); ok {
+				return "started a poll", poll.Question
+			}
+			return "started a poll", message.Content
+		},
+	},
+	{
+		Name: "system",
+		ContentRequired: true,
+		System: true,
+		NewPayload: func() ContentPayload { return &SystemPayload{} },
+		Summary: func(message *Message, _ ContentPayload) (string, string) {
+			return "", message.Content
+		},
+	},
+}
+
+
+var contentTypes = indexContentTypes(contentTypeList)
+
+
+func indexContentTypes(list []*ContentType) map[string]*ContentType {
+	index := make(map[string]*ContentType, len(list))
+	for _, contentType := range list {
+		index[contentType.Name] = contentType
+	}
+
+	return index
+}
+
+
+func attachmentContentType(name, mimeFamily, label string) *ContentType {
+	return &ContentType{
+		Name: name,
+		Attachment: true,
+		MimeFamily: mimeFamily,
+		Summary: func(message *Message, _ ContentPayload) (string, string) {
+			return label, message.Content
+		},
+	}
+}
+
+
+// LocationPayload is a point on the map, optionally with the place's name
+type LocationPayload struct {
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Name      string   `json:"name,omitempty"`
+	Address   string   `json:"address,omitempty"`
+}
+
+
+func (p *LocationPayload) Validate() error {
+	return validation.ValidateStruct(p,
+		validation.Field(&p.Latitude, validation.NotNil, validation.Min(-90.0), validation.Max(90.0)),
+		validation.Field(&p.Longitude, validation.NotNil, validation.Min(-180.0), validation.Max(180.0)),
+		validation.Field(&p.Name, validation.RuneLength(0, 100)),
+		validation.Field(&p.Address, validation.RuneLength(0, 200)),
+	)
+}
+
+
+// PollPayload is the question and options a poll message starts with
+type PollPayload struct {
+	Question       string     `json:"question"`
+	Options        []string   `json:"options"`
+	MultipleChoice bool       `json:"multipleChoice"`
+	Anonymous      bool       `json:"anonymous"`
+	ClosesAt       *time.Time `json:"closesAt,omitempty"`
+}
+
+
+func (p *PollPayload) Validate() error {
+	return validation.ValidateStruct(p,
+		validation.Field(&p.Question, validation.Required, validation.RuneLength(1, 300)),
+		validation.Field(&p.Options, validation.Required, validation.Length(2, 10),
+			validation.Each(validation.Required, validation.RuneLength(1, 100)), validation.By(distinctOptions)),
+		validation.Field(&p.ClosesAt, validation.By(inFuture)),
+	)
+}
+
+
+// SystemPayload names the event a system message announces
+type SystemPayload struct {
+	Event string `json:"event"`
+}
+
+
+func (p *SystemPayload) Validate() error {
+	return validation.ValidateStruct(p,
+		validation.Field(&p.Event, validation.Required, validation.Length(1, 50)),
+	)
+}
+
+
+func distinctOptions(value interface{}) error {
+	seen := map[string]bool{}
+
+	for _, option := range value.([]string) {
+		key := strings.ToLower(strings.TrimSpace(option))
+		if seen[key] {
+			return errors.New("must not repeat an option")
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
+
+
+func inFuture(value interface{}) error {
+	if at, ok := value.(*time.Time); ok && at != nil && !at.After(time.Now()) {
+		return errors.New("must be in the future")
+	}
+
+	return nil
+}
+
+
+// ValidateUserContent checks a message sent by a member against its content type.
+// Field errors are keyed the way payloads are, so they can be reported per field.
+func ValidateUserContent(message *Message) error {
+	if contentType, ok := contentTypes[message.ContentType]; ok && contentType.System {
+		return validation.Errors{ "contentType": errors.New("is reserved for messages sent by the server") }
+	}
+
+	return ValidateContent(message)
+}
+
+
+// ValidateContent checks a message's text, attachment and payload against the
+// schema of its content type. Unknown content types are rejected.
+func ValidateContent(message *Message) error {
+	contentType, ok := contentTypes[message.ContentType]
+	if !ok {
+		return validation.Errors{ "contentType": errors.New("must be one of " + strings.Join(ContentTypeNames(), ", ")) }
+	}
+
+	errs := validation.Errors{}
+
+	if contentType.ContentRequired {
+		errs["content"] = validation.Validate(message.Content, validation.Required, validation.RuneLength(1, MaxContentLength))
+	} else {
+		errs["content"] = validation.Validate(message.Content, validation.RuneLength(0, MaxContentLength))
+	}
+
+	if contentType.Attachment && message.AttachmentId == nil {
+		errs["attachmentId"] = fmt.Errorf("is required for %s messages", contentType.Name)
+	} else if !contentType.Attachment && message.AttachmentId != nil {
+		errs["attachmentId"] = fmt.Errorf("is not allowed for %s messages", contentType.Name)
+	}
+
+	if contentType.NewPayload == nil {
+		if hasPayload(message.Payload) {
+			errs["payload"] = fmt.Errorf("is not allowed for %s messages", contentType.Name)
+		}
+	} else if payload, err := contentType.decode(message.Payload); err != nil {
+		errs["payload"] = err
+	} else if err := payload.Validate(); err != nil {
+		flattenErrors(errs, "payload", err)
+	}
+
+	return errs.Filter()
+}
+
+
+// decode reads the message's payload into the content type's schema
+func (t *ContentType) decode(raw JSON) (ContentPayload, error) {
+	if !hasPayload(raw) {
+		return nil, errors.New("is required")
+	}
+
+	payload := t.NewPayload()
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(payload); err != nil {
+		return nil, errors.New("does not match the " + t.Name + " schema")
+	}
+
+	return payload, nil
+}
+
+
+// ContentTypeNames lists the content types members can send
+func ContentTypeNames() []string {
+	names := []string{}
+	for _, contentType := range contentTypeList {
+		if !contentType.System {
+			names = append(names, contentType.Name)
+		}
+	}
+
+	return names
+}
+
+
+// DecodePayload reads the message's payload as its content type's schema, nil
+// when it has none or it can't be read
+func (m *Message) DecodePayload() ContentPayload {
+	contentType, ok := contentTypes[m.ContentType]
+	if !ok || contentType.NewPayload == nil {
+		return nil
+	}
+
+	payload, err := contentType.decode(m.Payload)
+	if err != nil {
+		return nil
+	}
+
+	return payload
+}
+
+
+// Preview is the short text shown for the message in notifications: what kind of
+// message it is, followed by its text or caption. Removed messages show nothing
+// of what they said.
+func (m *Message) Preview() string {
+	if m.IsRemoved() {
+		return RemovedMessageContent
+	}
+
+	label, detail := "", m.Content
+
+	if contentType, ok := contentTypes[m.ContentType]; ok {
+		label, detail = contentType.Summary(m, m.DecodePayload())
+	} else if m.Content == "" && m.HasAttachment() {
+		label = "sent an attachment"
+	}
+
+	detail = truncate(strings.TrimSpace(detail), previewLength)
+
+	switch {
+	case label == "":
+		return detail
+	case detail == "":
+		return label
+	default:
+		return label + ": " + detail
+	}
+}
+
+
+func truncate(text string, length int) string {
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+
+	return string([]rune(text)[:length]) + "…"
+}
+
+
+func hasPayload(raw JSON) bool {
+	trimmed := bytes.TrimSpace(raw)
+	return len(trimmed) > 0 && !bytes.Equal(trimmed, []byte("null"))
+}
+
+
+// flattenErrors spreads nested validation errors into field keys such as
+// payload.options.0, so each can be reported on its own
+func flattenErrors(into validation.Errors, prefix string, err error) {
+	var nested validation.Errors
+	if !errors.As(err, &nested) {
+		into[prefix] = err
+		return
+	}
+
+	for field, fieldErr := range nested {
+		if fieldErr != nil {
+			flattenErrors(into, prefix+"."+field, fieldErr)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+
+// MaxDirectParticipants caps the size of a direct conversation, the opener included.
+// Anything larger should be a regular group.
+const MaxDirectParticipants = 10
+
+
+// UserBlock stops BlockedId from opening or continuing direct conversations with
+// BlockerId. Blocks apply in both directions once either side has set one.
+type UserBlock struct {
+	Base
+	BlockerId uint `json:"blockerId" gorm:"uniqueIndex:idx_user_blocks_pair"`
+	Blocker   User `json:"-" gorm:"foreignKey:BlockerId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	BlockedId uint `json:"blockedId" gorm:"uniqueIndex:idx_user_blocks_pair;index"`
+	Blocked   User `json:"blocked" gorm:"foreignKey:BlockedId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+
+type IDirectRepository interface {
+	CreateDirectGroup(ownerId int, participantIds []uint) (*Group, error)
+	GetDirectGroupByKey(key string) (*Group, error)
+	RestoreParticipant(groupId, userId, actorId int) error
+	GetDirectGroupsByUserId(userId int, query PageQuery) (*GroupPage, error)
+	BlockUser(block *UserBlock) error
+	UnblockUser(blockerId, blockedId int) error
+	GetBlockedUsers(blockerId int) ([]UserBlock, error)
+	HasBlockBetween(userId int, otherIds []uint) (bool, error)
+}
+
+
+type IDirectService interface {
+	OpenDirectGroup(userId int, participantIds []uint) (*Group, error)
+	GetDirectGroups(userId int, query PageQuery) (*GroupPage, error)
+	BlockUser(userId, blockedId int) (*UserBlock, error)
+	UnblockUser(userId, blockedId int) error
+	GetBlockedUsers(userId int) ([]UserBlock, error)
+}
+
+
+// DirectKey identifies a direct conversation by its participants regardless of
+// who opened it, so the same people always map to the same conversation
+func DirectKey(participantIds []uint) string {
+	ids := append([]uint(nil), participantIds...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	parts := make([]string, 0, len(ids))
+	for i, id := range ids {
+		if i > 0 && id == ids[i-1] {
+			continue
+		}
+		parts = append(parts, strconv.FormatUint(uint64(id), 10))
+	}
+
+	return strings.Join(parts, ":")
+}
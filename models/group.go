@@ -1,40 +1,154 @@
 package models
 
+import "time"
+
+
+const (
+	PublicGroupVisibility  GroupVisibility = "public"
+	PrivateGroupVisibility GroupVisibility = "private"
+	HiddenGroupVisibility  GroupVisibility = "hidden"
+)
+
+
+const (
+	StandardGroupKind GroupKind = "group"
+	DirectGroupKind   GroupKind = "direct"
+)
+
+
+// GroupVisibility controls who can find a group and whether joining needs an invite.
+// Private groups can be looked up but not joined without an invite,
+// hidden groups are not visible at all to non-members.
+type GroupVisibility string
+
+
+// GroupKind separates regular groups from direct conversations, which share
+// the message pipeline but are created and listed on their own.
+type GroupKind string
+
 
 type Group struct {
 	Base
-	Name 		string 		`json:"name" gorm:"unique"`
-	Description string 		`json:"description"`
-	Users 		[]User 		`gorm:"many2many:user_groups"`
-	Messages    []Message   `gorm:"constraint:OnUpdate:CASCADE, OnDelete:CASCADE`
+	Name             string          `json:"name" gorm:"unique"`
+	Kind             GroupKind       `json:"kind" gorm:"type:varchar(20);default:group"`
+	DirectKey        *string         `json:"-" gorm:"uniqueIndex"`
+	Description      string          `json:"description"`
+	Visibility       GroupVisibility `json:"visibility" gorm:"type:varchar(20);default:public"`
+	RequiresApproval *bool           `json:"requiresApproval" gorm:"type:bool;default:false"`
+	EditWindowMinutes *int           `json:"editWindowMinutes"`
+	Anonymous        *bool           `json:"anonymous" gorm:"type:bool;default:false"`
+	PseudonymSalt    string          `json:"-"`
+	MessageSeq       int64           `json:"-" gorm:"not null;default:0"`
+	LastMessageId    *uint           `json:"-" gorm:"index"`
+	LastMessage      *Message        `json:"lastMessage,omitempty" gorm:"-"`
+	UnreadCount      int64           `json:"unreadCount" gorm:"-"`
+	Users            []User          `gorm:"many2many:user_groups"`
+	Messages         []Message
 }
 
 
 type UserGroup struct {
 	Base
-	UserId     uint    `gorm:"primaryKey" json:"userId"`
-	GroupId    uint	   `gorm:"primaryKey" json:"groupId"`
-	Banned     bool    `json:"ban" gorm:"type:bool;default:false"`
+	UserId      uint        `gorm:"primaryKey" json:"userId"`
+	GroupId     uint        `gorm:"primaryKey" json:"groupId"`
+	Banned      bool        `json:"ban" gorm:"type:bool;default:false"`
+	BannedUntil *time.Time  `json:"bannedUntil"`
+	Muted       bool        `json:"muted" gorm:"type:bool;default:false"`
+	MutedUntil  *time.Time  `json:"mutedUntil"`
+	Role        GroupRole   `json:"role" gorm:"type:varchar(20);default:member"`
+	LastReadSeq int64       `json:"lastReadSeq" gorm:"not null;default:0"`
+	// JoinedSeq is the group's last message when the member joined. Earlier
+	// messages start out read for them, but they never actually saw them.
+	JoinedSeq   int64       `json:"-" gorm:"not null;default:0"`
 }
 
 
 type IGroupRepository interface {
-	CreateGroup(group *Group) (*Group, error)
-	UpdateGroup(group Group) error
+	CreateGroup(group *Group, ownerId int) (*Group, error)
+	UpdateGroup(actorId int, group Group) error
 	GetGroupById(id int) (*Group, error)
-	GetGroupsByUserId(userId, limit, page int) ([]Group, error)
+	GetGroupsByUserId(userId int, query PageQuery) (*GroupPage, error)
 	DeleteGroupById(id int) error
-	BanUserFromGroup(groupId, userId int) error
-	UnBanUserFromGroup(groupId, userId int) error
+	GetMembership(groupId, userId int) (*UserGroup, error)
+	GetMembersByUserNames(groupId int, userNames []string) ([]User, error)
+	GetUsersByIds(ids []uint) ([]User, error)
+	ResolvePseudonym(actorId int, group *Group, pseudonym, reason string) (*User, error)
+	MarkRead(groupId, userId int, seq int64) error
+	HasBlockWithMember(groupId, userId int) (bool, error)
+	CountReaders(groupId int, seq int64, authorId uint) (int64, error)
+	SanctionMember(sanction *Sanction) (*Sanction, error)
+	LiftSanction(actorId, groupId, userId int, sanctionType SanctionType) error
+	GetActiveSanctions(groupId int) ([]Sanction, error)
+	KickUserFromGroup(actorId, groupId, userId int) error
+	UpdateMemberRole(actorId, groupId, userId int, role GroupRole) error
+	TransferOwnership(groupId, ownerId, newOwnerId int) error
+	GetMembershipEvents(groupId, limit, page int) ([]MembershipEvent, error)
+	GetModerationLogs(groupId int, filter ModerationLogFilter, limit, page int) ([]ModerationLog, error)
 }
 
 
 type IGroupService interface {
-	CreateGroup(group *Group) (*Group, error)
-	UpdateGroup(group Group) error
-	GetGroupById(id int) (*Group, error)
-	GetGroupsByUserId(userId, limit, page int) ([]Group, error)
-	DeleteGroupById(id int) error
-	BanUserFromGroup(groupId, userId int) error
-	UnBanUserFromGroup(groupId, userId int) error
-}
\ No newline at end of file
+	CreateGroup(userId int, group *Group) (*Group, error)
+	UpdateGroup(userId int, group Group) error
+	GetGroupById(userId, id int) (*Group, error)
+	GetGroupsByUserId(userId int, query PageQuery) (*GroupPage, error)
+	GetMembership(userId, groupId int) (*UserGroup, error)
+	DeleteGroupById(userId, id int) error
+	BanUserFromGroup(actorId, groupId, userId int, duration time.Duration, reason string) (*Sanction, error)
+	UnBanUserFromGroup(actorId, groupId, userId int) error
+	MuteUserInGroup(actorId, groupId, userId int, duration time.Duration, reason string) (*Sanction, error)
+	UnMuteUserInGroup(actorId, groupId, userId int) error
+	GetActiveSanctions(userId, groupId int) ([]Sanction, error)
+	KickUserFromGroup(actorId, groupId, userId int) error
+	UpdateMemberRole(actorId, groupId, userId int, role GroupRole) error
+	TransferOwnership(actorId, groupId, newOwnerId int) error
+	GetMembershipEvents(userId, groupId, limit, page int) ([]MembershipEvent, error)
+	GetModerationLogs(userId, groupId int, filter ModerationLogFilter, limit, page int) ([]ModerationLog, error)
+	ResolvePseudonym(actorId, groupId int, pseudonym, reason string) (*Author, error)
+}
+
+
+// IsBanned reports whether a ban is in force. Timed bans lapse on their own.
+func (ug *UserGroup) IsBanned(now time.Time) bool {
+	return ug.Banned && (ug.BannedUntil == nil || ug.BannedUntil.After(now))
+}
+
+
+// IsMuted reports whether a mute is in force. Muted members can read but not post.
+func (ug *UserGroup) IsMuted(now time.Time) bool {
+	return ug.Muted && (ug.MutedUntil == nil || ug.MutedUntil.After(now))
+}
+
+
+// EditDeadline returns when edits to a message sent at sentAt stop being accepted.
+// Groups without an edit window return nil.
+func (g *Group) EditDeadline(sentAt time.Time) *time.Time {
+	if g.EditWindowMinutes == nil || *g.EditWindowMinutes <= 0 {
+		return nil
+	}
+
+	deadline := sentAt.Add(time.Duration(*g.EditWindowMinutes) * time.Minute)
+	return &deadline
+}
+
+
+// IsAnonymous reports whether members are shown to each other by pseudonym only
+func (g *Group) IsAnonymous() bool {
+	return g.Anonymous != nil && *g.Anonymous
+}
+
+
+func (g *Group) IsDirect() bool {
+	return g.Kind == DirectGroupKind
+}
+
+
+// NeedsApproval reports whether joining without an invite creates a join request
+func (g *Group) NeedsApproval() bool {
+	return g.RequiresApproval != nil && *g.RequiresApproval
+}
+
+
+func (v GroupVisibility) IsValid() bool {
+	return v == PublicGroupVisibility || v == PrivateGroupVisibility || v == HiddenGroupVisibility
+}
@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+
+type GroupInvite struct {
+	Base
+	GroupId     uint        `json:"groupId" gorm:"index"`
+	Group       Group       `json:"-" gorm:"foreignKey:GroupId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Token       string      `json:"token" gorm:"uniqueIndex;not null"`
+	CreatedById uint        `json:"createdById,omitempty"`
+	ExpiresAt   time.Time   `json:"expiresAt"`
+	MaxUses     int         `json:"maxUses"`
+	Uses        int         `json:"uses" gorm:"default:0"`
+	RevokedAt   *time.Time  `json:"revokedAt"`
+}
+
+
+// InviteRedemption records which invite a member used to join a group
+type InviteRedemption struct {
+	Base
+	InviteId    uint        `json:"inviteId" gorm:"index"`
+	Invite      GroupInvite `json:"-" gorm:"foreignKey:InviteId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	GroupId     uint        `json:"groupId" gorm:"index"`
+	UserId      uint        `json:"userId,omitempty"`
+	User        User        `json:"-" gorm:"foreignKey:UserId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Author      *Author     `json:"author,omitempty" gorm:"-"`
+}
+
+
+type IInviteRepository interface {
+	CreateInvite(invite *GroupInvite) (*GroupInvite, error)
+	GetInviteById(id int) (*GroupInvite, error)
+	GetInviteByToken(token string) (*GroupInvite, error)
+	GetInvitesByGroupId(groupId int) ([]GroupInvite, error)
+	RevokeInvite(id int) error
+	GetRedemptionsByInviteId(inviteId int) ([]InviteRedemption, error)
+}
+
+
+type IInviteService interface {
+	CreateInvite(userId, groupId int, expiresIn time.Duration, maxUses int) (*GroupInvite, error)
+	GetInvitesByGroupId(userId, groupId int) ([]GroupInvite, error)
+	RevokeInvite(userId, groupId, inviteId int) error
+	GetRedemptionsByInviteId(userId, groupId, inviteId int) ([]InviteRedemption, error)
+}
+
+
+// IsUsable reports whether the invite can still be redeemed. A MaxUses of zero means unlimited.
+func (i *GroupInvite) IsUsable(now time.Time) bool {
+	if i.RevokedAt != nil || !i.ExpiresAt.After(now) {
+		return false
+	}
+
+	return i.MaxUses == 0 || i.Uses < i.MaxUses
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+
+const (
+	PendingJoinRequest  JoinRequestStatus = "pending"
+	ApprovedJoinRequest JoinRequestStatus = "approved"
+	RejectedJoinRequest JoinRequestStatus = "rejected"
+)
+
+
+type JoinRequestStatus string
+
+
+// JoinRequest is a pending membership for groups that require approval.
+// The requester only gets a UserGroup row once an admin approves it. In anonymous
+// groups the user IDs are left out and Author carries the requester's pseudonym.
+type JoinRequest struct {
+	Base
+	GroupId       uint              `json:"groupId" gorm:"index"`
+	Group         Group             `json:"-" gorm:"foreignKey:GroupId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	UserId        uint              `json:"userId,omitempty" gorm:"index"`
+	User          User              `json:"-" gorm:"foreignKey:UserId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Author        *Author           `json:"author,omitempty" gorm:"-"`
+	Status        JoinRequestStatus `json:"status" gorm:"type:varchar(20);default:pending"`
+	ReviewedById  *uint             `json:"reviewedById,omitempty"`
+	ReviewMessage string            `json:"reviewMessage"`
+	ReviewedAt    *time.Time        `json:"reviewedAt"`
+}
+
+
+type IJoinRequestRepository interface {
+	CreateJoinRequest(request *JoinRequest) (*JoinRequest, error)
+	GetJoinRequestById(id int) (*JoinRequest, error)
+	GetPendingJoinRequest(groupId, userId int) (*JoinRequest, error)
+	GetJoinRequestsByGroupId(groupId int, status JoinRequestStatus) ([]JoinRequest, error)
+	ApproveJoinRequest(request *JoinRequest, reviewerId int, message string) error
+	RejectJoinRequest(request *JoinRequest, reviewerId int, message string) error
+}
+
+
+type IJoinRequestService interface {
+	GetJoinRequestsByGroupId(userId, groupId int, status JoinRequestStatus) ([]JoinRequest, error)
+	ApproveJoinRequest(userId, groupId, requestId int, message string) error
+	RejectJoinRequest(userId, groupId, requestId int, message string) error
+}
@@ -0,0 +1,28 @@
+package models
+
+
+const (
+	JoinMembershipEvent  MembershipEventType = "join"
+	LeaveMembershipEvent MembershipEventType = "leave"
+	KickMembershipEvent  MembershipEventType = "kick"
+	BanMembershipEvent   MembershipEventType = "ban"
+	UnbanMembershipEvent MembershipEventType = "unban"
+)
+
+
+type MembershipEventType string
+
+
+// MembershipEvent is one entry in a group's membership history. ActorId is the
+// member who caused the change, which is the user themselves for joins and leaves.
+// Anonymous groups see Member and Actor pseudonyms in place of the user IDs.
+type MembershipEvent struct {
+	Base
+	GroupId  uint                `json:"groupId" gorm:"index"`
+	Group    Group               `json:"-" gorm:"foreignKey:GroupId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	UserId   uint                `json:"userId,omitempty"`
+	Member   *Author             `json:"member,omitempty" gorm:"-"`
+	ActorId  uint                `json:"actorId,omitempty"`
+	Actor    *Author             `json:"actor,omitempty" gorm:"-"`
+	Type     MembershipEventType `json:"type" gorm:"type:varchar(20)"`
+}
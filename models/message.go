@@ -1,33 +1,107 @@
 package models
 
+import (
+	"time"
+)
+
+
+// RemovedMessageContent replaces the content of a message removed by a moderator
+const RemovedMessageContent = "message removed by a moderator"
+
+
+const previewLength = 100
+
 
 type Message struct {
 	Base
 	Content  		string 		`json:"content"`
-	ContentType     string      `gorm:"not null" json"contentType"`
-	AttachmentUrl  *string		`gorm:"type:text" json"attachmentUrl`
-	GroupId         uint 		`json:"_"`
-	Group			Group       `gorm:"foreignKey:GroupId; constraint:OnUpdate:CASCADE, OnDelete:CASCADE" json"-"`
+	ContentType     string      `gorm:"not null" json:"ContentType"`
+	Seq             int64       `gorm:"not null;default:0" json:"seq"`
+	AttachmentUrl  *string		`gorm:"type:text" json:"AttachmentUrl"`
+	AttachmentId   *uint        `gorm:"uniqueIndex" json:"attachmentId"`
+	Attachment     *Attachment  `gorm:"foreignKey:AttachmentId;constraint:OnUpdate:CASCADE,OnDelete:SET NULL" json:"attachment,omitempty"`
+	Payload         JSON        `gorm:"type:jsonb" json:"payload,omitempty"`
+	Poll           *Poll        `gorm:"foreignKey:MessageId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"poll,omitempty"`
+	GroupId         uint 		`json:"groupId"`
+	Group			Group       `gorm:"foreignKey:GroupId; constraint:OnUpdate:CASCADE, OnDelete:CASCADE" json:"-"`
 	UserId          uint        `json:"-"`
-	User  			User 		`gorm:"foreignKey:UserId; constraint:OnUpdate:CASCADE, OnDelete:SET NULL"`
+	User  			User 		`gorm:"foreignKey:UserId;constraint:OnUpdate:CASCADE,OnDelete:SET NULL" json:"-"`
+	Author         *Author      `gorm:"-" json:"author,omitempty"`
+	RemovedAt      *time.Time   `json:"removedAt"`
+	RemovedById    *uint        `json:"removedById"`
+	ParentId       *uint        `gorm:"index" json:"parentId"`
+	Parent         *Message     `gorm:"foreignKey:ParentId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	ReplyCount      int         `gorm:"not null;default:0" json:"replyCount"`
+	LastReplyAt    *time.Time   `json:"lastReplyAt"`
+	Edited          bool        `gorm:"not null;default:false" json:"edited"`
+	EditedAt       *time.Time   `json:"editedAt"`
+	ScheduledMessageId *uint    `gorm:"uniqueIndex" json:"scheduledMessageId,omitempty"`
+	Reactions      []ReactionSummary `gorm:"-" json:"reactions"`
+	Mentions       []Notification    `gorm:"-" json:"-"`
+}
+
+
+// ReadReceipt tells a group that a member has read up to a message. UserId is
+// left out in anonymous groups, where the reader is shown by pseudonym.
+type ReadReceipt struct {
+	UserId    *uint  `json:"userId,omitempty"`
+	GroupId   uint   `json:"-"`
+	MessageId uint   `json:"messageId"`
+	Seq       int64  `json:"seq"`
+	Reader    Author `json:"reader"`
+}
+
+
+// IsReply reports whether the message belongs to another message's thread
+func (m *Message) IsReply() bool {
+	return m.ParentId != nil
+}
+
+
+// HasAttachment reports whether the message carries a file, either uploaded or
+// linked by URL before uploads existed
+func (m *Message) HasAttachment() bool {
+	return m.AttachmentId != nil || m.AttachmentUrl != nil
+}
+
+
+// IsRemoved reports whether a moderator has replaced the message with a tombstone
+func (m *Message) IsRemoved() bool {
+	return m.RemovedAt != nil
 }
 
 
 type IMessageRepository interface {
 	SendMessage(message *Message) (*Message, error)
-	GetMessagesInGroup(groupId, limit, page int) ([]Message, error)
-	GetUserMessagesInGroup(userId, groupId, limit, page int) ([]Message, error)
+	GetMessagesInGroup(groupId int, query PageQuery) (*MessagePage, error)
+	GetUserMessagesInGroup(userId, groupId int, query PageQuery) (*MessagePage, error)
+	GetReplies(parentId int, query PageQuery) (*MessagePage, error)
 	DeleteMessage(id, userId, groupId int) error
+	RemoveMessage(message *Message, actorId int, reason string) (*Message, error)
 	UpdateMessage(message Message) error
 	GetMessageById(id int) (*Message, error)
+	GetRevisions(messageId int) ([]MessageRevision, error)
+	SearchMessages(userId int, search MessageSearch) ([]SearchResult, error)
 }
 
 
 type IMessageService interface {
 	SendMessage(message *Message) (*Message, error)
-	GetMessagesInGroup(groupId, limit, page int) ([]Message, error)
-	GetUserMessagesInGroup(userId, groupId, limit, page int) ([]Message, error)
-	DeleteMessage(id, userId, groupId int) error
+	GetMessagesInGroup(userId, groupId int, query PageQuery) (*MessagePage, error)
+	GetUserMessagesInGroup(userId, groupId int, query PageQuery) (*MessagePage, error)
+	GetThread(userId, messageId int, query PageQuery) (*MessagePage, error)
+	DeleteMessage(id, userId, groupId int, reason string) (*Message, error)
 	UpdateMessage(message Message) error
-	GetMessageById(id int) (*Message, error)
-}
\ No newline at end of file
+	GetMessageById(userId, id int) (*Message, error)
+	MarkRead(userId, messageId int) (*ReadReceipt, error)
+	GetAuthor(groupId, userId int) (*Author, error)
+	GetSeenCount(userId, messageId int) (int64, error)
+	GetRevisions(userId, messageId int) ([]MessageRevision, error)
+	SearchMessages(userId int, search MessageSearch) ([]SearchResult, error)
+}
+
+
+// Cursor returns the message's position in its group's history
+func (m *Message) Cursor() Cursor {
+	return Cursor{ Key: m.Seq, ID: m.ID }
+}
@@ -0,0 +1,10 @@
+package models
+
+
+// MessageRevision keeps the content a message had before one of its edits
+type MessageRevision struct {
+	Base
+	MessageId   uint    `json:"messageId" gorm:"index"`
+	Message     Message `json:"-" gorm:"foreignKey:MessageId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Content     string  `json:"content"`
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+
+const (
+	BanModerationAction               ModerationAction = "ban"
+	UnbanModerationAction             ModerationAction = "unban"
+	MuteModerationAction              ModerationAction = "mute"
+	UnmuteModerationAction            ModerationAction = "unmute"
+	KickModerationAction              ModerationAction = "kick"
+	DeleteMessageModerationAction     ModerationAction = "deleteMessage"
+	UpdateGroupModerationAction       ModerationAction = "updateGroup"
+	ChangeRoleModerationAction        ModerationAction = "changeRole"
+	TransferOwnershipModerationAction ModerationAction = "transferOwnership"
+	PinMessageModerationAction        ModerationAction = "pinMessage"
+	UnpinMessageModerationAction      ModerationAction = "unpinMessage"
+	ResolvePseudonymModerationAction  ModerationAction = "resolvePseudonym"
+)
+
+
+var ErrModerationLogAppendOnly = errors.New("moderation log entries cannot be changed")
+
+
+type ModerationAction string
+
+
+// ModerationLog is an append-only record of a moderation action in a group.
+// Entries are written in the same transaction as the action they describe.
+// Anonymous groups see Actor and Target pseudonyms in place of the user IDs.
+type ModerationLog struct {
+	Base
+	GroupId         uint             `json:"groupId" gorm:"index"`
+	Group           Group            `json:"-" gorm:"foreignKey:GroupId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	ActorId         uint             `json:"actorId,omitempty" gorm:"index"`
+	Actor           *Author          `json:"actor,omitempty" gorm:"-"`
+	TargetUserId    *uint            `json:"targetUserId,omitempty" gorm:"index"`
+	Target          *Author          `json:"target,omitempty" gorm:"-"`
+	TargetMessageId *uint            `json:"targetMessageId"`
+	Action          ModerationAction `json:"action" gorm:"type:varchar(40);index"`
+	Reason          string           `json:"reason"`
+	Before          JSON             `json:"before" gorm:"type:jsonb"`
+	After           JSON             `json:"after" gorm:"type:jsonb"`
+}
+
+
+type ModerationLogFilter struct {
+	Action       ModerationAction
+	ActorId      int
+	TargetUserId int
+	From         *time.Time
+	To           *time.Time
+}
+
+
+func (l *ModerationLog) BeforeUpdate(tx *gorm.DB) error {
+	return ErrModerationLogAppendOnly
+}
+
+
+func (l *ModerationLog) BeforeDelete(tx *gorm.DB) error {
+	return ErrModerationLogAppendOnly
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+
+const (
+	MentionNotification NotificationType = "mention"
+)
+
+
+type NotificationType string
+
+
+// Notification is an inbox entry for a user, such as being @mentioned in a message
+type Notification struct {
+	Base
+	UserId    uint             `json:"userId" gorm:"index:idx_notifications_user_read"`
+	User      User             `json:"-" gorm:"foreignKey:UserId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	GroupId   uint             `json:"groupId"`
+	Group     Group            `json:"-" gorm:"foreignKey:GroupId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	MessageId uint             `json:"messageId"`
+	Message   Message          `json:"-" gorm:"foreignKey:MessageId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	ActorId   uint             `json:"actorId"`
+	Type      NotificationType `json:"type" gorm:"type:varchar(20)"`
+	Preview   string           `json:"preview"`
+	ReadAt    *time.Time       `json:"readAt" gorm:"index:idx_notifications_user_read"`
+}
+
+
+type INotificationRepository interface {
+	CreateNotifications(notifications []Notification) error
+	GetNotifications(userId int, unreadOnly bool, query PageQuery) (*NotificationPage, error)
+	MarkRead(userId, id int) error
+	MarkAllRead(userId int) error
+	GetUnreadCount(userId int) (int64, error)
+}
+
+
+type INotificationService interface {
+	GetNotifications(userId int, unreadOnly bool, query PageQuery) (*NotificationPage, error)
+	MarkRead(userId, id int) error
+	MarkAllRead(userId int) error
+	GetUnreadCount(userId int) (int64, error)
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+
+var ErrInvalidCursor = errors.New("invalid pagination cursor")
+
+
+// Cursor marks a position in a keyset-paginated list. Key is the sort column
+// and ID breaks ties between rows that share a key.
+type Cursor struct {
+	Key int64 `json:"k"`
+	ID  uint  `json:"i"`
+}
+
+
+// PageQuery selects a page of results. After walks forward from a cursor and
+// Before walks backward. Without either the list starts from its default end.
+type PageQuery struct {
+	Before *Cursor
+	After  *Cursor
+	Limit  int
+}
+
+
+// MessagePage is a page of messages. NextCursor continues in the same direction
+// as the request and is empty on the last page.
+type MessagePage struct {
+	Items      []Message `json:"items"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+}
+
+
+type UserPage struct {
+	Items      []User `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+
+type GroupPage struct {
+	Items      []Group `json:"items"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+}
+
+
+type NotificationPage struct {
+	Items      []Notification `json:"items"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+
+// Encode returns the opaque string form of the cursor handed to clients
+func (c Cursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+
+func DecodeCursor(value string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	cursor := &Cursor{}
+	if err := json.Unmarshal(raw, cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
@@ -0,0 +1,27 @@
+package models
+
+
+// PinnedMessage keeps a message at the top of its group. A message can be pinned
+// once. Who pinned it is left out in anonymous groups.
+type PinnedMessage struct {
+	Base
+	GroupId    uint    `json:"groupId" gorm:"index"`
+	Group      Group   `json:"-" gorm:"foreignKey:GroupId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	MessageId  uint    `json:"messageId" gorm:"uniqueIndex"`
+	Message    Message `json:"message" gorm:"foreignKey:MessageId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	PinnedById uint    `json:"pinnedById,omitempty"`
+}
+
+
+type IPinRepository interface {
+	PinMessage(pin *PinnedMessage, maxPins int) (*PinnedMessage, error)
+	UnpinMessage(actorId int, message *Message) error
+	GetPinnedMessages(groupId int) ([]PinnedMessage, error)
+}
+
+
+type IPinService interface {
+	PinMessage(userId, messageId int) (*PinnedMessage, error)
+	UnpinMessage(userId, messageId int) (*Message, error)
+	GetPinnedMessages(userId, groupId int) ([]PinnedMessage, error)
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"time"
+)
+
+
+// Poll is the question and options of a poll message. Votes can be changed until
+// ClosesAt, if there is one. In anonymous polls nobody sees who voted for what.
+// The tallies are filled in when the poll is loaded.
+type Poll struct {
+	Base
+	MessageId      uint         `json:"messageId" gorm:"uniqueIndex"`
+	GroupId        uint         `json:"-" gorm:"index"`
+	Question       string       `json:"question"`
+	MultipleChoice bool         `json:"multipleChoice"`
+	Anonymous      bool         `json:"anonymous"`
+	ClosesAt       *time.Time   `json:"closesAt"`
+	Options        []PollOption `json:"options" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Closed         bool         `json:"closed" gorm:"-"`
+	TotalVoters    int          `json:"totalVoters" gorm:"-"`
+	MyVotes        []uint       `json:"myVotes" gorm:"-"`
+}
+
+
+// PollOption is one of a poll's answers. Voters is left out of anonymous polls.
+type PollOption struct {
+	Base
+	PollId   uint     `json:"-" gorm:"index"`
+	Position int      `json:"position"`
+	Text     string   `json:"text"`
+	Votes    int      `json:"votes" gorm:"-"`
+	Voters   []Author `json:"voters,omitempty" gorm:"-"`
+}
+
+
+// PollVote is a member's choice of an option. Members of multiple choice polls
+// hold one vote per option they picked.
+type PollVote struct {
+	Base
+	PollId   uint       `json:"-" gorm:"index"`
+	Poll     Poll       `json:"-" gorm:"foreignKey:PollId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	OptionId uint       `json:"optionId" gorm:"uniqueIndex:idx_poll_votes_choice"`
+	Option   PollOption `json:"-" gorm:"foreignKey:OptionId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	UserId   uint       `json:"-" gorm:"uniqueIndex:idx_poll_votes_choice;index"`
+	User     User       `json:"-" gorm:"foreignKey:UserId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+
+// NewPoll sets up the poll a poll message's payload describes
+func (p *PollPayload) NewPoll(groupId uint) *Poll {
+	poll := &Poll{
+		GroupId: groupId,
+		Question: p.Question,
+		MultipleChoice: p.MultipleChoice,
+		Anonymous: p.Anonymous,
+		ClosesAt: p.ClosesAt,
+	}
+
+	for i, text := range p.Options {
+		poll.Options = append(poll.Options, PollOption{ Position: i, Text: text })
+	}
+
+	return poll
+}
+
+
+// IsClosed reports whether the poll has stopped taking votes
+func (p *Poll) IsClosed(now time.Time) bool {
+	return p.ClosesAt != nil && !now.Before(*p.ClosesAt)
+}
+
+
+// HasOption reports whether the option belongs to the poll
+func (p *Poll) HasOption(optionId uint) bool {
+	for _, option := range p.Options {
+		if option.ID == optionId {
+			return true
+		}
+	}
+
+	return false
+}
+
+
+// Tally counts the votes cast in the poll as the viewer sees them. Voters are
+// listed by the authors given for them, which are left out for anonymous polls.
+func (p *Poll) Tally(votes []PollVote, viewerId uint, authors map[uint]Author, now time.Time) {
+	options := map[uint]*PollOption{}
+	for i := range p.Options {
+		p.Options[i].Votes = 0
+		p.Options[i].Voters = nil
+		options[p.Options[i].ID] = &p.Options[i]
+	}
+
+	voters := map[uint]bool{}
+	p.MyVotes = []uint{}
+
+	for _, vote := range votes {
+		option, ok := options[vote.OptionId]
+		if !ok || vote.PollId != p.ID {
+			continue
+		}
+
+		option.Votes++
+		voters[vote.UserId] = true
+
+		if vote.UserId == viewerId {
+			p.MyVotes = append(p.MyVotes, vote.OptionId)
+		}
+
+		if author, ok := authors[vote.UserId]; ok && !p.Anonymous {
+			option.Voters = append(option.Voters, author)
+		}
+	}
+
+	p.TotalVoters = len(voters)
+	p.Closed = p.IsClosed(now)
+}
+
+
+type IPollRepository interface {
+	GetPollByMessageId(messageId int) (*Poll, error)
+	GetPollsByMessageIds(messageIds []uint) ([]Poll, error)
+	GetPollVotes(pollIds []uint) ([]PollVote, error)
+	ReplaceVotes(poll *Poll, userId int, optionIds []uint, now time.Time) error
+}
+
+
+type IPollService interface {
+	GetPoll(userId, messageId int) (*Poll, error)
+	Vote(userId, messageId int, optionIds []uint) (*Poll, error)
+	RetractVote(userId, messageId int) (*Poll, error)
+}
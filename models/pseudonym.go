@@ -0,0 +1,102 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+
+// PseudonymAvatarCount is how many avatars pseudonyms are drawn from, matching
+// the range of User.ImageNum
+const PseudonymAvatarCount = 16
+
+
+var pseudonymAdjectives = []string{
+	"Amber", "Ashen", "Brisk", "Calm", "Cobalt", "Crimson", "Dusky", "Echoing",
+	"Faded", "Gentle", "Gilded", "Hollow", "Hushed", "Idle", "Jade", "Lunar",
+	"Misty", "Nimble", "Obsidian", "Pale", "Quiet", "Restless", "Silver", "Smoky",
+	"Solemn", "Stray", "Swift", "Tidal", "Umber", "Velvet", "Wandering", "Wry",
+}
+
+
+var pseudonymAnimals = []string{
+	"Badger", "Bat", "Crane", "Crow", "Eel", "Falcon", "Fox", "Gecko",
+	"Hare", "Heron", "Ibis", "Jackal", "Lynx", "Marten", "Mole", "Moth",
+	"Newt", "Ocelot", "Otter", "Owl", "Panther", "Raven", "Seal", "Shrike",
+	"Sparrow", "Stoat", "Swan", "Tapir", "Toad", "Viper", "Weasel", "Wren",
+}
+
+
+// Author is how the sender of a message is shown to other members. In anonymous
+// groups only the pseudonym is filled in and UserId is left out.
+type Author struct {
+	UserId       *uint  `json:"userId,omitempty"`
+	Name         string `json:"name"`
+	Avatar       int    `json:"avatar"`
+	Pseudonymous bool   `json:"pseudonymous"`
+}
+
+
+// BeforeCreate gives every group a secret salt for deriving pseudonyms, so they
+// can't be guessed from user IDs
+func (g *Group) BeforeCreate(tx *gorm.DB) error {
+	if err := g.Base.BeforeCreate(tx); err != nil {
+		return err
+	}
+
+	if g.PseudonymSalt == "" {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		g.PseudonymSalt = hex.EncodeToString(salt)
+	}
+
+	return nil
+}
+
+
+// Pseudonym derives the member's name and avatar in this group. It is stable for
+// the lifetime of the group, including across leaving and rejoining.
+func (g *Group) Pseudonym(userId uint) Author {
+	mac := hmac.New(sha256.New, []byte(g.PseudonymSalt))
+	mac.Write([]byte(strconv.FormatUint(uint64(userId), 10)))
+	sum := mac.Sum(nil)
+
+	return Author{
+		Name: fmt.Sprintf("%s %s %04d",
+			pseudonymAdjectives[int(sum[0])%len(pseudonymAdjectives)],
+			pseudonymAnimals[int(sum[1])%len(pseudonymAnimals)],
+			binary.BigEndian.Uint16(sum[2:4])%10000),
+		Avatar: int(sum[4]) % PseudonymAvatarCount,
+		Pseudonymous: true,
+	}
+}
+
+
+// AuthorOf returns how the user appears to other members of the group
+func (g *Group) AuthorOf(user User) Author {
+	if g.IsAnonymous() {
+		return g.Pseudonym(user.ID)
+	}
+
+	return NewAuthor(user)
+}
+
+
+// NewAuthor shows the user under their own account
+func NewAuthor(user User) Author {
+	id := user.ID
+	return Author{
+		UserId: &id,
+		Name: user.UserName,
+		Avatar: user.ImageNum,
+	}
+}
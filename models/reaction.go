@@ -0,0 +1,35 @@
+package models
+
+
+// Reaction is a single user's emoji on a message. A user can react with several
+// different emoji but only once with each.
+type Reaction struct {
+	Base
+	MessageId uint    `json:"messageId" gorm:"uniqueIndex:idx_reactions_message_user_emoji"`
+	Message   Message `json:"-" gorm:"foreignKey:MessageId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	UserId    uint    `json:"userId" gorm:"uniqueIndex:idx_reactions_message_user_emoji"`
+	User      User    `json:"-" gorm:"foreignKey:UserId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Emoji     string  `json:"emoji" gorm:"type:varchar(64);uniqueIndex:idx_reactions_message_user_emoji"`
+}
+
+
+// ReactionSummary aggregates the reactions to a message for one emoji.
+// Reacted is set when the requesting user is among them.
+type ReactionSummary struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+
+type IReactionRepository interface {
+	AddReaction(reaction *Reaction) error
+	RemoveReaction(messageId, userId int, emoji string) error
+	GetReactionSummaries(messageIds []uint, userId int) (map[uint][]ReactionSummary, error)
+}
+
+
+type IReactionService interface {
+	AddReaction(userId, messageId int, emoji string) (*Message, error)
+	RemoveReaction(userId, messageId int, emoji string) (*Message, error)
+}
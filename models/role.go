@@ -0,0 +1,92 @@
+package models
+
+
+const (
+	GroupOwnerRole     GroupRole = "owner"
+	GroupAdminRole     GroupRole = "admin"
+	GroupModeratorRole GroupRole = "moderator"
+	GroupMemberRole    GroupRole = "member"
+)
+
+
+const (
+	UpdateGroupPermission          GroupPermission = "updateGroup"
+	DeleteGroupPermission          GroupPermission = "deleteGroup"
+	BanMembersPermission           GroupPermission = "banMembers"
+	ManageRolesPermission          GroupPermission = "manageRoles"
+	ManageInvitesPermission        GroupPermission = "manageInvites"
+	ReviewJoinRequestsPermission   GroupPermission = "reviewJoinRequests"
+	KickMembersPermission          GroupPermission = "kickMembers"
+	TransferOwnershipPermission    GroupPermission = "transferOwnership"
+	ViewMembershipEventsPermission GroupPermission = "viewMembershipEvents"
+	MuteMembersPermission          GroupPermission = "muteMembers"
+	ViewSanctionsPermission        GroupPermission = "viewSanctions"
+	ViewModerationLogPermission    GroupPermission = "viewModerationLog"
+	DeleteMessagesPermission       GroupPermission = "deleteMessages"
+	PinMessagesPermission          GroupPermission = "pinMessages"
+	ViewRevisionsPermission        GroupPermission = "viewRevisions"
+	ResolvePseudonymsPermission    GroupPermission = "resolvePseudonyms"
+)
+
+
+type GroupRole string
+
+
+type GroupPermission string
+
+
+// groupRolePermissions is the permission matrix for group memberships.
+// A role may only act on members whose role it outranks.
+var groupRolePermissions = map[GroupRole][]GroupPermission{
+	GroupOwnerRole: {
+		UpdateGroupPermission, DeleteGroupPermission, BanMembersPermission, ManageRolesPermission,
+		ManageInvitesPermission, ReviewJoinRequestsPermission, KickMembersPermission,
+		TransferOwnershipPermission, ViewMembershipEventsPermission, MuteMembersPermission,
+		ViewSanctionsPermission, ViewModerationLogPermission, DeleteMessagesPermission,
+		PinMessagesPermission, ViewRevisionsPermission, ResolvePseudonymsPermission,
+	},
+	GroupAdminRole: {
+		UpdateGroupPermission, BanMembersPermission, ManageRolesPermission,
+		ManageInvitesPermission, ReviewJoinRequestsPermission, KickMembersPermission,
+		ViewMembershipEventsPermission, MuteMembersPermission, ViewSanctionsPermission,
+		ViewModerationLogPermission, DeleteMessagesPermission, PinMessagesPermission,
+		ViewRevisionsPermission, ResolvePseudonymsPermission,
+	},
+	GroupModeratorRole: {
+		BanMembersPermission, KickMembersPermission, ViewMembershipEventsPermission,
+		MuteMembersPermission, ViewSanctionsPermission, DeleteMessagesPermission,
+		PinMessagesPermission, ViewRevisionsPermission, ResolvePseudonymsPermission,
+	},
+	GroupMemberRole: {},
+}
+
+
+var groupRoleRanks = map[GroupRole]int{
+	GroupOwnerRole:     4,
+	GroupAdminRole:     3,
+	GroupModeratorRole: 2,
+	GroupMemberRole:    1,
+}
+
+
+func (r GroupRole) IsValid() bool {
+	_, ok := groupRoleRanks[r]
+	return ok
+}
+
+
+// Can reports whether the role has been granted the permission
+func (r GroupRole) Can(permission GroupPermission) bool {
+	for _, p := range groupRolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+
+// Outranks reports whether the role sits strictly above other
+func (r GroupRole) Outranks(other GroupRole) bool {
+	return groupRoleRanks[r] > groupRoleRanks[other]
+}
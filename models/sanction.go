@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+
+const (
+	BanSanction  SanctionType = "ban"
+	MuteSanction SanctionType = "mute"
+)
+
+
+type SanctionType string
+
+
+// Sanction records a ban or mute against a group member. A nil ExpiresAt means
+// the sanction lasts until a moderator lifts it. In anonymous groups the user IDs
+// are left out and Author and Actor carry pseudonyms.
+type Sanction struct {
+	Base
+	GroupId    uint         `json:"groupId" gorm:"index"`
+	Group      Group        `json:"-" gorm:"foreignKey:GroupId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	UserId     uint         `json:"userId,omitempty" gorm:"index"`
+	User       User         `json:"-" gorm:"foreignKey:UserId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Author     *Author      `json:"author,omitempty" gorm:"-"`
+	ActorId    uint         `json:"actorId,omitempty"`
+	Actor      *Author      `json:"actor,omitempty" gorm:"-"`
+	Type       SanctionType `json:"type" gorm:"type:varchar(20)"`
+	Reason     string       `json:"reason"`
+	ExpiresAt  *time.Time   `json:"expiresAt"`
+	LiftedAt   *time.Time   `json:"liftedAt"`
+	LiftedById *uint        `json:"liftedById,omitempty"`
+}
+
+
+func (s *Sanction) IsActive(now time.Time) bool {
+	return s.LiftedAt == nil && (s.ExpiresAt == nil || s.ExpiresAt.After(now))
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+)
+
+
+const (
+	PendingScheduledMessage   ScheduledMessageStatus = "pending"
+	SendingScheduledMessage   ScheduledMessageStatus = "sending"
+	SentScheduledMessage      ScheduledMessageStatus = "sent"
+	FailedScheduledMessage    ScheduledMessageStatus = "failed"
+	CancelledScheduledMessage ScheduledMessageStatus = "cancelled"
+)
+
+
+type ScheduledMessageStatus string
+
+
+// ScheduledMessage is a message composed now to be sent at SendAt. The dispatcher
+// claims it by moving it to sending, and the message it sends carries its ID
+// under a unique index, so a claim interrupted by a restart can be retried
+// without the message going out twice. Only pending messages can be changed.
+type ScheduledMessage struct {
+	Base
+	UserId       uint                   `json:"-" gorm:"index"`
+	User         User                   `json:"-" gorm:"foreignKey:UserId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	GroupId      uint                   `json:"groupId" gorm:"index"`
+	Group        Group                  `json:"-" gorm:"foreignKey:GroupId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Content      string                 `json:"content"`
+	ContentType  string                 `json:"contentType" gorm:"not null"`
+	AttachmentId *uint                  `json:"attachmentId"`
+	ParentId     *uint                  `json:"parentId"`
+	Payload      JSON                   `json:"payload,omitempty" gorm:"type:jsonb"`
+	SendAt       time.Time              `json:"sendAt" gorm:"index"`
+	Status       ScheduledMessageStatus `json:"status" gorm:"type:varchar(20);default:pending;index"`
+	ClaimedAt    *time.Time             `json:"-"`
+	Attempts     int                    `json:"-" gorm:"not null;default:0"`
+	MessageId    *uint                  `json:"messageId"`
+	Failure      string                 `json:"failure,omitempty"`
+}
+
+
+// ToMessage is the message the scheduled one is sent as
+func (s *ScheduledMessage) ToMessage() *Message {
+	id := s.ID
+	return &Message{
+		Content: s.Content,
+		ContentType: s.ContentType,
+		AttachmentId: s.AttachmentId,
+		ParentId: s.ParentId,
+		Payload: s.Payload,
+		GroupId: s.GroupId,
+		UserId: s.UserId,
+		ScheduledMessageId: &id,
+	}
+}
+
+
+type IScheduledMessageRepository interface {
+	CreateScheduledMessage(scheduled *ScheduledMessage) (*ScheduledMessage, error)
+	GetScheduledMessage(userId, id int) (*ScheduledMessage, error)
+	GetScheduledMessages(userId, groupId int) ([]ScheduledMessage, error)
+	UpdateScheduledMessage(scheduled *ScheduledMessage) error
+	CancelScheduledMessage(userId, id int) error
+	ClaimDueScheduledMessages(now, staleBefore time.Time, limit int) ([]ScheduledMessage, error)
+	GetMessageByScheduledMessageId(id uint) (*Message, error)
+	CompleteScheduledMessage(scheduled *ScheduledMessage, messageId *uint, failure string) error
+	ReleaseScheduledMessage(scheduled *ScheduledMessage, retryAt time.Time) error
+}
+
+
+type IScheduledMessageService interface {
+	ScheduleMessage(scheduled *ScheduledMessage) (*ScheduledMessage, error)
+	GetScheduledMessage(userId, id int) (*ScheduledMessage, error)
+	GetScheduledMessages(userId, groupId int) ([]ScheduledMessage, error)
+	UpdateScheduledMessage(userId, id int, changes ScheduledMessage) (*ScheduledMessage, error)
+	CancelScheduledMessage(userId, id int) error
+	// DispatchDue sends every scheduled message that is due, handing each one sent to
+	// publish, and reports how many were sent
+	DispatchDue(publish func(message *Message)) (int, error)
+}
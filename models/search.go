@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+
+// MessageSearch describes a full-text search over messages. A zero GroupId searches
+// every group the caller is an active member of.
+type MessageSearch struct {
+	Query         string
+	GroupId       int
+	AuthorId      int
+	ContentType   string
+	From          *time.Time
+	To            *time.Time
+	HasAttachment *bool
+	Limit         int
+	Page          int
+}
+
+
+// SearchResult is a matching message with its relevance and a highlighted snippet.
+// Snippet is HTML: the message text escaped, with matches wrapped in <mark> tags.
+type SearchResult struct {
+	Message Message `json:"message"`
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"io"
+	"time"
+)
+
+
+// UploadSession is a resumable upload. Chunks are stored as they arrive, so a
+// client that loses its connection can ask for Offset and carry on from there.
+// Once Offset reaches Size the chunks are joined into an attachment. Sessions
+// that see no activity before ExpiresAt are collected along with their chunks.
+// CompletingAt is set while one request is joining the chunks.
+type UploadSession struct {
+	Base
+	UserId       uint          `json:"-" gorm:"index"`
+	User         User          `json:"-" gorm:"foreignKey:UserId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Kind         string        `json:"kind" gorm:"type:varchar(20)"`
+	FileName     string        `json:"fileName"`
+	DeclaredType string        `json:"mimeType"`
+	Size         int64         `json:"size"`
+	Offset       int64         `json:"offset"`
+	ExpiresAt    time.Time     `json:"expiresAt" gorm:"index"`
+	AttachmentId *uint         `json:"attachmentId,omitempty"`
+	CompletingAt *time.Time    `json:"-"`
+	Attachment   *Attachment   `json:"attachment,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	Chunks       []UploadChunk `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+
+// UploadChunk is one stored piece of an upload, starting at Offset. Offsets are
+// unique per session so two requests can't both append at the same place.
+type UploadChunk struct {
+	Base
+	UploadSessionId uint   `json:"-" gorm:"uniqueIndex:idx_upload_chunks_offset"`
+	Offset          int64  `json:"offset" gorm:"uniqueIndex:idx_upload_chunks_offset"`
+	Size            int64  `json:"size"`
+	StorageKey      string `json:"-"`
+}
+
+
+// IsComplete reports whether the upload has been turned into an attachment
+func (s *UploadSession) IsComplete() bool {
+	return s.AttachmentId != nil
+}
+
+
+type IUploadRepository interface {
+	CreateUploadSession(session *UploadSession) (*UploadSession, error)
+	GetUploadSession(userId, id int) (*UploadSession, error)
+	AppendUploadChunk(session *UploadSession, chunk *UploadChunk, expiresAt time.Time) error
+	GetUploadChunks(sessionId uint) ([]UploadChunk, error)
+	ClaimUploadSession(session *UploadSession, now, staleBefore time.Time) error
+	ReleaseUploadSession(session *UploadSession) error
+	CompleteUploadSession(session *UploadSession, attachment *Attachment, expiresAt time.Time) error
+	DeleteUploadSession(sessionId uint) error
+	GetExpiredUploadSessions(now time.Time, limit int) ([]UploadSession, error)
+}
+
+
+type IUploadService interface {
+	CreateUpload(userId int, upload Upload) (*UploadSession, error)
+	GetUpload(userId, id int) (*UploadSession, error)
+	AppendChunk(userId, id int, offset int64, body io.Reader) (*UploadSession, error)
+	CancelUpload(userId, id int) error
+	CollectExpiredUploads() (int, error)
+}
@@ -25,7 +25,7 @@ type User struct {
 	ImageNum		int 		 `json:"image"`
 	Ban 			bool  		 `json:"ban" gorm:"type:bool;default:false"`
 	TotpEnabled     bool  		 `json:"totpEnabled" gorm:"type:bool;default:false"`
-	TotpSecret      string 		 `json:"_"`
+	TotpSecret      string 		 `json:"-"`
 	OneTimePassword string   	 `json:"-"`
 	OneTimePasswordExpiry time.Time `json:"oneTimePasswordExpiry"`
 	OneTimePasswordValid  bool      `json:"oneTimePasswordValid" gorm:"type:bool;default:false"`
@@ -34,12 +34,12 @@ type User struct {
 
 type IUserRepository interface {
 	RegisterUser(user *User) (*User, error)
-	JoinGroup(userId, groupId int) error
+	JoinGroup(userId, groupId int, invite *GroupInvite) error
 	LeaveGroup(userId, groupId int) error
 	GetUserById(id int) (*User, error)
 	GetUserByUUID(uuid string) (*User, error)
 	GetUserByEmailOrUserName(email string) (*User, error)
-	GetUsersByGroupId(groupId, limit, page int) ([]User, error)
+	GetUsersByGroupId(groupId int, query PageQuery) (*UserPage, error)
 	CreateOneTimePassword(user *User, password string, expiry time.Time) error
 	InvalidateOneTimePassword(user *User) error
 	UpdateUserTOTP(user User, totpSecret string, totpEnabled bool) error
@@ -55,7 +55,7 @@ type IUserService interface {
 	GetUserById(id int) (*User, error)
 	GetUserByUUID(uuid string) (*User, error)
 	GetUserByEmailOrUserName(email string) (*User, error)
-	GetUsersByGroupId(groupId, limit, page int) ([]User, error)
+	GetUsersByGroupId(userId, groupId int, query PageQuery) (*UserPage, error)
 	UpdateUser(user User) error
 	UpdatePassword(userId int, password string) error
 	ConfirmPassword(userId int, password string) error
@@ -67,8 +67,8 @@ type IUserService interface {
 	VerifyTOTP(userId int, verifyTOTP VerifyTOTPRequest) error
 	DisableTOTP(userId int) error
 	UpdateUserImageNum(userId, num int) (int, error)
-	JoinGroup(userId, groupId int) error
-	// LeaveGroup(userId, groupId int) error
+	JoinGroup(userId, groupId int, inviteToken string) (*JoinRequest, error)
+	LeaveGroup(userId, groupId int) error
 }
 
 
@@ -92,7 +92,7 @@ func (user *User) CheckPassword(providedPassword string) error {
 }
 
 type VerifyTOTPRequest struct {
-	Totp string `json: "totp"`
+	Totp string `json:"Totp"`
 }
 
 func (r VerifyTOTPRequest) Validate() error {
@@ -0,0 +1,323 @@
+package repository
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+
+type attachmentRepository struct {
+	DB *gorm.DB
+}
+
+
+func NewAttachmentRepository(db *gorm.DB) models.IAttachmentRepository {
+	return &attachmentRepository{ DB: db }
+}
+
+
+func (r *attachmentRepository) CreateAttachment(attachment *models.Attachment) (*models.Attachment, error) {
+	if err := r.DB.Omit("User").Create(attachment).Error; err != nil {
+		log.Print("Could not save attachment")
+		return nil, apperrors.NewInternal()
+	}
+
+	return attachment, nil
+}
+
+
+func (r *attachmentRepository) GetAttachmentById(id int) (*models.Attachment, error) {
+	attachment := &models.Attachment{}
+
+	if err := r.DB.Preload("Thumbnails").Where("id = ?", id).First(&attachment).Error; err != nil {
+		log.Printf("Could not find attachment with ID: %d\n", id)
+		return nil, apperrors.NewNotFound("Attachment", strconv.Itoa(id))
+	}
+
+	return attachment, nil
+}
+
+
+// GetAttachmentGroupIds lists the groups the attachment has been sent to
+func (r *attachmentRepository) GetAttachmentGroupIds(id int) ([]uint, error) {
+	var groupIds []uint
+
+	if err := r.DB.Model(&models.Message{}).Distinct("group_id").
+		Where("attachment_id = ? AND removed_at IS NULL", id).
+		Pluck("group_id", &groupIds).Error; err != nil {
+		log.Printf("Could not find messages with attachment ID: %d\n", id)
+		return nil, apperrors.NewInternal()
+	}
+
+	return groupIds, nil
+}
+
+
+// AcquireBlob takes a reference to the blob with the same hash, calling store to
+// save the content first if nobody has uploaded it yet. The hash stays locked
+// throughout, so content is never stored and collected at the same time.
+func (r *attachmentRepository) AcquireBlob(blob *models.Blob, store func() error) (*models.Blob, error) {
+	acquired := blob
+
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockBlobHash(tx, blob.Hash); err != nil {
+			return err
+		}
+
+		existing := &models.Blob{}
+		err := tx.Where("hash = ?", blob.Hash).First(&existing).Error
+
+		if err == nil {
+			if err := tx.Model(existing).UpdateColumn("ref_count", gorm.Expr("ref_count + 1")).Error; err != nil {
+				return err
+			}
+
+			existing.RefCount++
+			acquired = existing
+			return nil
+		}
+
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if err := store(); err != nil {
+			return err
+		}
+
+		blob.RefCount = 1
+		return tx.Create(blob).Error
+	})
+
+	if err != nil {
+		log.Printf("Could not acquire blob with hash: %s\n", blob.Hash)
+		return nil, apperrors.GetAppError(err, apperrors.ServerError)
+	}
+
+	return acquired, nil
+}
+
+
+// GetBlobThumbnailKeys lists the thumbnails already stored for a blob by the
+// attachments that use it
+func (r *attachmentRepository) GetBlobThumbnailKeys(blobId uint) ([]string, error) {
+	var keys []string
+
+	if err := r.DB.Unscoped().Model(&models.AttachmentThumbnail{}).
+		Joins("JOIN attachments ON attachments.id = attachment_thumbnails.attachment_id").
+		Where("attachments.blob_id = ?", blobId).
+		Distinct().Pluck("attachment_thumbnails.storage_key", &keys).Error; err != nil {
+		log.Printf("Could not get thumbnails of blob with ID: %d\n", blobId)
+		return nil, apperrors.NewInternal()
+	}
+
+	return keys, nil
+}
+
+
+// GetUnsentAttachmentIds finds attachments uploaded before the given time that were
+// never sent, and aren't waiting to be sent in a scheduled message
+func (r *attachmentRepository) GetUnsentAttachmentIds(before time.Time, limit int) ([]uint, error) {
+	var ids []uint
+
+	if err := r.DB.Model(&models.Attachment{}).Scopes(unsentAttachment).
+		Where("attachments.created_at < ?", before).
+		Order("attachments.id asc").Limit(limit).Pluck("attachments.id", &ids).Error; err != nil {
+		log.Print("Could not get unsent attachments")
+		return nil, apperrors.NewInternal()
+	}
+
+	return ids, nil
+}
+
+
+// ReleaseUnsentAttachment retires an attachment that was never sent and drops its
+// reference to the stored content. The attachment row is locked the same way
+// sending a message locks it, so it can't be sent while it is being released.
+// Reports whether it was released.
+func (r *attachmentRepository) ReleaseUnsentAttachment(id uint) (bool, error) {
+	released := false
+
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		var ids []uint
+
+		if err := tx.Model(&models.Attachment{}).Clauses(clause.Locking{ Strength: "UPDATE", Table: clause.Table{ Name: "attachments" } }).
+			Scopes(unsentAttachment).Where("attachments.id = ?", id).
+			Pluck("attachments.id", &ids).Error; err != nil {
+			return err
+		}
+
+		if len(ids) == 0 {
+			return nil
+		}
+
+		released = true
+		return releaseAttachment(tx, id)
+	})
+
+	if err != nil {
+		log.Printf("Could not release unsent attachment with ID: %d\n", id)
+		return false, apperrors.NewInternal()
+	}
+
+	return released, nil
+}
+
+
+// unsentAttachment matches attachments no message has been sent with, counting
+// deleted ones, and that no pending scheduled message is going to send
+func unsentAttachment(db *gorm.DB) *gorm.DB {
+	return db.
+		Where("NOT EXISTS (SELECT 1 FROM messages WHERE messages.attachment_id = attachments.id)").
+		Where(`NOT EXISTS (SELECT 1 FROM scheduled_messages WHERE scheduled_messages.attachment_id = attachments.id
+			AND scheduled_messages.status IN ? AND scheduled_messages.deleted_at IS NULL)`,
+			[]models.ScheduledMessageStatus{ models.PendingScheduledMessage, models.SendingScheduledMessage })
+}
+
+
+// ReleaseBlob gives back a reference taken for an attachment that was never saved
+func (r *attachmentRepository) ReleaseBlob(blobId uint) error {
+	if err := r.DB.Model(&models.Blob{}).Where("id = ? AND ref_count > 0", blobId).
+		UpdateColumn("ref_count", gorm.Expr("ref_count - 1")).Error; err != nil {
+		log.Printf("Could not release blob with ID: %d\n", blobId)
+		return apperrors.NewInternal()
+	}
+
+	return nil
+}
+
+
+func (r *attachmentRepository) GetOrphanedBlobs(limit int) ([]models.Blob, error) {
+	var blobs []models.Blob
+
+	if err := r.DB.Where("ref_count = 0").Order("id asc").Limit(limit).Find(&blobs).Error; err != nil {
+		log.Print("Could not get unreferenced blobs")
+		return nil, apperrors.NewInternal()
+	}
+
+	return blobs, nil
+}
+
+
+// DeleteBlob calls remove with the storage keys of the blob and its thumbnails,
+// then forgets it. Blobs that were referenced again in the meantime are left alone.
+func (r *attachmentRepository) DeleteBlob(blob *models.Blob, remove func(keys []string) error) error {
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockBlobHash(tx, blob.Hash); err != nil {
+			return err
+		}
+
+		current := &models.Blob{}
+		err := tx.Clauses(clause.Locking{ Strength: "UPDATE" }).
+			Where("id = ? AND ref_count = 0", blob.ID).First(&current).Error
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var thumbnailKeys []string
+
+		if err := tx.Unscoped().Model(&models.AttachmentThumbnail{}).
+			Joins("JOIN attachments ON attachments.id = attachment_thumbnails.attachment_id").
+			Where("attachments.blob_id = ?", current.ID).
+			Distinct().Pluck("attachment_thumbnails.storage_key", &thumbnailKeys).Error; err != nil {
+			return err
+		}
+
+		if err := remove(append([]string{ current.StorageKey }, thumbnailKeys...)); err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Delete(current).Error
+	})
+
+	if err != nil {
+		log.Printf("Could not delete blob with ID: %d\n", blob.ID)
+		return apperrors.GetAppError(err, apperrors.ServerError)
+	}
+
+	return nil
+}
+
+
+// GetGroupStorageUsage totals the files in messages currently in the group
+func (r *attachmentRepository) GetGroupStorageUsage(groupId int) (*models.StorageUsage, error) {
+	usage := &models.StorageUsage{}
+
+	if err := r.DB.Raw(`SELECT COUNT(*) AS files, COALESCE(SUM(attachments.size), 0) AS bytes,
+			COALESCE(ROUND(SUM(attachments.size::float8 / GREATEST(COALESCE(blobs.ref_count, 1), 1))), 0)::bigint AS stored_bytes
+		FROM messages
+		JOIN attachments ON attachments.id = messages.attachment_id AND attachments.deleted_at IS NULL
+		LEFT JOIN blobs ON blobs.id = attachments.blob_id
+		WHERE messages.group_id = ? AND messages.deleted_at IS NULL`, groupId).Scan(usage).Error; err != nil {
+		log.Printf("Could not get storage usage of group with ID: %d\n", groupId)
+		return nil, apperrors.NewInternal()
+	}
+
+	return usage, nil
+}
+
+
+// GetUserStorageUsage totals the files the user uploaded, whether sent yet or not
+func (r *attachmentRepository) GetUserStorageUsage(userId int) (*models.StorageUsage, error) {
+	usage := &models.StorageUsage{}
+
+	if err := r.DB.Raw(`SELECT COUNT(*) AS files, COALESCE(SUM(attachments.size), 0) AS bytes,
+			COALESCE(ROUND(SUM(attachments.size::float8 / GREATEST(COALESCE(blobs.ref_count, 1), 1))), 0)::bigint AS stored_bytes
+		FROM attachments
+		LEFT JOIN blobs ON blobs.id = attachments.blob_id
+		WHERE attachments.user_id = ? AND attachments.deleted_at IS NULL`, userId).Scan(usage).Error; err != nil {
+		log.Printf("Could not get storage usage of user with ID: %d\n", userId)
+		return nil, apperrors.NewInternal()
+	}
+
+	return usage, nil
+}
+
+
+// releaseAttachment retires the attachment of a message that is going away and
+// drops its reference to the stored content
+func releaseAttachment(tx *gorm.DB, attachmentId uint) error {
+	attachment := &models.Attachment{}
+
+	if err := tx.Where("id = ?", attachmentId).First(&attachment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if err := tx.Delete(attachment).Error; err != nil {
+		return err
+	}
+
+	if attachment.BlobId == nil {
+		return nil
+	}
+
+	return tx.Model(&models.Blob{}).Where("id = ? AND ref_count > 0", *attachment.BlobId).
+		UpdateColumn("ref_count", gorm.Expr("ref_count - 1")).Error
+}
+
+
+// lockBlobHash serializes storing and collecting the same content until the
+// transaction ends
+func lockBlobHash(tx *gorm.DB, hash string) error {
+	key, err := strconv.ParseUint(hash[:16], 16, 64)
+	if err != nil {
+		return err
+	}
+
+	return tx.Exec("SELECT pg_advisory_xact_lock(?)", int64(key)).Error
+}
@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"errors"
+	"log"
+	"strconv"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+
+type directRepository struct {
+	DB *gorm.DB
+}
+
+
+func NewDirectRepository(db *gorm.DB) models.IDirectRepository {
+	return &directRepository{ DB: db }
+}
+
+
+// CreateDirectGroup creates a hidden conversation in which every participant is a
+// plain member. If the same participants opened one concurrently that one is returned.
+func (r *directRepository) CreateDirectGroup(ownerId int, participantIds []uint) (*models.Group, error) {
+	key := models.DirectKey(participantIds)
+
+	group := &models.Group{
+		Name: "direct:" + key,
+		Kind: models.DirectGroupKind,
+		DirectKey: &key,
+		Visibility: models.HiddenGroupVisibility,
+	}
+
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+
+		for _, participantId := range participantIds {
+			member := &models.UserGroup{
+				UserId: participantId,
+				GroupId: group.ID,
+				Role: models.GroupMemberRole,
+			}
+
+			if err := tx.Create(&member).Error; err != nil {
+				log.Printf("Could not add user %d to direct group\n", participantId)
+				return apperrors.NewBadRequest("Could not create direct conversation")
+			}
+
+			if err := recordMembershipEvent(tx, group.ID, participantId, uint(ownerId), models.JoinMembershipEvent); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		if existing, findErr := r.GetDirectGroupByKey(key); findErr == nil {
+			return existing, nil
+		}
+
+		log.Print("Could not create direct group")
+		return nil, apperrors.GetAppError(err, "Could not create direct conversation")
+	}
+
+	return group, nil
+}
+
+
+func (r *directRepository) GetDirectGroupByKey(key string) (*models.Group, error) {
+	group := &models.Group{}
+
+	if err := r.DB.Where("direct_key = ? AND kind = ?", key, models.DirectGroupKind).First(&group).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, apperrors.NewNotFound("Group", key)
+		}
+
+		log.Printf("Could not get direct group with key: %s\n", key)
+		return nil, apperrors.NewInternal()
+	}
+
+	return group, nil
+}
+
+
+// RestoreParticipant brings back a participant who left the conversation, on
+// their own or when actorId reopens it. Messages sent while they were away don't
+// count as unread.
+func (r *directRepository) RestoreParticipant(groupId, userId, actorId int) error {
+	group := &models.Group{}
+
+	if err := r.DB.Where("id = ?", groupId).First(&group).Error; err != nil {
+		log.Printf("Could not find group with ID: %d\n", groupId)
+		return apperrors.NewNotFound("Group", strconv.Itoa(groupId))
+	}
+
+	member := &models.UserGroup{
+		UserId: uint(userId),
+		GroupId: group.ID,
+		Role: models.GroupMemberRole,
+		LastReadSeq: group.MessageSeq,
+		JoinedSeq: group.MessageSeq,
+	}
+
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := addMember(tx, member); err != nil {
+			return err
+		}
+
+		return recordMembershipEvent(tx, group.ID, member.UserId, uint(actorId), models.JoinMembershipEvent)
+	})
+}
+
+
+func (r *directRepository) GetDirectGroupsByUserId(userId int, query models.PageQuery) (*models.GroupPage, error) {
+	return groupsPage(r.DB, userId, models.DirectGroupKind, query)
+}
+
+
+// BlockUser is idempotent, blocking someone twice keeps a single block
+func (r *directRepository) BlockUser(block *models.UserBlock) error {
+	if err := r.DB.Omit(clause.Associations).Clauses(clause.OnConflict{ DoNothing: true }).Create(block).Error; err != nil {
+		log.Printf("Could not block user with ID: %d\n", block.BlockedId)
+		return apperrors.NewInternal()
+	}
+
+	return nil
+}
+
+
+func (r *directRepository) UnblockUser(blockerId, blockedId int) error {
+	result := r.DB.Unscoped().
+		Where("blocker_id = ? AND blocked_id = ?", blockerId, blockedId).
+		Delete(&models.UserBlock{})
+
+	if result.Error != nil {
+		log.Printf("Could not unblock user with ID: %d\n", blockedId)
+		return apperrors.NewInternal()
+	}
+
+	if result.RowsAffected == 0 {
+		return apperrors.NewBadRequest("You have not blocked this user")
+	}
+
+	return nil
+}
+
+
+func (r *directRepository) GetBlockedUsers(blockerId int) ([]models.UserBlock, error) {
+	var blocks []models.UserBlock
+
+	if err := r.DB.Preload("Blocked", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "uuid", "user_name", "image_num")
+	}).Where("blocker_id = ?", blockerId).Order("id desc").Find(&blocks).Error; err != nil {
+		log.Printf("Could not get blocked users for user with ID: %d\n", blockerId)
+		return nil, apperrors.NewInternal()
+	}
+
+	return blocks, nil
+}
+
+
+// HasBlockBetween reports whether a block exists in either direction between the
+// user and any of the others
+func (r *directRepository) HasBlockBetween(userId int, otherIds []uint) (bool, error) {
+	if len(otherIds) == 0 {
+		return false, nil
+	}
+
+	var count int64
+
+	if err := r.DB.Model(&models.UserBlock{}).
+		Where("(blocker_id = ? AND blocked_id IN ?) OR (blocked_id = ? AND blocker_id IN ?)", userId, otherIds, userId, otherIds).
+		Count(&count).Error; err != nil {
+		log.Printf("Could not check blocks for user with ID: %d\n", userId)
+		return false, apperrors.NewInternal()
+	}
+
+	return count > 0, nil
+}
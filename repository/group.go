@@ -5,6 +5,9 @@ import (
 	"darkoo/apperrors"
 
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -20,10 +23,29 @@ func NewGroupRepository(db *gorm.DB) models.IGroupRepository {
 }
 
 
-func (r *groupRepository) CreateGroup(group *models.Group) (*models.Group, error) {
-	if err := r.DB.Create(&group).Error; err != nil {
-		log.Print("Could not create group")
-		return nil, apperrors.NewBadRequest("Could not create group")
+func (r *groupRepository) CreateGroup(group *models.Group, ownerId int) (*models.Group, error) {
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&group).Error; err != nil {
+			log.Print("Could not create group")
+			return apperrors.NewBadRequest("Could not create group")
+		}
+
+		owner := &models.UserGroup{
+			UserId: uint(ownerId),
+			GroupId: group.ID,
+			Role: models.GroupOwnerRole,
+		}
+
+		if err := tx.Create(&owner).Error; err != nil {
+			log.Printf("Could not make user %d owner of group\n", ownerId)
+			return apperrors.NewBadRequest("Could not create group")
+		}
+
+		return recordMembershipEvent(tx, group.ID, owner.UserId, owner.UserId, models.JoinMembershipEvent)
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
 	return group, nil
@@ -34,7 +56,7 @@ func (r *groupRepository) GetGroupById(id int) (*models.Group, error) {
 	group := &models.Group{}
 
 	if err := r.DB.Where("id = ?", id).First(&group).Error; err != nil {
-		log.Print("Could not get group with ID: %d\n", id)
+		log.Printf("Could not get group with ID: %d\n", id)
 		return nil, apperrors.NewBadRequest("Could not get group with provided ID")
 	}
 
@@ -42,7 +64,7 @@ func (r *groupRepository) GetGroupById(id int) (*models.Group, error) {
 }
 
 
-func (r *groupRepository) UpdateGroup(group models.Group) error {
+func (r *groupRepository) UpdateGroup(actorId int, group models.Group) error {
 	groupId := group.ID
 	foundGroup, _ := r.GetGroupById(int(groupId))
 
@@ -60,30 +82,159 @@ func (r *groupRepository) UpdateGroup(group models.Group) error {
 		updatedDetails["Description"] = group.Description
 	}
 
-	if err := r.DB.Model(&foundGroup).Updates(updatedDetails).Error; err != nil {
-		log.Print("Could not update group")
-		return apperrors.NewInternal()
+	if group.Visibility != "" {
+		updatedDetails["Visibility"] = group.Visibility
 	}
 
-	return nil
+	if group.RequiresApproval != nil {
+		updatedDetails["RequiresApproval"] = *group.RequiresApproval
+	}
+
+	if group.EditWindowMinutes != nil {
+		updatedDetails["EditWindowMinutes"] = *group.EditWindowMinutes
+	}
+
+	before := map[string] interface{}{
+		"Name": foundGroup.Name,
+		"Description": foundGroup.Description,
+		"Visibility": foundGroup.Visibility,
+		"RequiresApproval": foundGroup.NeedsApproval(),
+		"EditWindowMinutes": foundGroup.EditWindowMinutes,
+	}
+
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&foundGroup).Updates(updatedDetails).Error; err != nil {
+			log.Print("Could not update group")
+			return apperrors.NewInternal()
+		}
+
+		entry := &models.ModerationLog{
+			GroupId: foundGroup.ID,
+			ActorId: uint(actorId),
+			Action: models.UpdateGroupModerationAction,
+		}
+
+		return recordModerationLog(tx, entry, before, updatedDetails)
+	})
 }
 
 
-func (r *groupRepository) GetGroupsByUserId(id, limit, page int) ([]models.Group, error) {
+func (r *groupRepository) GetGroupsByUserId(id int, query models.PageQuery) (*models.GroupPage, error) {
 	user := &models.User{}
-	var groups []models.Group
 	
 	if err := r.DB.Where("id = ?", id).First(&user).Error; err != nil {
-		log.Print("Could not fin user with ID: %d\n", id)
-		return groups, apperrors.NewBadRequest("Could not find user with provided ID")
+		log.Printf("Could not find user with ID: %d\n", id)
+		return nil, apperrors.NewBadRequest("Could not find user with provided ID")
 	}
 
-	if err := r.DB.Model(&user).Association("Groups").Find(&groups).Error; err != nil {
+	return groupsPage(r.DB, id, models.StandardGroupKind, query)
+}
+
+
+// groupsPage lists the user's groups of one kind, most recently active first
+func groupsPage(db *gorm.DB, userId int, kind models.GroupKind, query models.PageQuery) (*models.GroupPage, error) {
+	var groups []models.Group
+	tx := db
+
+	// Direct conversations have no name of their own, so they list their participants
+	if kind == models.DirectGroupKind {
+		tx = tx.Preload("Users", func(db *gorm.DB) *gorm.DB {
+			return db.Select("users.id", "users.uuid", "users.user_name", "users.image_num")
+		})
+	}
+
+	// Message IDs only grow, so the newest last message means the most recent activity
+	if err := tx.Joins("JOIN user_groups ON user_groups.group_id = groups.id").
+		Where("user_groups.user_id = ? AND groups.kind = ?", userId, kind).
+		Scopes(keyset("COALESCE(groups.last_message_id, 0)", "groups.id", query, true)).
+		Find(&groups).Error; err != nil {
 		log.Print("Could not find user_groups association")
-		return groups, apperrors.NewBadRequest("Could not find user_groups association")
+		return nil, apperrors.NewBadRequest("Could not find user_groups association")
+	}
+
+	page := &models.GroupPage{ Items: groups }
+
+	if limit := pageSize(query.Limit); len(groups) > limit {
+		page.Items = groups[:limit]
+		last := page.Items[limit-1]
+		page.NextCursor = models.Cursor{ Key: int64(lastActivity(last)), ID: last.ID }.Encode()
+	}
+
+	if err := summarizeGroups(db, userId, page.Items); err != nil {
+		return nil, err
 	}
 
-	return groups, nil
+	return page, nil
+}
+
+
+func lastActivity(group models.Group) uint {
+	if group.LastMessageId == nil {
+		return 0
+	}
+	return *group.LastMessageId
+}
+
+
+// summarizeGroups fills in each group's unread count for the user and its last message
+func summarizeGroups(db *gorm.DB, userId int, groups []models.Group) error {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	groupIds := make([]uint, 0, len(groups))
+	messageIds := make([]uint, 0, len(groups))
+
+	for _, group := range groups {
+		groupIds = append(groupIds, group.ID)
+		if group.LastMessageId != nil {
+			messageIds = append(messageIds, *group.LastMessageId)
+		}
+	}
+
+	var counts []struct {
+		GroupId uint
+		Unread  int64
+	}
+
+	if err := db.Table("user_groups").
+		Select("user_groups.group_id, COUNT(messages.id) AS unread").
+		Joins(`LEFT JOIN messages ON messages.group_id = user_groups.group_id
+			AND messages.seq > user_groups.last_read_seq
+			AND messages.user_id <> user_groups.user_id
+			AND messages.deleted_at IS NULL`).
+		Where("user_groups.user_id = ? AND user_groups.group_id IN ?", userId, groupIds).
+		Group("user_groups.group_id").
+		Scan(&counts).Error; err != nil {
+		log.Printf("Could not count unread messages for user with ID: %d\n", userId)
+		return apperrors.NewInternal()
+	}
+
+	var lastMessages []models.Message
+
+	if len(messageIds) > 0 {
+		if err := db.Where("id IN ?", messageIds).Find(&lastMessages).Error; err != nil {
+			log.Printf("Could not get last messages for user with ID: %d\n", userId)
+			return apperrors.NewInternal()
+		}
+	}
+
+	unread := map[uint]int64{}
+	for _, count := range counts {
+		unread[count.GroupId] = count.Unread
+	}
+
+	latest := map[uint]*models.Message{}
+	for i := range lastMessages {
+		latest[lastMessages[i].GroupId] = &lastMessages[i]
+	}
+
+	for i := range groups {
+		groups[i].UnreadCount = unread[groups[i].ID]
+		groups[i].LastMessage = latest[groups[i].ID]
+	}
+
+	return nil
 }
 
 
@@ -97,59 +248,459 @@ func (r *groupRepository) DeleteGroupById(id int) error {
 }
 
 
-func (r *groupRepository) BanUserFromGroup(groupId, userId int) error { 
-	group := &models.Group{}
-	user := &models.User{}
-	userGroup := &models.UserGroup{}
+// SanctionMember bans or mutes a member and records the sanction alongside it
+func (r *groupRepository) SanctionMember(sanction *models.Sanction) (*models.Sanction, error) {
+	userGroup, err := r.GetMembership(int(sanction.GroupId), int(sanction.UserId))
 
-	if err := r.DB.Where("id = ?", groupId).First(&group).Error; err != nil {
-		log.Printf("Group with ID %d not found\n", groupId)
-		return apperrors.NewBadRequest("Group with provided ID not found")
+	if err != nil {
+		return nil, apperrors.NewBadRequest("User does not belong to this group")
 	}
 
-	if err := r.DB.Where("id = ?", userId).First(&user).Error; err != nil {
-		log.Printf("User with ID %d not found\n", userId)
-		return apperrors.NewBadRequest("User with provided ID not found")
+	now := time.Now()
+	updates := map[string] interface{}{}
+	action := models.BanModerationAction
+
+	switch sanction.Type {
+	case models.BanSanction:
+		if userGroup.IsBanned(now) {
+			log.Print("User is already banned from this group")
+			return nil, apperrors.NewBadRequest("User is already banned from this group")
+		}
+		updates["banned"] = true
+		updates["banned_until"] = sanction.ExpiresAt
+
+	case models.MuteSanction:
+		if userGroup.IsMuted(now) {
+			log.Print("User is already muted in this group")
+			return nil, apperrors.NewBadRequest("User is already muted in this group")
+		}
+		updates["muted"] = true
+		updates["muted_until"] = sanction.ExpiresAt
+		action = models.MuteModerationAction
 	}
 
-	if err := r.DB.Where("user_id = ? AND group_id = ? AND banned = ?", userId, groupId, false).First(&userGroup).Error; err != nil {
-		log.Print("User is already banned or does not belong to this group")
-		return apperrors.NewBadRequest("User is already banned or does not belong to this group")
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&userGroup).Updates(updates).Error; err != nil {
+			log.Printf("Could not update user %s status\n", sanction.Type)
+			return apperrors.NewInternal()
+		}
+
+		if err := tx.Create(&sanction).Error; err != nil {
+			log.Print("Could not record sanction")
+			return apperrors.NewInternal()
+		}
+
+		entry := &models.ModerationLog{
+			GroupId: sanction.GroupId,
+			ActorId: sanction.ActorId,
+			TargetUserId: uintPtr(sanction.UserId),
+			Action: action,
+			Reason: sanction.Reason,
+		}
+
+		if err := recordModerationLog(tx, entry, nil, updates); err != nil {
+			return err
+		}
+
+		if sanction.Type == models.BanSanction {
+			return recordMembershipEvent(tx, sanction.GroupId, sanction.UserId, sanction.ActorId, models.BanMembershipEvent)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
 	}
-	
-	if err := r.DB.Model(&userGroup).Updates(models.UserGroup{ Banned : true}).Error; err != nil {
-		log.Print("Could not updated user banned status")
-		return apperrors.NewBadRequest("Could not update user banned status")
+
+	return sanction, nil
+}
+
+
+// LiftSanction ends an active ban or mute before it expires on its own
+func (r *groupRepository) LiftSanction(actorId, groupId, userId int, sanctionType models.SanctionType) error {
+	userGroup, err := r.GetMembership(groupId, userId)
+
+	if err != nil {
+		return apperrors.NewBadRequest("User does not belong to this group")
 	}
 
-	return nil
+	now := time.Now()
+	before := map[string] interface{}{}
+	updates := map[string] interface{}{}
+	action := models.UnbanModerationAction
+
+	switch sanctionType {
+	case models.BanSanction:
+		if !userGroup.IsBanned(now) {
+			log.Print("User is not banned from this group")
+			return apperrors.NewBadRequest("User is not banned from this group")
+		}
+		before["banned"] = true
+		before["banned_until"] = userGroup.BannedUntil
+		updates["banned"] = false
+		updates["banned_until"] = nil
+
+	case models.MuteSanction:
+		if !userGroup.IsMuted(now) {
+			log.Print("User is not muted in this group")
+			return apperrors.NewBadRequest("User is not muted in this group")
+		}
+		before["muted"] = true
+		before["muted_until"] = userGroup.MutedUntil
+		updates["muted"] = false
+		updates["muted_until"] = nil
+		action = models.UnmuteModerationAction
+	}
+
+	actor := uint(actorId)
+
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&userGroup).Updates(updates).Error; err != nil {
+			log.Printf("Could not update user %s status\n", sanctionType)
+			return apperrors.NewInternal()
+		}
+
+		if err := tx.Model(&models.Sanction{}).
+			Scopes(activeSanction(now)).
+			Where("group_id = ? AND user_id = ? AND type = ?", groupId, userId, sanctionType).
+			Updates(models.Sanction{ LiftedAt: &now, LiftedById: &actor }).Error; err != nil {
+			log.Print("Could not lift sanction")
+			return apperrors.NewInternal()
+		}
+
+		entry := &models.ModerationLog{
+			GroupId: userGroup.GroupId,
+			ActorId: actor,
+			TargetUserId: uintPtr(userGroup.UserId),
+			Action: action,
+		}
+
+		if err := recordModerationLog(tx, entry, before, updates); err != nil {
+			return err
+		}
+
+		if sanctionType == models.BanSanction {
+			return recordMembershipEvent(tx, userGroup.GroupId, userGroup.UserId, actor, models.UnbanMembershipEvent)
+		}
+
+		return nil
+	})
 }
 
 
-func (r *groupRepository) UnBanUserFromGroup(groupId, userId int) error { 
-	group := &models.Group{}
-	user := &models.User{}
+func (r *groupRepository) GetActiveSanctions(groupId int) ([]models.Sanction, error) {
+	var sanctions []models.Sanction
+
+	if err := r.DB.Preload("User", publicUser).Scopes(activeSanction(time.Now())).
+					Where("group_id = ?", groupId).Order("created_at desc").Find(&sanctions).Error; err != nil {
+		log.Printf("Could not get sanctions for group with ID: %d\n", groupId)
+		return sanctions, apperrors.NewInternal()
+	}
+
+	return sanctions, nil
+}
+
+
+func (r *groupRepository) KickUserFromGroup(actorId, groupId, userId int) error {
+	userGroup, err := r.GetMembership(groupId, userId)
+
+	if err != nil {
+		return apperrors.NewBadRequest("User does not belong to this group")
+	}
+
+	// The membership row is what holds a ban, so deleting it would lift the ban
+	now := time.Now()
+	if userGroup.IsBanned(now) {
+		return apperrors.NewBadRequest("This member is banned and cannot be kicked")
+	}
+
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().
+			Where("id = ? AND NOT (banned AND (banned_until IS NULL OR banned_until > ?))", userGroup.ID, now).
+			Delete(&models.UserGroup{})
+
+		if result.Error != nil {
+			log.Print("Could not remove user from group")
+			return apperrors.NewInternal()
+		}
+
+		if result.RowsAffected == 0 {
+			return apperrors.NewBadRequest("This member is banned and cannot be kicked")
+		}
+
+		entry := &models.ModerationLog{
+			GroupId: userGroup.GroupId,
+			ActorId: uint(actorId),
+			TargetUserId: uintPtr(userGroup.UserId),
+			Action: models.KickModerationAction,
+		}
+
+		if err := recordModerationLog(tx, entry, map[string] interface{}{ "role": userGroup.Role }, nil); err != nil {
+			return err
+		}
+
+		return recordMembershipEvent(tx, userGroup.GroupId, userGroup.UserId, uint(actorId), models.KickMembershipEvent)
+	})
+}
+
+
+func (r *groupRepository) GetMembership(groupId, userId int) (*models.UserGroup, error) {
 	userGroup := &models.UserGroup{}
 
-	if err := r.DB.Where("id = ?", groupId).First(&group).Error; err != nil {
-		log.Printf("Group with ID %d not found\n", groupId)
-		return apperrors.NewBadRequest("Group with provided ID not found")
+	if err := r.DB.Where("user_id = ? AND group_id = ?", userId, groupId).First(&userGroup).Error; err != nil {
+		log.Printf("User %d is not a member of group %d\n", userId, groupId)
+		return nil, apperrors.NewNotFound("Membership", strconv.Itoa(userId))
+	}
+
+	return userGroup, nil
+}
+
+
+// GetMembersByUserNames finds active members of a group by username, ignoring case
+func (r *groupRepository) GetMembersByUserNames(groupId int, userNames []string) ([]models.User, error) {
+	var users []models.User
+
+	if len(userNames) == 0 {
+		return users, nil
+	}
+
+	if err := r.DB.Joins("JOIN user_groups ON user_groups.user_id = users.id").
+		Where("user_groups.group_id = ? AND LOWER(users.user_name) IN ?", groupId, userNames).
+		Scopes(activeMember(time.Now())).
+		Find(&users).Error; err != nil {
+		log.Printf("Could not find members of group with ID: %d\n", groupId)
+		return users, apperrors.NewInternal()
+	}
+
+	return users, nil
+}
+
+
+func (r *groupRepository) GetUsersByIds(ids []uint) ([]models.User, error) {
+	var users []models.User
+
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	if err := r.DB.Select("id", "uuid", "user_name", "image_num").Where("id IN ?", ids).Find(&users).Error; err != nil {
+		log.Print("Could not get users by ID")
+		return users, apperrors.NewInternal()
+	}
+
+	return users, nil
+}
+
+
+// ResolvePseudonym finds the account behind a pseudonym among everyone who has been
+// a member of the group or written in it, and records the lookup in the moderation log
+func (r *groupRepository) ResolvePseudonym(actorId int, group *models.Group, pseudonym, reason string) (*models.User, error) {
+	var candidates []uint
+
+	if err := r.DB.Raw(`SELECT user_id FROM user_groups WHERE group_id = ?
+		UNION SELECT user_id FROM membership_events WHERE group_id = ?
+		UNION SELECT user_id FROM messages WHERE group_id = ? AND user_id IS NOT NULL`, group.ID, group.ID, group.ID).
+		Scan(&candidates).Error; err != nil {
+		log.Printf("Could not list members of group with ID: %d\n", group.ID)
+		return nil, apperrors.NewInternal()
+	}
+
+	var matches []uint
+	for _, candidate := range candidates {
+		if strings.EqualFold(group.Pseudonym(candidate).Name, pseudonym) {
+			matches = append(matches, candidate)
+		}
 	}
 
-	if err := r.DB.Where("id = ?", userId).First(&user).Error; err != nil {
-		log.Printf("User with ID %d not found\n", userId)
-		return apperrors.NewBadRequest("User with provided ID not found")
+	if len(matches) == 0 {
+		return nil, apperrors.NewNotFound("Pseudonym", pseudonym)
 	}
 
-	if err := r.DB.Where("user_id = ? AND group_id = ? AND banned = ?", userId, groupId, true).First(&userGroup).Error; err != nil {
-		log.Print("User is not banned or does not belong to this group")
-		return apperrors.NewBadRequest("User is not banned or does not belong to this group")
+	if len(matches) > 1 {
+		log.Printf("Pseudonym %q is shared by %d users in group %d\n", pseudonym, len(matches), group.ID)
+		return nil, apperrors.NewConflict("Pseudonym", pseudonym)
 	}
 
-	if err := r.DB.Model(&userGroup).Updates(models.UserGroup{ Banned : false}).Error; err != nil {
-		log.Print("Could not updated user banned status")
-		return apperrors.NewBadRequest("Could not update user banned status")
+	user := &models.User{}
+
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", matches[0]).First(&user).Error; err != nil {
+			log.Printf("Could not find user with ID: %d\n", matches[0])
+			return apperrors.NewNotFound("User", strconv.Itoa(int(matches[0])))
+		}
+
+		entry := &models.ModerationLog{
+			GroupId: group.ID,
+			ActorId: uint(actorId),
+			TargetUserId: uintPtr(user.ID),
+			Action: models.ResolvePseudonymModerationAction,
+			Reason: reason,
+		}
+
+		return recordModerationLog(tx, entry, nil, map[string] interface{}{ "pseudonym": pseudonym })
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+
+// MarkRead moves the member's read marker up to seq. Markers never move backwards.
+func (r *groupRepository) MarkRead(groupId, userId int, seq int64) error {
+	if err := r.DB.Model(&models.UserGroup{}).
+		Where("group_id = ? AND user_id = ?", groupId, userId).
+		UpdateColumn("last_read_seq", gorm.Expr("GREATEST(last_read_seq, ?)", seq)).Error; err != nil {
+		log.Printf("Could not update read marker in group with ID: %d\n", groupId)
+		return apperrors.NewInternal()
 	}
 
 	return nil
+}
+
+
+// HasBlockWithMember reports whether the user has blocked, or been blocked by,
+// anyone else in the group
+func (r *groupRepository) HasBlockWithMember(groupId, userId int) (bool, error) {
+	var count int64
+
+	if err := r.DB.Model(&models.UserBlock{}).
+		Joins(`JOIN user_groups ON user_groups.group_id = ? AND user_groups.deleted_at IS NULL
+			AND user_groups.user_id IN (user_blocks.blocker_id, user_blocks.blocked_id)`, groupId).
+		Where("(user_blocks.blocker_id = ? OR user_blocks.blocked_id = ?) AND user_groups.user_id <> ?", userId, userId, userId).
+		Count(&count).Error; err != nil {
+		log.Printf("Could not check blocks in group with ID: %d\n", groupId)
+		return false, apperrors.NewInternal()
+	}
+
+	return count > 0, nil
+}
+
+
+// CountReaders counts members other than the author who have read up to seq.
+// Members who joined after the message was sent never saw it and aren't counted.
+func (r *groupRepository) CountReaders(groupId int, seq int64, authorId uint) (int64, error) {
+	var count int64
+
+	if err := r.DB.Model(&models.UserGroup{}).
+		Where("group_id = ? AND user_id <> ? AND last_read_seq >= ? AND joined_seq < ?", groupId, authorId, seq, seq).
+		Count(&count).Error; err != nil {
+		log.Printf("Could not count readers in group with ID: %d\n", groupId)
+		return 0, apperrors.NewInternal()
+	}
+
+	return count, nil
+}
+
+
+func (r *groupRepository) UpdateMemberRole(actorId, groupId, userId int, role models.GroupRole) error {
+	userGroup, err := r.GetMembership(groupId, userId)
+
+	if err != nil {
+		return apperrors.NewBadRequest("User does not belong to this group")
+	}
+
+	before := map[string] interface{}{ "role": userGroup.Role }
+
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&userGroup).Updates(models.UserGroup{ Role: role }).Error; err != nil {
+			log.Print("Could not update member role")
+			return apperrors.NewInternal()
+		}
+
+		entry := &models.ModerationLog{
+			GroupId: userGroup.GroupId,
+			ActorId: uint(actorId),
+			TargetUserId: uintPtr(userGroup.UserId),
+			Action: models.ChangeRoleModerationAction,
+		}
+
+		return recordModerationLog(tx, entry, before, map[string] interface{}{ "role": role })
+	})
+}
+
+
+func (r *groupRepository) TransferOwnership(groupId, ownerId, newOwnerId int) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.UserGroup{}).
+			Where("group_id = ? AND user_id = ?", groupId, newOwnerId).
+			Scopes(activeMember(time.Now())).
+			Update("role", models.GroupOwnerRole)
+
+		if result.Error != nil {
+			log.Print("Could not promote new group owner")
+			return apperrors.NewInternal()
+		}
+
+		if result.RowsAffected == 0 {
+			log.Printf("User %d cannot become owner of group %d\n", newOwnerId, groupId)
+			return apperrors.NewBadRequest("New owner must be an active member of this group")
+		}
+
+		if err := tx.Model(&models.UserGroup{}).
+			Where("group_id = ? AND user_id = ?", groupId, ownerId).
+			Update("role", models.GroupAdminRole).Error; err != nil {
+			log.Print("Could not demote previous group owner")
+			return apperrors.NewInternal()
+		}
+
+		entry := &models.ModerationLog{
+			GroupId: uint(groupId),
+			ActorId: uint(ownerId),
+			TargetUserId: uintPtr(uint(newOwnerId)),
+			Action: models.TransferOwnershipModerationAction,
+		}
+
+		return recordModerationLog(tx, entry, map[string] interface{}{ "ownerId": ownerId }, map[string] interface{}{ "ownerId": newOwnerId })
+	})
+}
+
+
+func (r *groupRepository) GetMembershipEvents(groupId, limit, page int) ([]models.MembershipEvent, error) {
+	var events []models.MembershipEvent
+
+	if err := r.DB.Scopes(paginate(limit, page)).Where("group_id = ?", groupId).
+					Order("id desc").Find(&events).Error; err != nil {
+		log.Printf("Could not get membership events for group with ID: %d\n", groupId)
+		return events, apperrors.NewInternal()
+	}
+
+	return events, nil
+}
+
+
+func (r *groupRepository) GetModerationLogs(groupId int, filter models.ModerationLogFilter, limit, page int) ([]models.ModerationLog, error) {
+	var logs []models.ModerationLog
+
+	query := r.DB.Where("group_id = ?", groupId)
+
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+
+	if filter.ActorId != 0 {
+		query = query.Where("actor_id = ?", filter.ActorId)
+	}
+
+	if filter.TargetUserId != 0 {
+		query = query.Where("target_user_id = ?", filter.TargetUserId)
+	}
+
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	if err := query.Scopes(paginate(limit, page)).Order("id desc").Find(&logs).Error; err != nil {
+		log.Printf("Could not get moderation logs for group with ID: %d\n", groupId)
+		return logs, apperrors.NewInternal()
+	}
+
+	return logs, nil
 }
\ No newline at end of file
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"darkoo/models"
+	"darkoo/apperrors"
+
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+
+type inviteRepository struct {
+	DB *gorm.DB
+}
+
+
+func NewInviteRepository(db *gorm.DB) models.IInviteRepository {
+	return &inviteRepository{ DB: db, }
+}
+
+
+func (r *inviteRepository) CreateInvite(invite *models.GroupInvite) (*models.GroupInvite, error) {
+	if err := r.DB.Create(&invite).Error; err != nil {
+		log.Print("Could not create invite")
+		return nil, apperrors.NewInternal()
+	}
+
+	return invite, nil
+}
+
+
+func (r *inviteRepository) GetInviteById(id int) (*models.GroupInvite, error) {
+	invite := &models.GroupInvite{}
+
+	if err := r.DB.Where("id = ?", id).First(&invite).Error; err != nil {
+		log.Printf("Could not find invite with ID: %d\n", id)
+		return nil, apperrors.NewBadRequest("Could not find invite with provided ID")
+	}
+
+	return invite, nil
+}
+
+
+func (r *inviteRepository) GetInviteByToken(token string) (*models.GroupInvite, error) {
+	invite := &models.GroupInvite{}
+
+	if err := r.DB.Where("token = ?", token).First(&invite).Error; err != nil {
+		log.Print("Could not find invite with provided token")
+		return nil, apperrors.NewBadRequest("Invite is invalid or has expired")
+	}
+
+	return invite, nil
+}
+
+
+func (r *inviteRepository) GetInvitesByGroupId(groupId int) ([]models.GroupInvite, error) {
+	var invites []models.GroupInvite
+
+	if err := r.DB.Where("group_id = ?", groupId).Order("created_at desc").Find(&invites).Error; err != nil {
+		log.Printf("Could not get invites for group with ID: %d\n", groupId)
+		return invites, apperrors.NewInternal()
+	}
+
+	return invites, nil
+}
+
+
+func (r *inviteRepository) RevokeInvite(id int) error {
+	invite, err := r.GetInviteById(id)
+
+	if err != nil {
+		return err
+	}
+
+	if invite.RevokedAt != nil {
+		log.Print("Invite has already been revoked")
+		return apperrors.NewBadRequest("Invite has already been revoked")
+	}
+
+	if err := r.DB.Model(&invite).Updates(models.GroupInvite{ RevokedAt: timePtr(time.Now()) }).Error; err != nil {
+		log.Print("Could not revoke invite")
+		return apperrors.NewInternal()
+	}
+
+	return nil
+}
+
+
+func (r *inviteRepository) GetRedemptionsByInviteId(inviteId int) ([]models.InviteRedemption, error) {
+	var redemptions []models.InviteRedemption
+
+	if err := r.DB.Preload("User", publicUser).Where("invite_id = ?", inviteId).Order("created_at desc").Find(&redemptions).Error; err != nil {
+		log.Printf("Could not get redemptions for invite with ID: %d\n", inviteId)
+		return redemptions, apperrors.NewInternal()
+	}
+
+	return redemptions, nil
+}
+
+
+// redeemInvite consumes one use of the invite and records the redemption.
+// It must run inside the transaction that creates the membership.
+func redeemInvite(tx *gorm.DB, invite *models.GroupInvite, userId uint) error {
+	result := tx.Model(&models.GroupInvite{}).
+		Where("id = ? AND revoked_at IS NULL AND expires_at > ? AND (max_uses = 0 OR uses < max_uses)", invite.ID, time.Now()).
+		UpdateColumn("uses", gorm.Expr("uses + ?", 1))
+
+	if result.Error != nil {
+		log.Print("Could not redeem invite")
+		return apperrors.NewInternal()
+	}
+
+	if result.RowsAffected == 0 {
+		log.Printf("Invite %d is no longer usable\n", invite.ID)
+		return apperrors.NewBadRequest("Invite is invalid or has expired")
+	}
+
+	redemption := &models.InviteRedemption{
+		InviteId: invite.ID,
+		GroupId: invite.GroupId,
+		UserId: userId,
+	}
+
+	if err := tx.Create(&redemption).Error; err != nil {
+		log.Print("Could not record invite redemption")
+		return apperrors.NewInternal()
+	}
+
+	return nil
+}
+
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
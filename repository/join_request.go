@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"darkoo/models"
+	"darkoo/apperrors"
+
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+
+type joinRequestRepository struct {
+	DB *gorm.DB
+}
+
+
+func NewJoinRequestRepository(db *gorm.DB) models.IJoinRequestRepository {
+	return &joinRequestRepository{ DB: db, }
+}
+
+
+func (r *joinRequestRepository) CreateJoinRequest(request *models.JoinRequest) (*models.JoinRequest, error) {
+	if err := r.DB.Create(&request).Error; err != nil {
+		log.Print("Could not create join request")
+		return nil, apperrors.NewInternal()
+	}
+
+	return request, nil
+}
+
+
+func (r *joinRequestRepository) GetJoinRequestById(id int) (*models.JoinRequest, error) {
+	request := &models.JoinRequest{}
+
+	if err := r.DB.Where("id = ?", id).First(&request).Error; err != nil {
+		log.Printf("Could not find join request with ID: %d\n", id)
+		return nil, apperrors.NewBadRequest("Could not find join request with provided ID")
+	}
+
+	return request, nil
+}
+
+
+func (r *joinRequestRepository) GetPendingJoinRequest(groupId, userId int) (*models.JoinRequest, error) {
+	request := &models.JoinRequest{}
+
+	if err := r.DB.Where("group_id = ? AND user_id = ? AND status = ?", groupId, userId, models.PendingJoinRequest).
+					First(&request).Error; err != nil {
+		return nil, apperrors.NewNotFound("JoinRequest", "pending")
+	}
+
+	return request, nil
+}
+
+
+func (r *joinRequestRepository) GetJoinRequestsByGroupId(groupId int, status models.JoinRequestStatus) ([]models.JoinRequest, error) {
+	var requests []models.JoinRequest
+
+	if err := r.DB.Preload("User", publicUser).Where("group_id = ? AND status = ?", groupId, status).
+					Order("created_at asc").Find(&requests).Error; err != nil {
+		log.Printf("Could not get join requests for group with ID: %d\n", groupId)
+		return requests, apperrors.NewInternal()
+	}
+
+	return requests, nil
+}
+
+
+func (r *joinRequestRepository) ApproveJoinRequest(request *models.JoinRequest, reviewerId int, message string) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := reviewJoinRequest(tx, request, models.ApprovedJoinRequest, reviewerId, message); err != nil {
+			return err
+		}
+
+		lastSeq, err := currentMessageSeq(tx, request.GroupId)
+		if err != nil {
+			return err
+		}
+
+		userGroup := &models.UserGroup{
+			UserId: request.UserId,
+			GroupId: request.GroupId,
+			LastReadSeq: lastSeq,
+			JoinedSeq: lastSeq,
+		}
+
+		if err := addMember(tx, userGroup); err != nil {
+			return err
+		}
+
+		return recordMembershipEvent(tx, request.GroupId, request.UserId, uint(reviewerId), models.JoinMembershipEvent)
+	})
+}
+
+
+func (r *joinRequestRepository) RejectJoinRequest(request *models.JoinRequest, reviewerId int, message string) error {
+	return reviewJoinRequest(r.DB, request, models.RejectedJoinRequest, reviewerId, message)
+}
+
+
+// reviewJoinRequest moves a request out of pending. The status guard keeps two
+// reviewers from both acting on the same request.
+func reviewJoinRequest(db *gorm.DB, request *models.JoinRequest, status models.JoinRequestStatus, reviewerId int, message string) error {
+	reviewer := uint(reviewerId)
+
+	result := db.Model(&models.JoinRequest{}).
+		Where("id = ? AND status = ?", request.ID, models.PendingJoinRequest).
+		Updates(models.JoinRequest{
+			Status: status,
+			ReviewedById: &reviewer,
+			ReviewMessage: message,
+			ReviewedAt: timePtr(time.Now()),
+		})
+
+	if result.Error != nil {
+		log.Print("Could not review join request")
+		return apperrors.NewInternal()
+	}
+
+	if result.RowsAffected == 0 {
+		log.Printf("Join request %d has already been reviewed\n", request.ID)
+		return apperrors.NewBadRequest("Join request has already been reviewed")
+	}
+
+	return nil
+}
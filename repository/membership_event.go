@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"darkoo/models"
+	"darkoo/apperrors"
+
+	"log"
+
+	"gorm.io/gorm"
+)
+
+
+// recordMembershipEvent appends to the group's membership history.
+// It must run inside the transaction that changes the membership.
+func recordMembershipEvent(tx *gorm.DB, groupId, userId, actorId uint, eventType models.MembershipEventType) error {
+	event := &models.MembershipEvent{
+		GroupId: groupId,
+		UserId: userId,
+		ActorId: actorId,
+		Type: eventType,
+	}
+
+	if err := tx.Create(&event).Error; err != nil {
+		log.Printf("Could not record %s event for user %d in group %d\n", eventType, userId, groupId)
+		return apperrors.NewInternal()
+	}
+
+	return nil
+}
@@ -5,8 +5,10 @@ import (
 	"darkoo/apperrors"
 
 	"log"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 
@@ -35,41 +37,205 @@ func (r *messageRepository) SendMessage(message *models.Message) (*models.Messag
 		return nil, apperrors.NewBadRequest("Could not find user with provided ID")
 	}
 
-	if err := r.DB.Where("user_id = ? AND group_id = ? AND banned = ?", user.ID, group.ID, false).First(&userGroup).Error; err != nil {
+	if err := r.DB.Where("user_id = ? AND group_id = ?", user.ID, group.ID).Scopes(activeMember(time.Now())).First(&userGroup).Error; err != nil {
 		log.Print("Cannot send messages. User is not a member of group")
 		return nil, apperrors.NewBadRequest("Cannot send messages. User is not a member of group")
 	}
 
-	if message.ContentType != "text" && message.AttachmentUrl == nil {
-		log.Print("Attachment URL is required for this type of message")
-		return nil, apperrors.NewBadRequest("Attachment URL is required for this type of message")
+	if message.AttachmentId != nil {
+		if err := r.checkAttachment(message); err != nil {
+			return nil, err
+		}
 	}
 
-	if err := r.DB.Create(&message).Error; err != nil {
-		log.Print("Could not send message")
-		return nil, apperrors.NewInternal()
+	if message.IsReply() {
+		if err := r.checkParent(message); err != nil {
+			return nil, err
+		}
+	}
+
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		// Unsent attachments are collected after a while, so make sure this one
+		// is still there and keep it until the message is saved
+		if message.AttachmentId != nil {
+			var ids []uint
+			if err := tx.Model(&models.Attachment{}).Clauses(clause.Locking{ Strength: "UPDATE" }).
+				Where("id = ?", *message.AttachmentId).Pluck("id", &ids).Error; err != nil {
+				log.Printf("Could not lock attachment with ID: %d\n", *message.AttachmentId)
+				return apperrors.NewInternal()
+			}
+			if len(ids) == 0 {
+				return apperrors.NewBadRequest("Could not find attachment with provided ID")
+			}
+		}
+
+		seq, err := nextMessageSeq(tx, message.GroupId)
+		if err != nil {
+			return err
+		}
+		message.Seq = seq
+
+		if err := tx.Omit("Attachment").Create(&message).Error; err != nil {
+			log.Print("Could not send message")
+			return apperrors.NewInternal()
+		}
+
+		if err := tx.Model(&models.Group{}).Where("id = ?", message.GroupId).
+			UpdateColumn("last_message_id", message.ID).Error; err != nil {
+			log.Printf("Could not update last message of group with ID: %d\n", message.GroupId)
+			return apperrors.NewInternal()
+		}
+
+		if message.IsReply() {
+			if err := tx.Model(&models.Message{}).Where("id = ?", *message.ParentId).UpdateColumns(map[string] interface{}{
+				"reply_count": gorm.Expr("reply_count + 1"),
+				"last_reply_at": message.CreatedAt,
+			}).Error; err != nil {
+				log.Printf("Could not update thread of message with ID: %d\n", *message.ParentId)
+				return apperrors.NewInternal()
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
 	return message, nil
 }
 
 
-func (r *messageRepository) GetMessagesInGroup(groupId, limit, page int) ([]models.Message, error) {
+// checkAttachment makes sure the attachment was uploaded by the sender, matches the
+// message's content type and has not been sent before
+func (r *messageRepository) checkAttachment(message *models.Message) error {
+	attachment := &models.Attachment{}
+
+	if err := r.DB.Preload("Thumbnails").Where("id = ? AND user_id = ?", *message.AttachmentId, message.UserId).First(&attachment).Error; err != nil {
+		log.Printf("Could not find attachment with ID: %d\n", *message.AttachmentId)
+		return apperrors.NewBadRequest("Could not find attachment with provided ID")
+	}
+
+	if attachment.Kind != message.ContentType {
+		return apperrors.NewBadRequest("Attachment cannot be sent as a " + message.ContentType + " message")
+	}
+
+	var count int64
+
+	if err := r.DB.Unscoped().Model(&models.Message{}).Where("attachment_id = ?", attachment.ID).Count(&count).Error; err != nil {
+		log.Printf("Could not check usage of attachment with ID: %d\n", attachment.ID)
+		return apperrors.NewInternal()
+	}
+
+	if count > 0 {
+		return apperrors.NewBadRequest("This attachment has already been sent")
+	}
+
+	message.Attachment = attachment
+	return nil
+}
+
+
+// nextMessageSeq bumps the group's message counter. The row lock it takes is held
+// until the transaction ends, so sequence numbers are handed out in commit order.
+func nextMessageSeq(tx *gorm.DB, groupId uint) (int64, error) {
+	var seq int64
+
+	if err := tx.Raw("UPDATE groups SET message_seq = message_seq + 1 WHERE id = ? RETURNING message_seq", groupId).
+		Scan(&seq).Error; err != nil {
+		log.Printf("Could not allocate message sequence for group with ID: %d\n", groupId)
+		return 0, apperrors.NewInternal()
+	}
+
+	return seq, nil
+}
+
+
+// checkParent makes sure a reply targets a top-level message in the same group.
+// Threads are one level deep, so replies cannot be replied to.
+func (r *messageRepository) checkParent(message *models.Message) error {
+	parent, err := r.GetMessageById(int(*message.ParentId))
+
+	if err != nil || parent.GroupId != message.GroupId {
+		log.Printf("Could not find parent message with ID: %d\n", *message.ParentId)
+		return apperrors.NewBadRequest("Could not find the message being replied to")
+	}
+
+	if parent.IsReply() {
+		log.Print("Cannot reply to a thread reply")
+		return apperrors.NewBadRequest("Replies can only be made to top-level messages")
+	}
+
+	if parent.IsRemoved() {
+		log.Print("Cannot reply to a removed message")
+		return apperrors.NewBadRequest("This message was removed by a moderator")
+	}
+
+	return nil
+}
+
+
+func currentMessageSeq(tx *gorm.DB, groupId uint) (int64, error) {
+	var seq int64
+
+	if err := tx.Model(&models.Group{}).Where("id = ?", groupId).Pluck("message_seq", &seq).Error; err != nil {
+		log.Printf("Could not get message sequence for group with ID: %d\n", groupId)
+		return 0, apperrors.NewInternal()
+	}
+
+	return seq, nil
+}
+
+
+// messagePage runs a keyset query over messages ordered by their group sequence
+func messagePage(db *gorm.DB, query models.PageQuery, newestFirst bool) (*models.MessagePage, error) {
 	var messages []models.Message
 
-	if err := r.DB.Where("group_id = ?", groupId).Find(&messages).Error; err != nil {
+	if err := db.Preload("Attachment.Thumbnails").Scopes(keyset("seq", "id", query, newestFirst)).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+
+	page := &models.MessagePage{ Items: messages }
+
+	if limit := pageSize(query.Limit); len(messages) > limit {
+		page.Items = messages[:limit]
+		page.NextCursor = page.Items[limit-1].Cursor().Encode()
+	}
+
+	return page, nil
+}
+
+
+func (r *messageRepository) GetMessagesInGroup(groupId int, query models.PageQuery) (*models.MessagePage, error) {
+	page, err := messagePage(r.DB.Where("group_id = ? AND parent_id IS NULL", groupId), query, true)
+
+	if err != nil {
 		log.Print("Could not get messages")
-		return messages, apperrors.NewInternal()
+		return nil, apperrors.NewInternal()
 	}
 
-	return messages, nil
+	return page, nil
+}
+
+
+// GetReplies pages through a thread, oldest reply first
+func (r *messageRepository) GetReplies(parentId int, query models.PageQuery) (*models.MessagePage, error) {
+	page, err := messagePage(r.DB.Where("parent_id = ?", parentId), query, false)
+
+	if err != nil {
+		log.Printf("Could not get replies to message with ID: %d\n", parentId)
+		return nil, apperrors.NewInternal()
+	}
+
+	return page, nil
 }
 
 
 func (r *messageRepository) GetMessageById(id int) (*models.Message, error) {
 	message := &models.Message{}
 
-	if err := r.DB.Where("id = ?", id).First(&message).Error; err != nil {
+	if err := r.DB.Preload("Attachment.Thumbnails").Where("id = ?", id).First(&message).Error; err != nil {
 		log.Printf("Could not find message with ID: %d\n", id)
 		return nil, apperrors.NewBadRequest("Could not find message with provided ID")
 	}
@@ -78,68 +244,310 @@ func (r *messageRepository) GetMessageById(id int) (*models.Message, error) {
 }
 
 
+// UpdateMessage saves an edit. The message is read again under lock, so the
+// revision it records is the content this edit replaces.
 func (r *messageRepository) UpdateMessage(message models.Message) error {
 	id := message.ID
-	foundMessage, _ := r.GetMessageById(int(id))
-	userGroup := &models.UserGroup{}
 
-	if foundMessage == nil {
-		log.Printf("Could not find message with ID: %d\n", int(id))
-		return apperrors.NewBadRequest("Could not find message with ID")
-	}
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		foundMessage := &models.Message{}
+		userGroup := &models.UserGroup{}
 
-	if err := r.DB.Where("user_id = ? AND group_id = ? AND banned = ?", foundMessage.UserId, foundMessage.GroupId, false).First(&userGroup).Error; err != nil {
-		log.Print("Unable to edit message")
-		return apperrors.NewBadRequest("Unable to edit message")
-	}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&foundMessage).Error; err != nil {
+			log.Printf("Could not find message with ID: %d\n", int(id))
+			return apperrors.NewBadRequest("Could not find message with ID")
+		}
 
+		if foundMessage.IsRemoved() {
+			log.Printf("Message with ID: %d was removed by a moderator\n", int(id))
+			return apperrors.NewBadRequest("This message was removed by a moderator")
+		}
+
+		if err := tx.Where("user_id = ? AND group_id = ?", foundMessage.UserId, foundMessage.GroupId).Scopes(activeMember(time.Now())).First(&userGroup).Error; err != nil {
+			log.Print("Unable to edit message")
+			return apperrors.NewBadRequest("Unable to edit message")
+		}
 
-	updatedContent := map[string] interface{}{}
 
-	if message.ContentType == "text"{
-		if message.Content != "" {
-			updatedContent["Content"] = message.Content
+		if foundMessage.ContentType != "text" {
+			log.Print("You can only edit text messages")
+			return apperrors.NewBadRequest("You can only edit text messages")
 		}
-	} else {
-		log.Print("You can only edit text messages")
-		return apperrors.NewBadRequest("You can only edit text messages")
-	}
 
-	if err := r.DB.Model(&foundMessage).Updates(updatedContent).Error; err != nil {
-		log.Print("Could not edit message")
-		return apperrors.NewInternal()
+		if message.Content == "" || message.Content == foundMessage.Content {
+			return nil
+		}
+
+		revision := &models.MessageRevision{
+			MessageId: foundMessage.ID,
+			Content: foundMessage.Content,
+		}
+
+		if err := tx.Create(&revision).Error; err != nil {
+			log.Print("Could not save message revision")
+			return apperrors.NewInternal()
+		}
+
+		if err := tx.Model(&foundMessage).Updates(map[string] interface{}{
+			"content": message.Content,
+			"edited": true,
+			"edited_at": time.Now(),
+		}).Error; err != nil {
+			log.Print("Could not edit message")
+			return apperrors.NewInternal()
+		}
+
+		return nil
+	})
+}
+
+
+// GetRevisions lists the earlier versions of a message, oldest first
+func (r *messageRepository) GetRevisions(messageId int) ([]models.MessageRevision, error) {
+	var revisions []models.MessageRevision
+
+	if err := r.DB.Where("message_id = ?", messageId).Order("id asc").Find(&revisions).Error; err != nil {
+		log.Printf("Could not get revisions of message with ID: %d\n", messageId)
+		return revisions, apperrors.NewInternal()
 	}
 
-	return nil
+	return revisions, nil
 }
 
 
 func (r *messageRepository) DeleteMessage(id, userId, groupId int) error {
 	userGroup := &models.UserGroup{}
 
-	if err := r.DB.Where("user_id = ? AND group_id = ? AND banned = ?", userId, groupId, false).First(&userGroup).Error; err != nil {
+	if err := r.DB.Where("user_id = ? AND group_id = ?", userId, groupId).Scopes(activeMember(time.Now())).First(&userGroup).Error; err != nil {
 		log.Print("Unauthorized to delete user message from this group")
 		return apperrors.NewBadRequest("Unauthorized to delete user message from this group")
 	}
 
 
-	if err := r.DB.Where("id = ? AND user_id = ? AND group_id = ?", id, userId, groupId).Delete(&models.Message{}).Error; err != nil {
-		log.Print("Error deleting message")
-		return apperrors.NewInternal()
+	message := &models.Message{}
+
+	if err := r.DB.Where("id = ? AND user_id = ? AND group_id = ?", id, userId, groupId).First(&message).Error; err != nil {
+		log.Printf("Could not find message with ID: %d\n", id)
+		return apperrors.NewBadRequest("Could not find message with provided ID")
 	}
 
-	return nil
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&message).Error; err != nil {
+			log.Print("Error deleting message")
+			return apperrors.NewInternal()
+		}
+
+		if err := unpinDeletedMessage(tx, message.ID); err != nil {
+			log.Printf("Could not unpin message with ID: %d\n", message.ID)
+			return apperrors.NewInternal()
+		}
+
+		if message.AttachmentId != nil {
+			if err := releaseAttachment(tx, *message.AttachmentId); err != nil {
+				log.Printf("Could not release attachment of message with ID: %d\n", message.ID)
+				return apperrors.NewInternal()
+			}
+		}
+
+		// The thread's latest reply is whichever is left once this one is gone
+		if message.IsReply() {
+			lastReply := tx.Model(&models.Message{}).Select("MAX(created_at)").Where("parent_id = ?", *message.ParentId)
+
+			if err := tx.Model(&models.Message{}).Where("id = ? AND reply_count > 0", *message.ParentId).UpdateColumns(map[string] interface{}{
+				"reply_count": gorm.Expr("reply_count - 1"),
+				"last_reply_at": lastReply,
+			}).Error; err != nil {
+				log.Printf("Could not update thread of message with ID: %d\n", *message.ParentId)
+				return apperrors.NewInternal()
+			}
+		}
+
+		return nil
+	})
+}
+
+
+func (r *messageRepository) GetUserMessagesInGroup(userId, groupId int, query models.PageQuery) (*models.MessagePage, error){
+	page, err := messagePage(r.DB.Where("user_id = ? AND group_id = ?", userId, groupId), query, true)
+
+	if err != nil {
+		log.Print("Could not get user messages from group")
+		return nil, apperrors.NewBadRequest("Could not get user messages from group")
+	}
+
+	return page, nil
+}
+
+
+// RemoveMessage replaces another member's message with a tombstone.
+// The original content is kept only in the moderation log.
+func (r *messageRepository) RemoveMessage(message *models.Message, actorId int, reason string) (*models.Message, error) {
+	if message.IsRemoved() {
+		log.Printf("Message with ID: %d was already removed\n", message.ID)
+		return nil, apperrors.NewBadRequest("This message was already removed")
+	}
+
+	before := map[string] interface{}{
+		"content": message.Content,
+		"contentType": message.ContentType,
+		"attachmentUrl": message.AttachmentUrl,
+		"attachmentId": message.AttachmentId,
+		"payload": message.Payload,
+	}
+
+	now := time.Now()
+	actor := uint(actorId)
+	attachmentId := message.AttachmentId
+
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(message).Updates(map[string] interface{}{
+			"content": models.RemovedMessageContent,
+			"attachment_url": nil,
+			"attachment_id": nil,
+			"payload": nil,
+			"removed_at": now,
+			"removed_by_id": actor,
+		}).Error; err != nil {
+			log.Printf("Could not remove message with ID: %d\n", message.ID)
+			return apperrors.NewInternal()
+		}
+
+		if attachmentId != nil {
+			if err := releaseAttachment(tx, *attachmentId); err != nil {
+				log.Printf("Could not release attachment of message with ID: %d\n", message.ID)
+				return apperrors.NewInternal()
+			}
+		}
+
+		if err := unpinDeletedMessage(tx, message.ID); err != nil {
+			log.Printf("Could not unpin message with ID: %d\n", message.ID)
+			return apperrors.NewInternal()
+		}
+
+		entry := &models.ModerationLog{
+			GroupId: message.GroupId,
+			ActorId: actor,
+			TargetUserId: uintPtr(message.UserId),
+			TargetMessageId: uintPtr(message.ID),
+			Action: models.DeleteMessageModerationAction,
+			Reason: reason,
+		}
+
+		return recordModerationLog(tx, entry, before, nil)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	message.Content = models.RemovedMessageContent
+	message.AttachmentUrl = nil
+	message.AttachmentId = nil
+	message.Attachment = nil
+	message.Payload = nil
+	message.Poll = nil
+	message.RemovedAt = &now
+	message.RemovedById = &actor
+
+	return message, nil
 }
 
 
-func (r *messageRepository) GetUserMessagesInGroup(userId, groupId, limit, page int) ([]models.Message, error){
+// escapedContent is the message text with HTML escaped, so the only markup in
+// search snippets is the <mark> tags around matches
+const escapedContent = `replace(replace(replace(replace(replace(messages.content,
+	'&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;')`
+
+
+// SearchMessages ranks messages against a websearch-style query using the
+// search_vector column kept up to date by Postgres. Only groups where the user is
+// an active member are searched, and removed messages never match.
+func (r *messageRepository) SearchMessages(userId int, search models.MessageSearch) ([]models.SearchResult, error) {
+	memberGroups := r.DB.Model(&models.UserGroup{}).Select("group_id").
+		Where("user_id = ?", userId).Scopes(activeMember(time.Now()))
+
+	query := r.DB.Table("messages").
+		Select(`messages.id, ts_rank(messages.search_vector, query) AS rank,
+			ts_headline('english', `+escapedContent+`, query, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2') AS snippet`).
+		Joins("CROSS JOIN websearch_to_tsquery('english', ?) AS query", search.Query).
+		Where("messages.search_vector @@ query").
+		Where("messages.group_id IN (?)", memberGroups).
+		Where("messages.deleted_at IS NULL AND messages.removed_at IS NULL")
+
+	if search.GroupId != 0 {
+		query = query.Where("messages.group_id = ?", search.GroupId)
+	}
+
+	if search.AuthorId != 0 {
+		query = query.Where("messages.user_id = ?", search.AuthorId)
+
+		// Searching by author would unmask pseudonyms, except for the caller's own messages
+		if search.AuthorId != userId {
+			query = query.Where("messages.group_id NOT IN (?)",
+				r.DB.Model(&models.Group{}).Select("id").Where("anonymous = ?", true))
+		}
+	}
+
+	if search.ContentType != "" {
+		query = query.Where("messages.content_type = ?", search.ContentType)
+	}
+
+	if search.From != nil {
+		query = query.Where("messages.created_at >= ?", *search.From)
+	}
+
+	if search.To != nil {
+		query = query.Where("messages.created_at < ?", *search.To)
+	}
+
+	if search.HasAttachment != nil {
+		if *search.HasAttachment {
+			query = query.Where("(messages.attachment_url IS NOT NULL OR messages.attachment_id IS NOT NULL)")
+		} else {
+			query = query.Where("messages.attachment_url IS NULL AND messages.attachment_id IS NULL")
+		}
+	}
+
+	var hits []struct {
+		ID      uint
+		Rank    float64
+		Snippet string
+	}
+
+	if err := query.Scopes(paginate(search.Limit, search.Page)).
+		Order("rank DESC, messages.id DESC").Scan(&hits).Error; err != nil {
+		log.Printf("Could not search messages for user with ID: %d\n", userId)
+		return nil, apperrors.NewInternal()
+	}
+
+	results := make([]models.SearchResult, 0, len(hits))
+
+	if len(hits) == 0 {
+		return results, nil
+	}
+
+	ids := make([]uint, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+
 	var messages []models.Message
 
+	if err := r.DB.Preload("Attachment.Thumbnails").Where("id IN ?", ids).Find(&messages).Error; err != nil {
+		log.Print("Could not load search results")
+		return nil, apperrors.NewInternal()
+	}
+
+	byId := map[uint]models.Message{}
+	for _, message := range messages {
+		byId[message.ID] = message
+	}
 
-	if err := r.DB.Where("user_id = ? AND group_id = ?", userId, groupId).Find(&messages).Error; err != nil {
-		log.Print("Could not get user messages from group")
-		return messages, apperrors.NewBadRequest("Could not get user messages from group")
+	for _, hit := range hits {
+		if message, ok := byId[hit.ID]; ok {
+			results = append(results, models.SearchResult{ Message: message, Rank: hit.Rank, Snippet: hit.Snippet })
+		}
 	}
 
-	return messages, nil
-}
\ No newline at end of file
+	return results, nil
+}
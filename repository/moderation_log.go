@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"darkoo/models"
+	"darkoo/apperrors"
+
+	"log"
+
+	"gorm.io/gorm"
+)
+
+
+// recordModerationLog appends an entry to the group's moderation log.
+// It must run inside the transaction that performs the action.
+func recordModerationLog(tx *gorm.DB, entry *models.ModerationLog, before, after interface{}) error {
+	var err error
+
+	if entry.Before, err = models.NewJSON(before); err != nil {
+		log.Printf("Could not encode moderation log values: %v\n", err)
+		return apperrors.NewInternal()
+	}
+
+	if entry.After, err = models.NewJSON(after); err != nil {
+		log.Printf("Could not encode moderation log values: %v\n", err)
+		return apperrors.NewInternal()
+	}
+
+	if err := tx.Create(entry).Error; err != nil {
+		log.Printf("Could not record %s moderation log for group %d\n", entry.Action, entry.GroupId)
+		return apperrors.NewInternal()
+	}
+
+	return nil
+}
+
+
+func uintPtr(value uint) *uint {
+	return &value
+}
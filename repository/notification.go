@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"log"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+
+type notificationRepository struct {
+	DB *gorm.DB
+}
+
+
+func NewNotificationRepository(db *gorm.DB) models.INotificationRepository {
+	return &notificationRepository{ DB: db }
+}
+
+
+func (r *notificationRepository) CreateNotifications(notifications []models.Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	if err := r.DB.Create(&notifications).Error; err != nil {
+		log.Print("Could not create notifications")
+		return apperrors.NewInternal()
+	}
+
+	return nil
+}
+
+
+// GetNotifications pages through a user's inbox, newest first
+func (r *notificationRepository) GetNotifications(userId int, unreadOnly bool, query models.PageQuery) (*models.NotificationPage, error) {
+	var notifications []models.Notification
+
+	db := r.DB.Where("user_id = ?", userId)
+
+	if unreadOnly {
+		db = db.Where("read_at IS NULL")
+	}
+
+	if err := db.Scopes(keyset("id", "id", query, true)).Find(&notifications).Error; err != nil {
+		log.Printf("Could not get notifications for user with ID: %d\n", userId)
+		return nil, apperrors.NewInternal()
+	}
+
+	page := &models.NotificationPage{ Items: notifications }
+
+	if limit := pageSize(query.Limit); len(notifications) > limit {
+		page.Items = notifications[:limit]
+		last := page.Items[limit-1]
+		page.NextCursor = models.Cursor{ Key: int64(last.ID), ID: last.ID }.Encode()
+	}
+
+	return page, nil
+}
+
+
+func (r *notificationRepository) MarkRead(userId, id int) error {
+	result := r.DB.Model(&models.Notification{}).
+		Where("id = ? AND user_id = ?", id, userId).
+		Update("read_at", gorm.Expr("COALESCE(read_at, ?)", time.Now()))
+
+	if result.Error != nil {
+		log.Printf("Could not mark notification with ID: %d as read\n", id)
+		return apperrors.NewInternal()
+	}
+
+	if result.RowsAffected == 0 {
+		return apperrors.NewNotFound("Notification", strconv.Itoa(id))
+	}
+
+	return nil
+}
+
+
+func (r *notificationRepository) MarkAllRead(userId int) error {
+	if err := r.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userId).
+		Update("read_at", time.Now()).Error; err != nil {
+		log.Printf("Could not mark notifications as read for user with ID: %d\n", userId)
+		return apperrors.NewInternal()
+	}
+
+	return nil
+}
+
+
+func (r *notificationRepository) GetUnreadCount(userId int) (int64, error) {
+	var count int64
+
+	if err := r.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userId).
+		Count(&count).Error; err != nil {
+		log.Printf("Could not count notifications for user with ID: %d\n", userId)
+		return 0, apperrors.NewInternal()
+	}
+
+	return count, nil
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"fmt"
+	"log"
+	"strconv"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+
+type pinRepository struct {
+	DB *gorm.DB
+}
+
+
+func NewPinRepository(db *gorm.DB) models.IPinRepository {
+	return &pinRepository{ DB: db }
+}
+
+
+// PinMessage pins a message unless the group already has maxPins pinned.
+// The group row is locked so concurrent pins can't overshoot the limit.
+func (r *pinRepository) PinMessage(pin *models.PinnedMessage, maxPins int) (*models.PinnedMessage, error) {
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{ Strength: "UPDATE" }).
+			Where("id = ?", pin.GroupId).First(&models.Group{}).Error; err != nil {
+			log.Printf("Could not find group with ID: %d\n", pin.GroupId)
+			return apperrors.NewBadRequest("Could not find group with provided ID")
+		}
+
+		var count int64
+
+		if err := tx.Model(&models.PinnedMessage{}).Scopes(pinnedMessageExists).
+			Where("pinned_messages.group_id = ?", pin.GroupId).Count(&count).Error; err != nil {
+			log.Printf("Could not count pinned messages in group with ID: %d\n", pin.GroupId)
+			return apperrors.NewInternal()
+		}
+
+		if count >= int64(maxPins) {
+			return apperrors.NewBadRequest(fmt.Sprintf("A group can have at most %d pinned messages", maxPins))
+		}
+
+		result := tx.Omit(clause.Associations).Clauses(clause.OnConflict{ DoNothing: true }).Create(pin)
+
+		if result.Error != nil {
+			log.Printf("Could not pin message with ID: %d\n", pin.MessageId)
+			return apperrors.NewInternal()
+		}
+
+		if result.RowsAffected == 0 {
+			return apperrors.NewConflict("PinnedMessage", strconv.Itoa(int(pin.MessageId)))
+		}
+
+		entry := &models.ModerationLog{
+			GroupId: pin.GroupId,
+			ActorId: pin.PinnedById,
+			TargetMessageId: uintPtr(pin.MessageId),
+			Action: models.PinMessageModerationAction,
+		}
+
+		return recordModerationLog(tx, entry, nil, nil)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pin, nil
+}
+
+
+func (r *pinRepository) UnpinMessage(actorId int, message *models.Message) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("message_id = ?", message.ID).Delete(&models.PinnedMessage{})
+
+		if result.Error != nil {
+			log.Printf("Could not unpin message with ID: %d\n", message.ID)
+			return apperrors.NewInternal()
+		}
+
+		if result.RowsAffected == 0 {
+			return apperrors.NewBadRequest("This message is not pinned")
+		}
+
+		entry := &models.ModerationLog{
+			GroupId: message.GroupId,
+			ActorId: uint(actorId),
+			TargetMessageId: uintPtr(message.ID),
+			Action: models.UnpinMessageModerationAction,
+		}
+
+		return recordModerationLog(tx, entry, nil, nil)
+	})
+}
+
+
+// GetPinnedMessages lists a group's pins, most recently pinned first
+func (r *pinRepository) GetPinnedMessages(groupId int) ([]models.PinnedMessage, error) {
+	var pins []models.PinnedMessage
+
+	if err := r.DB.Preload("Message.Attachment.Thumbnails").Scopes(pinnedMessageExists).
+		Where("pinned_messages.group_id = ?", groupId).
+		Order("pinned_messages.id desc").Find(&pins).Error; err != nil {
+		log.Printf("Could not get pinned messages for group with ID: %d\n", groupId)
+		return pins, apperrors.NewInternal()
+	}
+
+	return pins, nil
+}
+
+
+// pinnedMessageExists leaves out pins whose message has been deleted
+func pinnedMessageExists(db *gorm.DB) *gorm.DB {
+	return db.Joins("JOIN messages ON messages.id = pinned_messages.message_id AND messages.deleted_at IS NULL")
+}
+
+
+// unpinDeletedMessage drops the pin of a message that is deleted or removed in tx
+func unpinDeletedMessage(tx *gorm.DB, messageId uint) error {
+	return tx.Unscoped().Where("message_id = ?", messageId).Delete(&models.PinnedMessage{}).Error
+}
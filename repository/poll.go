@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+
+type pollRepository struct {
+	DB *gorm.DB
+}
+
+
+func NewPollRepository(db *gorm.DB) models.IPollRepository {
+	return &pollRepository{ DB: db }
+}
+
+
+func (r *pollRepository) GetPollByMessageId(messageId int) (*models.Poll, error) {
+	poll := &models.Poll{}
+
+	err := r.DB.Preload("Options", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).Where("message_id = ?", messageId).First(&poll).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, apperrors.NewBadRequest("This message is not a poll")
+	}
+	if err != nil {
+		log.Printf("Could not get poll of message with ID: %d\n", messageId)
+		return nil, apperrors.NewInternal()
+	}
+
+	return poll, nil
+}
+
+
+func (r *pollRepository) GetPollsByMessageIds(messageIds []uint) ([]models.Poll, error) {
+	polls := []models.Poll{}
+
+	if len(messageIds) == 0 {
+		return polls, nil
+	}
+
+	if err := r.DB.Preload("Options", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).Where("message_id IN ?", messageIds).Find(&polls).Error; err != nil {
+		log.Print("Could not get polls")
+		return polls, apperrors.NewInternal()
+	}
+
+	return polls, nil
+}
+
+
+// GetPollVotes returns every vote cast in the polls, oldest first
+func (r *pollRepository) GetPollVotes(pollIds []uint) ([]models.PollVote, error) {
+	votes := []models.PollVote{}
+
+	if len(pollIds) == 0 {
+		return votes, nil
+	}
+
+	if err := r.DB.Where("poll_id IN ?", pollIds).Order("id").Find(&votes).Error; err != nil {
+		log.Print("Could not get poll votes")
+		return votes, apperrors.NewInternal()
+	}
+
+	return votes, nil
+}
+
+
+// ReplaceVotes swaps the user's votes in the poll for the given options, or
+// withdraws them when there are none. The poll row is locked so a vote can't slip
+// in alongside another change of the same user's mind, or after the poll closes.
+func (r *pollRepository) ReplaceVotes(poll *models.Poll, userId int, optionIds []uint, now time.Time) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		current := &models.Poll{}
+
+		if err := tx.Clauses(clause.Locking{ Strength: "UPDATE" }).
+			Where("id = ?", poll.ID).First(&current).Error; err != nil {
+			log.Printf("Could not find poll with ID: %d\n", poll.ID)
+			return apperrors.NewBadRequest("Could not find poll")
+		}
+
+		if current.IsClosed(now) {
+			return apperrors.NewBadRequest("This poll is closed")
+		}
+
+		if err := tx.Unscoped().Where("poll_id = ? AND user_id = ?", poll.ID, userId).
+			Delete(&models.PollVote{}).Error; err != nil {
+			log.Printf("Could not clear votes in poll with ID: %d\n", poll.ID)
+			return apperrors.NewInternal()
+		}
+
+		if len(optionIds) == 0 {
+			return nil
+		}
+
+		votes := make([]models.PollVote, len(optionIds))
+		for i, optionId := range optionIds {
+			votes[i] = models.PollVote{ PollId: poll.ID, OptionId: optionId, UserId: uint(userId) }
+		}
+
+		if err := tx.Omit(clause.Associations).Create(&votes).Error; err != nil {
+			log.Printf("Could not vote in poll with ID: %d\n", poll.ID)
+			return apperrors.NewInternal()
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"log"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+
+type reactionRepository struct {
+	DB *gorm.DB
+}
+
+
+func NewReactionRepository(db *gorm.DB) models.IReactionRepository {
+	return &reactionRepository{ DB: db }
+}
+
+
+// AddReaction is idempotent, reacting twice with the same emoji keeps a single reaction
+func (r *reactionRepository) AddReaction(reaction *models.Reaction) error {
+	if err := r.DB.Clauses(clause.OnConflict{ DoNothing: true }).Create(reaction).Error; err != nil {
+		log.Printf("Could not add reaction to message with ID: %d\n", reaction.MessageId)
+		return apperrors.NewInternal()
+	}
+
+	return nil
+}
+
+
+func (r *reactionRepository) RemoveReaction(messageId, userId int, emoji string) error {
+	result := r.DB.Unscoped().
+		Where("message_id = ? AND user_id = ? AND emoji = ?", messageId, userId, emoji).
+		Delete(&models.Reaction{})
+
+	if result.Error != nil {
+		log.Printf("Could not remove reaction from message with ID: %d\n", messageId)
+		return apperrors.NewInternal()
+	}
+
+	if result.RowsAffected == 0 {
+		return apperrors.NewBadRequest("You have not reacted with this emoji")
+	}
+
+	return nil
+}
+
+
+// GetReactionSummaries counts reactions per emoji for each message, in the order
+// each emoji was first used on it
+func (r *reactionRepository) GetReactionSummaries(messageIds []uint, userId int) (map[uint][]models.ReactionSummary, error) {
+	summaries := map[uint][]models.ReactionSummary{}
+
+	if len(messageIds) == 0 {
+		return summaries, nil
+	}
+
+	var rows []struct {
+		MessageId uint
+		models.ReactionSummary
+	}
+
+	if err := r.DB.Model(&models.Reaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, BOOL_OR(user_id = ?) AS reacted", userId).
+		Where("message_id IN ?", messageIds).
+		Group("message_id, emoji").
+		Order("message_id, MIN(id)").
+		Scan(&rows).Error; err != nil {
+		log.Print("Could not get reactions")
+		return summaries, apperrors.NewInternal()
+	}
+
+	for _, row := range rows {
+		summaries[row.MessageId] = append(summaries[row.MessageId], row.ReactionSummary)
+	}
+
+	return summaries, nil
+}
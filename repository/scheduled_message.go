@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+
+type scheduledMessageRepository struct {
+	DB *gorm.DB
+}
+
+
+func NewScheduledMessageRepository(db *gorm.DB) models.IScheduledMessageRepository {
+	return &scheduledMessageRepository{ DB: db }
+}
+
+
+func (r *scheduledMessageRepository) CreateScheduledMessage(scheduled *models.ScheduledMessage) (*models.ScheduledMessage, error) {
+	if err := r.DB.Omit(clause.Associations).Create(&scheduled).Error; err != nil {
+		log.Print("Could not schedule message")
+		return nil, apperrors.NewInternal()
+	}
+
+	return scheduled, nil
+}
+
+
+func (r *scheduledMessageRepository) GetScheduledMessage(userId, id int) (*models.ScheduledMessage, error) {
+	scheduled := &models.ScheduledMessage{}
+
+	if err := r.DB.Where("id = ? AND user_id = ?", id, userId).First(&scheduled).Error; err != nil {
+		log.Printf("Could not find scheduled message with ID: %d\n", id)
+		return nil, apperrors.NewNotFound("Scheduled message", strconv.Itoa(id))
+	}
+
+	return scheduled, nil
+}
+
+
+// GetScheduledMessages lists the user's pending messages in the order they will
+// go out, in every group when groupId is 0
+func (r *scheduledMessageRepository) GetScheduledMessages(userId, groupId int) ([]models.ScheduledMessage, error) {
+	scheduled := []models.ScheduledMessage{}
+
+	db := r.DB.Where("user_id = ? AND status = ?", userId, models.PendingScheduledMessage)
+	if groupId != 0 {
+		db = db.Where("group_id = ?", groupId)
+	}
+
+	if err := db.Order("send_at, id").Find(&scheduled).Error; err != nil {
+		log.Print("Could not get scheduled messages")
+		return scheduled, apperrors.NewInternal()
+	}
+
+	return scheduled, nil
+}
+
+
+// UpdateScheduledMessage saves changes to a message that is still pending, so it
+// can't be changed once the dispatcher has picked it up
+func (r *scheduledMessageRepository) UpdateScheduledMessage(scheduled *models.ScheduledMessage) error {
+	result := r.DB.Model(&models.ScheduledMessage{}).
+		Where("id = ? AND status = ?", scheduled.ID, models.PendingScheduledMessage).
+		Select("content", "content_type", "attachment_id", "payload", "send_at", "updated_at").
+		Updates(scheduled)
+
+	if result.Error != nil {
+		log.Printf("Could not update scheduled message with ID: %d\n", scheduled.ID)
+		return apperrors.NewInternal()
+	}
+
+	if result.RowsAffected == 0 {
+		return apperrors.NewConflictWithMessage("This message is no longer pending and cannot be changed")
+	}
+
+	return nil
+}
+
+
+func (r *scheduledMessageRepository) CancelScheduledMessage(userId, id int) error {
+	result := r.DB.Model(&models.ScheduledMessage{}).
+		Where("id = ? AND user_id = ? AND status = ?", id, userId, models.PendingScheduledMessage).
+		UpdateColumns(map[string]interface{}{ "status": models.CancelledScheduledMessage, "updated_at": time.Now() })
+
+	if result.Error != nil {
+		log.Printf("Could not cancel scheduled message with ID: %d\n", id)
+		return apperrors.NewInternal()
+	}
+
+	if result.RowsAffected == 0 {
+		return apperrors.NewConflictWithMessage("This message is no longer pending and cannot be cancelled")
+	}
+
+	return nil
+}
+
+
+// ClaimDueScheduledMessages moves messages due by now to sending and returns them,
+// along with claims older than staleBefore, whose dispatcher is assumed to have
+// died. Rows another dispatcher has locked are skipped rather than waited on.
+func (r *scheduledMessageRepository) ClaimDueScheduledMessages(now, staleBefore time.Time, limit int) ([]models.ScheduledMessage, error) {
+	claimed := []models.ScheduledMessage{}
+
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{ Strength: "UPDATE", Options: "SKIP LOCKED" }).
+			Where("(status = ? AND send_at <= ?) OR (status = ? AND claimed_at < ?)",
+				models.PendingScheduledMessage, now, models.SendingScheduledMessage, staleBefore).
+			Order("send_at, id").Limit(limit).Find(&claimed).Error; err != nil {
+			return err
+		}
+
+		if len(claimed) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(claimed))
+		for i := range claimed {
+			ids[i] = claimed[i].ID
+			claimed[i].Status = models.SendingScheduledMessage
+			claimed[i].ClaimedAt = &now
+			claimed[i].Attempts++
+		}
+
+		return tx.Model(&models.ScheduledMessage{}).Where("id IN ?", ids).
+			UpdateColumns(map[string]interface{}{
+				"status": models.SendingScheduledMessage,
+				"claimed_at": now,
+				"attempts": gorm.Expr("attempts + 1"),
+			}).Error
+	})
+
+	if err != nil {
+		log.Print("Could not claim scheduled messages")
+		return nil, apperrors.NewInternal()
+	}
+
+	return claimed, nil
+}
+
+
+// GetMessageByScheduledMessageId finds the message a scheduled one was sent as,
+// nil if it hasn't been
+func (r *scheduledMessageRepository) GetMessageByScheduledMessageId(id uint) (*models.Message, error) {
+	message := &models.Message{}
+
+	err := r.DB.Where("scheduled_message_id = ?", id).First(&message).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Could not look up message of scheduled message with ID: %d\n", id)
+		return nil, apperrors.NewInternal()
+	}
+
+	return message, nil
+}
+
+
+// CompleteScheduledMessage records the outcome of a claim: sent as messageId, or
+// failed for the reason given when there is no message
+func (r *scheduledMessageRepository) CompleteScheduledMessage(scheduled *models.ScheduledMessage, messageId *uint, failure string) error {
+	status := models.SentScheduledMessage
+	if messageId == nil {
+		status = models.FailedScheduledMessage
+	}
+
+	if err := r.DB.Model(&models.ScheduledMessage{}).
+		Where("id = ? AND status = ?", scheduled.ID, models.SendingScheduledMessage).
+		UpdateColumns(map[string]interface{}{
+			"status": status,
+			"message_id": messageId,
+			"failure": failure,
+			"updated_at": time.Now(),
+		}).Error; err != nil {
+		log.Printf("Could not complete scheduled message with ID: %d\n", scheduled.ID)
+		return apperrors.NewInternal()
+	}
+
+	scheduled.Status = status
+	scheduled.MessageId = messageId
+	scheduled.Failure = failure
+	return nil
+}
+
+
+// ReleaseScheduledMessage hands a claim back to be tried again once retryAt has
+// passed, so a failing send isn't retried again straight away
+func (r *scheduledMessageRepository) ReleaseScheduledMessage(scheduled *models.ScheduledMessage, retryAt time.Time) error {
+	if err := r.DB.Model(&models.ScheduledMessage{}).
+		Where("id = ? AND status = ?", scheduled.ID, models.SendingScheduledMessage).
+		UpdateColumns(map[string]interface{}{
+			"status": models.PendingScheduledMessage,
+			"claimed_at": nil,
+			"send_at": retryAt,
+		}).Error; err != nil {
+		log.Printf("Could not release scheduled message with ID: %d\n", scheduled.ID)
+		return apperrors.NewInternal()
+	}
+
+	scheduled.Status = models.PendingScheduledMessage
+	scheduled.ClaimedAt = nil
+	scheduled.SendAt = retryAt
+	return nil
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"darkoo/models"
+
+	"gorm.io/gorm"
+)
+
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+
+// paginate applies limit/page offset pagination. Pages start at 1.
+func paginate(limit, page int) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		limit := pageSize(limit)
+
+		if page < 1 {
+			page = 1
+		}
+
+		return db.Offset((page - 1) * limit).Limit(limit)
+	}
+}
+
+
+// pageSize clamps a requested page size to the allowed range
+func pageSize(limit int) int {
+	if limit <= 0 {
+		return defaultPageSize
+	}
+
+	if limit > maxPageSize {
+		return maxPageSize
+	}
+
+	return limit
+}
+
+
+// keyset applies cursor pagination ordered by (keyColumn, idColumn). One extra row is
+// fetched so callers can tell whether another page follows. Without a cursor the list
+// starts from the newest end when newestFirst is set, otherwise from the oldest.
+func keyset(keyColumn, idColumn string, query models.PageQuery, newestFirst bool) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		descending := newestFirst
+
+		switch {
+		case query.After != nil:
+			descending = false
+			db = db.Where(fmt.Sprintf("(%s, %s) > (?, ?)", keyColumn, idColumn), query.After.Key, query.After.ID)
+
+		case query.Before != nil:
+			descending = true
+			db = db.Where(fmt.Sprintf("(%s, %s) < (?, ?)", keyColumn, idColumn), query.Before.Key, query.Before.ID)
+		}
+
+		order := "ASC"
+		if descending {
+			order = "DESC"
+		}
+
+		return db.Order(fmt.Sprintf("%s %s, %s %s", keyColumn, order, idColumn, order)).Limit(pageSize(query.Limit) + 1)
+	}
+}
+
+
+// publicUser loads only the parts of a user other people may see, for preloading
+// the user behind a record
+func publicUser(db *gorm.DB) *gorm.DB {
+	return db.Select("id", "uuid", "user_name", "image_num")
+}
+
+
+// activeMember filters user_groups rows down to members who are not under a ban.
+// Timed bans stop matching once banned_until has passed.
+func activeMember(now time.Time) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("(banned = ? OR (banned_until IS NOT NULL AND banned_until <= ?))", false, now)
+	}
+}
+
+
+// activeSanction filters sanctions that have neither been lifted nor expired
+func activeSanction(now time.Time) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", now)
+	}
+}
@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+
+type uploadRepository struct {
+	DB *gorm.DB
+}
+
+
+func NewUploadRepository(db *gorm.DB) models.IUploadRepository {
+	return &uploadRepository{ DB: db }
+}
+
+
+func (r *uploadRepository) CreateUploadSession(session *models.UploadSession) (*models.UploadSession, error) {
+	if err := r.DB.Omit("User", "Attachment").Create(session).Error; err != nil {
+		log.Print("Could not save upload session")
+		return nil, apperrors.NewInternal()
+	}
+
+	return session, nil
+}
+
+
+// GetUploadSession only finds sessions belonging to the user, so other people's
+// uploads look like they don't exist
+func (r *uploadRepository) GetUploadSession(userId, id int) (*models.UploadSession, error) {
+	session := &models.UploadSession{}
+
+	if err := r.DB.Preload("Attachment.Thumbnails").
+		Where("id = ? AND user_id = ?", id, userId).First(&session).Error; err != nil {
+		log.Printf("Could not find upload session with ID: %d\n", id)
+		return nil, apperrors.NewNotFound("Upload", strconv.Itoa(id))
+	}
+
+	return session, nil
+}
+
+
+// AppendUploadChunk records a stored chunk and moves the session's offset past it.
+// The offset only moves if nobody else appended first, otherwise a conflict is
+// returned and the chunk is not recorded.
+func (r *uploadRepository) AppendUploadChunk(session *models.UploadSession, chunk *models.UploadChunk, expiresAt time.Time) error {
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.UploadSession{}).
+			Where("id = ? AND \"offset\" = ? AND attachment_id IS NULL", session.ID, chunk.Offset).
+			UpdateColumns(map[string]interface{}{
+				"offset": gorm.Expr("\"offset\" + ?", chunk.Size),
+				"expires_at": expiresAt,
+				"updated_at": time.Now(),
+			})
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return apperrors.NewConflictWithMessage(fmt.Sprintf("Upload %d is no longer at offset %d", session.ID, chunk.Offset))
+		}
+
+		chunk.UploadSessionId = session.ID
+		return tx.Create(chunk).Error
+	})
+
+	if err != nil {
+		log.Printf("Could not append chunk to upload session with ID: %d\n", session.ID)
+		return apperrors.GetAppError(err, "Could not save uploaded chunk")
+	}
+
+	session.Offset = chunk.Offset + chunk.Size
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
+
+func (r *uploadRepository) GetUploadChunks(sessionId uint) ([]models.UploadChunk, error) {
+	var chunks []models.UploadChunk
+
+	if err := r.DB.Where("upload_session_id = ?", sessionId).Order("\"offset\" asc").Find(&chunks).Error; err != nil {
+		log.Printf("Could not get chunks of upload session with ID: %d\n", sessionId)
+		return nil, apperrors.NewInternal()
+	}
+
+	return chunks, nil
+}
+
+
+// ClaimUploadSession marks a finished session as being completed, so only one
+// request joins its chunks. Claims older than staleBefore were left by a request
+// that stopped partway and can be taken over.
+func (r *uploadRepository) ClaimUploadSession(session *models.UploadSession, now, staleBefore time.Time) error {
+	// Postgres keeps microseconds, and releasing matches the claim by its time
+	now = now.Truncate(time.Microsecond)
+
+	result := r.DB.Model(&models.UploadSession{}).
+		Where("id = ? AND attachment_id IS NULL AND (completing_at IS NULL OR completing_at < ?)", session.ID, staleBefore).
+		UpdateColumns(map[string]interface{}{
+			"completing_at": now,
+			"updated_at": now,
+		})
+
+	if result.Error != nil {
+		log.Printf("Could not claim upload session with ID: %d\n", session.ID)
+		return apperrors.NewInternal()
+	}
+
+	if result.RowsAffected == 0 {
+		return apperrors.NewConflictWithMessage(fmt.Sprintf("Upload %d is already being completed", session.ID))
+	}
+
+	session.CompletingAt = &now
+	return nil
+}
+
+
+// ReleaseUploadSession gives up a claim so that completing can be retried
+func (r *uploadRepository) ReleaseUploadSession(session *models.UploadSession) error {
+	if err := r.DB.Model(&models.UploadSession{}).Where("id = ? AND completing_at = ?", session.ID, session.CompletingAt).
+		UpdateColumn("completing_at", nil).Error; err != nil {
+		log.Printf("Could not release upload session with ID: %d\n", session.ID)
+		return apperrors.NewInternal()
+	}
+
+	session.CompletingAt = nil
+	return nil
+}
+
+
+// CompleteUploadSession links the finished attachment and drops the chunk records.
+// It fails with a conflict if another request completed the session first.
+func (r *uploadRepository) CompleteUploadSession(session *models.UploadSession, attachment *models.Attachment, expiresAt time.Time) error {
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.UploadSession{}).
+			Where("id = ? AND attachment_id IS NULL", session.ID).
+			UpdateColumns(map[string]interface{}{
+				"attachment_id": attachment.ID,
+				"completing_at": nil,
+				"expires_at": expiresAt,
+				"updated_at": time.Now(),
+			})
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return apperrors.NewConflictWithMessage(fmt.Sprintf("Upload %d has already been completed", session.ID))
+		}
+
+		return tx.Unscoped().Where("upload_session_id = ?", session.ID).Delete(&models.UploadChunk{}).Error
+	})
+
+	if err != nil {
+		log.Printf("Could not complete upload session with ID: %d\n", session.ID)
+		return apperrors.GetAppError(err, "Could not complete upload")
+	}
+
+	session.AttachmentId = &attachment.ID
+	session.CompletingAt = nil
+	session.Attachment = attachment
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
+
+func (r *uploadRepository) DeleteUploadSession(sessionId uint) error {
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("upload_session_id = ?", sessionId).Delete(&models.UploadChunk{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Where("id = ?", sessionId).Delete(&models.UploadSession{}).Error
+	})
+
+	if err != nil {
+		log.Printf("Could not delete upload session with ID: %d\n", sessionId)
+		return apperrors.NewInternal()
+	}
+
+	return nil
+}
+
+
+// GetExpiredUploadSessions finds sessions past their expiry along with any chunks
+// they still hold, oldest first
+func (r *uploadRepository) GetExpiredUploadSessions(now time.Time, limit int) ([]models.UploadSession, error) {
+	var sessions []models.UploadSession
+
+	if err := r.DB.Preload("Chunks").Where("expires_at < ?", now).
+		Order("expires_at asc").Limit(limit).Find(&sessions).Error; err != nil {
+		log.Print("Could not get expired upload sessions")
+		return nil, apperrors.NewInternal()
+	}
+
+	return sessions, nil
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 
@@ -35,7 +36,7 @@ func (r *userRepository) RegisterUser(user *models.User) (*models.User, error) {
 
 	// Attempt to create the new user
 	if err := r.DB.Create(&user).Error; err != nil {
-			log.Printf("Duplicate key error: Could not create user with email %v. Reason: %v\n", user.Email, err.Error)
+			log.Printf("Duplicate key error: Could not create user with email %v. Reason: %v\n", user.Email, err)
 			return nil, apperrors.NewInternal()
 	}
 
@@ -45,7 +46,7 @@ func (r *userRepository) RegisterUser(user *models.User) (*models.User, error) {
 
 
 
-func (r *userRepository) JoinGroup(userId, groupId int) error {
+func (r *userRepository) JoinGroup(userId, groupId int, invite *models.GroupInvite) error {
 	group := &models.Group{}
 	user := &models.User{}
 	userGroup := &models.UserGroup{}
@@ -60,27 +61,52 @@ func (r *userRepository) JoinGroup(userId, groupId int) error {
 		return apperrors.NewBadRequest("Group does not exist")
 	}
 
-	err := r.DB.Where("user_id = ? AND group_id = ?", userId, groupId).First(&userGroup) 
+	err := r.DB.Where("user_id = ? AND group_id = ?", userId, groupId).First(&userGroup).Error
 	
-	if err.Error != nil {
-		if errors.Is(err.Error, gorm.ErrRecordNotFound){
-			log.Print("User is not already a member of group")
-		}else {
-			return apperrors.NewInternal()
-		}
-	}
-
 	if err == nil {
 		log.Print("User is already a member of group")
 		return apperrors.NewBadRequest("User is already a member of group")
 	}
 
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return apperrors.NewInternal()
+	}
+
 	userGroup.UserId = user.ID
 	userGroup.GroupId = group.ID
+	// Messages sent before joining don't count as unread
+	userGroup.LastReadSeq = group.MessageSeq
+	userGroup.JoinedSeq = group.MessageSeq
+
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if invite != nil {
+			if err := redeemInvite(tx, invite, user.ID); err != nil {
+				return err
+			}
+		}
+
+		if err := addMember(tx, userGroup); err != nil {
+			return err
+		}
+
+		return recordMembershipEvent(tx, group.ID, user.ID, user.ID, models.JoinMembershipEvent)
+	})
+}
+
+
+// addMember creates a membership. Joins can race, through an invite and an approved
+// join request at once, so the unique index on user_groups decides which one wins.
+func addMember(tx *gorm.DB, member *models.UserGroup) error {
+	result := tx.Clauses(clause.OnConflict{ DoNothing: true }).Create(member)
 
-	if err := r.DB.Create(&userGroup).Error; err != nil {
-		log.Print("Could not join group")
-		return apperrors.NewBadRequest("Could not join group")
+	if result.Error != nil {
+		log.Printf("Could not add user %d to group %d\n", member.UserId, member.GroupId)
+		return apperrors.NewInternal()
+	}
+
+	if result.RowsAffected == 0 {
+		log.Print("User is already a member of group")
+		return apperrors.NewBadRequest("User is already a member of group")
 	}
 
 	return nil
@@ -108,12 +134,14 @@ func (r *userRepository) LeaveGroup(userId, groupId int) error {
 		return apperrors.NewBadRequest("User is not a part of this group")
 	}
 
-	if err := r.DB.Where("id = ?", userGroup.ID).Delete(&models.UserGroup{}).Error; err != nil {
-		log.Print("Could not delete user group association")
-		return apperrors.NewBadRequest("Could not delete user group association")
-	}
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("id = ?", userGroup.ID).Delete(&models.UserGroup{}).Error; err != nil {
+			log.Print("Could not delete user group association")
+			return apperrors.NewBadRequest("Could not delete user group association")
+		}
 
-	return nil
+		return recordMembershipEvent(tx, group.ID, user.ID, user.ID, models.LeaveMembershipEvent)
+	})
 }
 
 
@@ -152,22 +180,31 @@ func (r *userRepository) GetUserByEmailOrUserName(email string) (*models.User, e
 }
 
 
-func (r *userRepository) GetUsersByGroupId(id, limit, page int) ([]models.User, error) {
+func (r *userRepository) GetUsersByGroupId(id int, query models.PageQuery) (*models.UserPage, error) {
 	group := &models.Group{}
 	var users []models.User
 
 	if err := r.DB.Where("id = ?", id).First(&group).Error; err != nil {
-		return users, apperrors.NewBadRequest("Could not find group with provided ID")
+		return nil, apperrors.NewBadRequest("Could not find group with provided ID")
 	}
 
 	if err := r.DB.Joins("JOIN user_groups ON user_groups.user_id = users.id").
 					Where("user_groups.group_id = ?", id).
+					Scopes(keyset("users.id", "users.id", query, false)).
 					Find(&users).Error; err != nil {
 						log.Print("Failed to get users by group ID")
-						return users, apperrors.NewBadRequest("Failed to get users by group ID")
+						return nil, apperrors.NewBadRequest("Failed to get users by group ID")
 					}
 
-	return users, nil
+	page := &models.UserPage{ Items: users }
+
+	if limit := pageSize(query.Limit); len(users) > limit {
+		page.Items = users[:limit]
+		last := page.Items[limit-1]
+		page.NextCursor = models.Cursor{ Key: int64(last.ID), ID: last.ID }.Encode()
+	}
+
+	return page, nil
 }
 
 
@@ -0,0 +1,546 @@
+package services
+
+import (
+	"darkoo/apperrors"
+	"darkoo/media"
+	"darkoo/models"
+	"darkoo/storage"
+
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+
+// sniffLength is how much of a file http.DetectContentType looks at
+const sniffLength = 512
+
+
+// orphanedBlobBatch is how many unreferenced blobs are collected per query
+const orphanedBlobBatch = 100
+
+
+// unsentAttachmentBatch is how many unsent attachments are collected per query
+const unsentAttachmentBatch = 100
+
+
+// thumbnailSizes are the bounding squares image thumbnails are rendered to.
+// Sizes the original already fits in are skipped.
+var thumbnailSizes = []int{ 160, 480, 1024 }
+
+
+type attachmentService struct {
+	attachmentRepository models.IAttachmentRepository
+	groupRepository      models.IGroupRepository
+	storage              storage.Storage
+	signer               *storage.URLSigner
+	maxSize              int64
+	unsentTTL            time.Duration
+}
+
+
+// NewAttachmentService creates the attachment service. Attachments that haven't
+// been sent within unsentTTL of being uploaded are collected.
+func NewAttachmentService(AttachmentRepository models.IAttachmentRepository, GroupRepository models.IGroupRepository,
+	Storage storage.Storage, Signer *storage.URLSigner, maxSize int64, unsentTTL time.Duration) models.IAttachmentService {
+	return &attachmentService{
+		attachmentRepository: AttachmentRepository,
+		groupRepository: GroupRepository,
+		storage: Storage,
+		signer: Signer,
+		maxSize: maxSize,
+		unsentTTL: unsentTTL,
+	}
+}
+
+
+// UploadAttachment stores a file once its contents are confirmed to match both the
+// declared MIME type and the kind of message it will be sent as
+func (s *attachmentService) UploadAttachment(userId int, upload models.Upload) (*models.Attachment, error) {
+	if !models.IsAttachmentKind(upload.Kind) {
+		return nil, apperrors.NewBadRequest(fmt.Sprintf("Files cannot be sent as %q messages", upload.Kind))
+	}
+
+	if upload.Size > s.maxSize {
+		return nil, apperrors.NewPayloadTooLarge(s.maxSize, upload.Size)
+	}
+
+	if upload.Size == 0 {
+		return nil, apperrors.NewBadRequest("Uploaded file is empty")
+	}
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(upload.Body, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		log.Printf("Could not read upload from user %d: %v\n", userId, err)
+		return nil, apperrors.NewBadRequest("Could not read uploaded file")
+	}
+	head = head[:n]
+
+	mimeType, err := resolveMimeType(upload, head)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := &models.Attachment{
+		UserId: uint(userId),
+		Kind: upload.Kind,
+		MimeType: mimeType,
+		FileName: filepath.Base(upload.FileName),
+		Size: upload.Size,
+	}
+
+	body := io.MultiReader(bytes.NewReader(head), upload.Body)
+
+	var content *pendingContent
+	if upload.Kind == "image" {
+		content, err = s.prepareImage(attachment, body)
+	} else {
+		content, err = s.spool(attachment, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	// Identical content is stored once and shared between attachments
+	stored := false
+	blob, err := s.attachmentRepository.AcquireBlob(&models.Blob{
+		Hash: content.hash,
+		Size: content.size,
+		StorageKey: "blobs/" + content.hash,
+	}, func() error {
+		stored = true
+		return s.storeContent(attachment, content)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Content first uploaded as a plain file has no thumbnails yet
+	if !stored {
+		if err := s.storeMissingThumbnails(blob, attachment, content); err != nil {
+			s.attachmentRepository.ReleaseBlob(blob.ID)
+			return nil, err
+		}
+	}
+
+	attachment.BlobId = &blob.ID
+	attachment.StorageKey = blob.StorageKey
+
+	saved, err := s.attachmentRepository.CreateAttachment(attachment)
+	if err != nil {
+		s.attachmentRepository.ReleaseBlob(blob.ID)
+		return nil, err
+	}
+
+	signAttachment(s.signer, saved)
+	return saved, nil
+}
+
+
+// pendingContent is the final form of an upload, hashed and waiting to be stored
+// unless identical content already is
+type pendingContent struct {
+	body       io.ReadSeeker
+	size       int64
+	hash       string
+	thumbnails []media.Thumbnail
+	file       *os.File
+}
+
+
+func (c *pendingContent) Close() {
+	if c.file != nil {
+		c.file.Close()
+		os.Remove(c.file.Name())
+	}
+}
+
+
+// spool buffers an upload in a temporary file while hashing it
+func (s *attachmentService) spool(attachment *models.Attachment, body io.Reader) (*pendingContent, error) {
+	file, err := os.CreateTemp("", "darkoo-upload-*")
+	if err != nil {
+		log.Printf("Could not buffer upload from user %d: %v\n", attachment.UserId, err)
+		return nil, apperrors.NewInternal()
+	}
+
+	content := &pendingContent{ body: file, file: file }
+	hash := sha256.New()
+
+	size, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(body, s.maxSize+1))
+	if err == nil {
+		err = s.checkReceived(attachment, size)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		content.Close()
+		return nil, uploadReadError(attachment, err)
+	}
+
+	content.size = size
+	content.hash = hex.EncodeToString(hash.Sum(nil))
+	return content, nil
+}
+
+
+// prepareImage strips location and other metadata from an image before anything
+// is stored, renders its thumbnails and fills in its dimensions
+func (s *attachmentService) prepareImage(attachment *models.Attachment, body io.Reader) (*pendingContent, error) {
+	data, err := io.ReadAll(io.LimitReader(body, s.maxSize+1))
+	if err == nil {
+		err = s.checkReceived(attachment, int64(len(data)))
+	}
+	if err != nil {
+		return nil, uploadReadError(attachment, err)
+	}
+
+	stripped, orientation, err := media.StripMetadata(data, attachment.MimeType)
+	if err != nil {
+		return nil, apperrors.NewBadRequest("Uploaded image is malformed")
+	}
+
+	info, err := media.Analyze(stripped, orientation, thumbnailSizes)
+	if err == media.ErrTooLarge {
+		return nil, apperrors.NewBadRequest("Uploaded image has too many pixels")
+	}
+	if err != nil {
+		return nil, apperrors.NewBadRequest("Uploaded image is malformed")
+	}
+
+	sum := sha256.Sum256(stripped)
+	content := &pendingContent{
+		body: bytes.NewReader(stripped),
+		size: int64(len(stripped)),
+		hash: hex.EncodeToString(sum[:]),
+	}
+
+	attachment.Size = content.size
+
+	// Formats the server can't decode, such as WebP, are stored without thumbnails
+	if info == nil {
+		return content, nil
+	}
+
+	attachment.Width = info.Width
+	attachment.Height = info.Height
+	attachment.Blurhash = info.Blurhash
+	content.thumbnails = info.Thumbnails
+
+	for _, thumbnail := range info.Thumbnails {
+		attachment.Thumbnails = append(attachment.Thumbnails, models.AttachmentThumbnail{
+			MaxDimension: thumbnail.MaxDimension,
+			Width: thumbnail.Width,
+			Height: thumbnail.Height,
+			MimeType: thumbnail.MimeType,
+			Size: int64(len(thumbnail.Data)),
+			StorageKey: fmt.Sprintf("thumbnails/%s-%d", content.hash, thumbnail.MaxDimension),
+		})
+	}
+
+	return content, nil
+}
+
+
+// checkReceived makes sure the whole file arrived, and nothing more
+func (s *attachmentService) checkReceived(attachment *models.Attachment, received int64) error {
+	if received > s.maxSize {
+		return apperrors.NewPayloadTooLarge(s.maxSize, received)
+	}
+
+	if received != attachment.Size {
+		return apperrors.NewBadRequest(fmt.Sprintf("Expected %d bytes but received %d", attachment.Size, received))
+	}
+
+	return nil
+}
+
+
+func uploadReadError(attachment *models.Attachment, err error) error {
+	if _, ok := err.(*apperrors.Error); ok {
+		return err
+	}
+
+	log.Printf("Could not read upload from user %d: %v\n", attachment.UserId, err)
+	return apperrors.NewBadRequest("Could not read uploaded file")
+}
+
+
+// storeContent saves new content and its thumbnails under the keys its hash gives them
+func (s *attachmentService) storeContent(attachment *models.Attachment, content *pendingContent) error {
+	key := "blobs/" + content.hash
+	stored := []string{}
+
+	err := s.storage.Put(context.Background(), key, content.body, content.size, attachment.MimeType)
+	if err == nil {
+		stored = append(stored, key)
+
+		for i, thumbnail := range content.thumbnails {
+			key := attachment.Thumbnails[i].StorageKey
+			if err = s.storage.Put(context.Background(), key, bytes.NewReader(thumbnail.Data), int64(len(thumbnail.Data)), thumbnail.MimeType); err != nil {
+				break
+			}
+			stored = append(stored, key)
+		}
+	}
+
+	if err != nil {
+		log.Printf("Could not store upload from user %d: %v\n", attachment.UserId, err)
+		s.removeKeys(stored)
+		return apperrors.NewInternal()
+	}
+
+	return nil
+}
+
+
+// storeMissingThumbnails saves the thumbnails of content that was already stored
+// without them. Another upload of the same content may be saving them too, which
+// is harmless since both write the same bytes, so nothing is cleaned up on failure.
+func (s *attachmentService) storeMissingThumbnails(blob *models.Blob, attachment *models.Attachment, content *pendingContent) error {
+	if len(content.thumbnails) == 0 {
+		return nil
+	}
+
+	keys, err := s.attachmentRepository.GetBlobThumbnailKeys(blob.ID)
+	if err != nil {
+		return err
+	}
+
+	existing := map[string]bool{}
+	for _, key := range keys {
+		existing[key] = true
+	}
+
+	for i, thumbnail := range content.thumbnails {
+		key := attachment.Thumbnails[i].StorageKey
+		if existing[key] {
+			continue
+		}
+
+		if err := s.storage.Put(context.Background(), key, bytes.NewReader(thumbnail.Data), int64(len(thumbnail.Data)), thumbnail.MimeType); err != nil {
+			log.Printf("Could not store thumbnail for upload from user %d: %v\n", attachment.UserId, err)
+			return apperrors.NewInternal()
+		}
+	}
+
+	return nil
+}
+
+
+// removeKeys deletes stored objects, treating ones already gone as deleted
+func (s *attachmentService) removeKeys(keys []string) error {
+	for _, key := range keys {
+		if err := s.storage.Delete(context.Background(), key); err != nil && err != storage.ErrNotFound {
+			log.Printf("Could not delete stored object %s: %v\n", key, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+
+// CollectUnsentAttachments retires attachments that were uploaded but never sent
+// in time, giving back their hold on the stored content so it can be collected,
+// and reports how many were retired
+func (s *attachmentService) CollectUnsentAttachments() (int, error) {
+	collected := 0
+	before := time.Now().Add(-s.unsentTTL)
+
+	for {
+		ids, err := s.attachmentRepository.GetUnsentAttachmentIds(before, unsentAttachmentBatch)
+		if err != nil {
+			return collected, err
+		}
+
+		for _, id := range ids {
+			released, err := s.attachmentRepository.ReleaseUnsentAttachment(id)
+			if err != nil {
+				return collected, err
+			}
+			if released {
+				collected++
+			}
+		}
+
+		if len(ids) < unsentAttachmentBatch {
+			return collected, nil
+		}
+	}
+}
+
+
+// CollectOrphanedBlobs deletes content that no attachment refers to anymore, such
+// as files whose every message was deleted, and reports how many were removed
+func (s *attachmentService) CollectOrphanedBlobs() (int, error) {
+	collected := 0
+
+	for {
+		blobs, err := s.attachmentRepository.GetOrphanedBlobs(orphanedBlobBatch)
+		if err != nil {
+			return collected, err
+		}
+
+		for i := range blobs {
+			if err := s.attachmentRepository.DeleteBlob(&blobs[i], s.removeKeys); err != nil {
+				return collected, err
+			}
+			collected++
+		}
+
+		if len(blobs) < orphanedBlobBatch {
+			return collected, nil
+		}
+	}
+}
+
+
+// GetGroupStorageUsage reports what the files sent in a group take up. Only
+// members who can manage the group may see it.
+func (s *attachmentService) GetGroupStorageUsage(userId, groupId int) (*models.StorageUsage, error) {
+	if _, err := authorize(s.groupRepository, userId, groupId, models.UpdateGroupPermission); err != nil {
+		return nil, err
+	}
+
+	return s.attachmentRepository.GetGroupStorageUsage(groupId)
+}
+
+
+func (s *attachmentService) GetUserStorageUsage(userId int) (*models.StorageUsage, error) {
+	return s.attachmentRepository.GetUserStorageUsage(userId)
+}
+
+
+// resolveMimeType sniffs the file and checks media against what the client declared.
+// Audio and video formats are often beyond what sniffing recognizes, so for those
+// an inconclusive sniff falls back to the declared type. Plain files keep whatever
+// type they sniff as, since declared types for documents vary too much to compare.
+func resolveMimeType(upload models.Upload, head []byte) (string, error) {
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+
+	declared := ""
+	if upload.DeclaredType != "" {
+		declared, _, _ = mime.ParseMediaType(upload.DeclaredType)
+	}
+
+	mimeType := sniffed
+	inconclusive := sniffed == "application/octet-stream"
+
+	if inconclusive && (upload.Kind == "audio" || upload.Kind == "video") && declared != "" {
+		mimeType = declared
+	} else if upload.Kind != "file" && declared != "" && declared != "application/octet-stream" && declared != sniffed && !inconclusive {
+		return "", apperrors.NewUnsupportedMediaType(fmt.Sprintf("Declared content type %s does not match the file, which is %s", declared, sniffed))
+	}
+
+	if !models.AcceptsMimeType(upload.Kind, mimeType) {
+		return "", apperrors.NewUnsupportedMediaType(fmt.Sprintf("A %s file cannot be sent as a %s message", mimeType, upload.Kind))
+	}
+
+	return mimeType, nil
+}
+
+
+// GetAttachment issues a fresh download link to the uploader, or to members of a
+// group the attachment has been sent to
+func (s *attachmentService) GetAttachment(userId, id int) (*models.Attachment, error) {
+	attachment, err := s.attachmentRepository.GetAttachmentById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if attachment.UserId != uint(userId) {
+		if err := s.requireRecipient(userId, id); err != nil {
+			return nil, err
+		}
+	}
+
+	signAttachment(s.signer, attachment)
+	return attachment, nil
+}
+
+
+// OpenSignedAttachment serves a download link, for the original file or one of its
+// thumbnails. Access was checked when the link was issued, so only the signature
+// and expiry are checked here.
+func (s *attachmentService) OpenSignedAttachment(id int, variant, expires, signature string) (*models.Attachment, io.ReadSeekCloser, error) {
+	if !s.signer.Verify(uint(id), variant, expires, signature, time.Now()) {
+		return nil, nil, apperrors.NewAuthorization("This link is invalid or has expired")
+	}
+
+	attachment, err := s.attachmentRepository.GetAttachmentById(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, _, ok := attachment.Variant(variant)
+	if !ok {
+		return nil, nil, apperrors.NewNotFound("Attachment", strconv.Itoa(id))
+	}
+
+	body, err := s.storage.Open(context.Background(), key)
+	if err != nil {
+		log.Printf("Could not open stored attachment %d: %v\n", id, err)
+		if err == storage.ErrNotFound {
+			return nil, nil, apperrors.NewNotFound("Attachment", strconv.Itoa(id))
+		}
+		return nil, nil, apperrors.NewInternal()
+	}
+
+	return attachment, body, nil
+}
+
+
+func (s *attachmentService) requireRecipient(userId, id int) error {
+	groupIds, err := s.attachmentRepository.GetAttachmentGroupIds(id)
+	if err != nil {
+		return err
+	}
+
+	for _, groupId := range groupIds {
+		if _, err := requireMember(s.groupRepository, userId, int(groupId)); err == nil {
+			return nil
+		}
+	}
+
+	return apperrors.NewNotFound("Attachment", strconv.Itoa(id))
+}
+
+
+// signAttachment fills in short-lived download links for the attachment and its thumbnails
+func signAttachment(signer *storage.URLSigner, attachment *models.Attachment) {
+	if attachment == nil {
+		return
+	}
+
+	now := time.Now()
+	url, expires := signer.Sign(attachment.ID, "", now)
+	attachment.Url = url
+	attachment.UrlExpiresAt = &expires
+
+	for i := range attachment.Thumbnails {
+		thumbnail := &attachment.Thumbnails[i]
+		thumbnail.Url, _ = signer.Sign(attachment.ID, models.ThumbnailVariant(thumbnail.MaxDimension), now)
+	}
+}
+
+
+// signAttachments links every attachment in messages the caller was allowed to load
+func signAttachments(signer *storage.URLSigner, messages []models.Message) {
+	for i := range messages {
+		signAttachment(signer, messages[i].Attachment)
+	}
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"darkoo/models"
+
+	"encoding/json"
+)
+
+
+// attachAuthors fills in who wrote each message. Messages in anonymous groups only
+// carry the author's pseudonym.
+func attachAuthors(groupRepository models.IGroupRepository, messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	groups := map[uint]*models.Group{}
+	userIds := []uint{}
+	seen := map[uint]bool{}
+
+	for _, message := range messages {
+		group, ok := groups[message.GroupId]
+		if !ok {
+			var err error
+			if group, err = groupRepository.GetGroupById(int(message.GroupId)); err != nil {
+				return err
+			}
+			groups[message.GroupId] = group
+		}
+
+		if !group.IsAnonymous() && !seen[message.UserId] {
+			seen[message.UserId] = true
+			userIds = append(userIds, message.UserId)
+		}
+	}
+
+	users, err := groupRepository.GetUsersByIds(userIds)
+	if err != nil {
+		return err
+	}
+
+	byId := map[uint]models.User{}
+	for _, user := range users {
+		byId[user.ID] = user
+	}
+
+	for i := range messages {
+		group := groups[messages[i].GroupId]
+
+		user, ok := byId[messages[i].UserId]
+		if !ok {
+			user = models.User{ Base: models.Base{ ID: messages[i].UserId } }
+		}
+
+		author := group.AuthorOf(user)
+		messages[i].Author = &author
+
+		// Moderators are anonymous too, so who removed a message isn't shown
+		if group.IsAnonymous() {
+			messages[i].RemovedById = nil
+		}
+	}
+
+	return nil
+}
+
+
+// userAuthor is how a user loaded along with a record is shown to the group
+func userAuthor(group *models.Group, user models.User) *models.Author {
+	author := group.AuthorOf(user)
+	return &author
+}
+
+
+// attachAuthor is attachAuthors for a single message
+func attachAuthor(groupRepository models.IGroupRepository, message *models.Message) error {
+	messages := []models.Message{ *message }
+
+	if err := attachAuthors(groupRepository, messages); err != nil {
+		return err
+	}
+
+	message.Author = messages[0].Author
+	return nil
+}
+
+
+// The functions below take the user IDs out of a group's records when the group is
+// anonymous and show pseudonyms in their place. Moderators are pseudonymous as well,
+// and the account behind a pseudonym is only revealed through ResolvePseudonym,
+// which is audited.
+
+
+func pseudonymOf(group *models.Group, userId uint) *models.Author {
+	author := group.Pseudonym(userId)
+	return &author
+}
+
+
+func hideSanctionIds(group *models.Group, sanctions []models.Sanction) {
+	if !group.IsAnonymous() {
+		return
+	}
+
+	for i := range sanctions {
+		sanctions[i].Actor = pseudonymOf(group, sanctions[i].ActorId)
+		sanctions[i].UserId = 0
+		sanctions[i].ActorId = 0
+		sanctions[i].LiftedById = nil
+	}
+}
+
+
+func hideJoinRequestIds(group *models.Group, requests []models.JoinRequest) {
+	if !group.IsAnonymous() {
+		return
+	}
+
+	for i := range requests {
+		requests[i].UserId = 0
+		requests[i].ReviewedById = nil
+	}
+}
+
+
+func hideInviteIds(group *models.Group, invites []models.GroupInvite) {
+	if !group.IsAnonymous() {
+		return
+	}
+
+	for i := range invites {
+		invites[i].CreatedById = 0
+	}
+}
+
+
+func hideRedemptionIds(group *models.Group, redemptions []models.InviteRedemption) {
+	if !group.IsAnonymous() {
+		return
+	}
+
+	for i := range redemptions {
+		redemptions[i].UserId = 0
+	}
+}
+
+
+func hideMembershipEventIds(group *models.Group, events []models.MembershipEvent) {
+	if !group.IsAnonymous() {
+		return
+	}
+
+	for i := range events {
+		events[i].Member = pseudonymOf(group, events[i].UserId)
+		events[i].Actor = pseudonymOf(group, events[i].ActorId)
+		events[i].UserId = 0
+		events[i].ActorId = 0
+	}
+}
+
+
+func hideModerationLogIds(group *models.Group, logs []models.ModerationLog) {
+	if !group.IsAnonymous() {
+		return
+	}
+
+	for i := range logs {
+		logs[i].Actor = pseudonymOf(group, logs[i].ActorId)
+		logs[i].ActorId = 0
+
+		if logs[i].TargetUserId != nil {
+			logs[i].Target = pseudonymOf(group, *logs[i].TargetUserId)
+			logs[i].TargetUserId = nil
+		}
+
+		// Ownership changes record the owners' user IDs as their values
+		if logs[i].Action == models.TransferOwnershipModerationAction {
+			logs[i].Before = pseudonymousOwner(group, logs[i].Before)
+			logs[i].After = pseudonymousOwner(group, logs[i].After)
+		}
+	}
+}
+
+
+func pseudonymousOwner(group *models.Group, value models.JSON) models.JSON {
+	var owner struct {
+		OwnerId uint `json:"ownerId"`
+	}
+
+	if err := json.Unmarshal(value, &owner); err != nil {
+		return nil
+	}
+
+	hidden, err := models.NewJSON(map[string] interface{}{ "owner": pseudonymOf(group, owner.OwnerId) })
+	if err != nil {
+		return nil
+	}
+
+	return hidden
+}
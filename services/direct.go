@@ -0,0 +1,126 @@
+package services
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"fmt"
+	"log"
+	"net/http"
+)
+
+
+type directService struct {
+	directRepository models.IDirectRepository
+	userRepository   models.IUserRepository
+	groupRepository  models.IGroupRepository
+}
+
+
+func NewDirectService(DirectRepository models.IDirectRepository, UserRepository models.IUserRepository,
+	GroupRepository models.IGroupRepository) models.IDirectService {
+	return &directService{
+		directRepository: DirectRepository,
+		userRepository: UserRepository,
+		groupRepository: GroupRepository,
+	}
+}
+
+
+// OpenDirectGroup returns the conversation between the user and the participants,
+// creating it the first time. Reopening a conversation brings back every participant
+// who left it, so messages reach everyone it was opened with.
+func (s *directService) OpenDirectGroup(userId int, participantIds []uint) (*models.Group, error) {
+	others := []uint{}
+	seen := map[uint]bool{ uint(userId): true }
+
+	for _, id := range participantIds {
+		if !seen[id] {
+			seen[id] = true
+			others = append(others, id)
+		}
+	}
+
+	if len(others) == 0 {
+		return nil, apperrors.NewBadRequest("A direct conversation needs at least one other participant")
+	}
+
+	if len(others)+1 > models.MaxDirectParticipants {
+		return nil, apperrors.NewBadRequest(fmt.Sprintf("A direct conversation can have at most %d participants", models.MaxDirectParticipants))
+	}
+
+	for _, id := range others {
+		if _, err := s.userRepository.GetUserById(int(id)); err != nil {
+			return nil, apperrors.NewBadRequest("One or more participants do not exist")
+		}
+	}
+
+	blocked, err := s.directRepository.HasBlockBetween(userId, others)
+	if err != nil {
+		return nil, err
+	}
+
+	if blocked {
+		log.Printf("User %d is blocked from a direct conversation\n", userId)
+		return nil, apperrors.NewAuthorization("You cannot message one or more of these users")
+	}
+
+	participants := append([]uint{ uint(userId) }, others...)
+	group, err := s.directRepository.GetDirectGroupByKey(models.DirectKey(participants))
+
+	if err != nil {
+		if apperrors.Status(err) != http.StatusNotFound {
+			return nil, err
+		}
+
+		return s.directRepository.CreateDirectGroup(userId, participants)
+	}
+
+	for _, id := range participants {
+		if _, err := s.groupRepository.GetMembership(int(group.ID), int(id)); err != nil {
+			if err := s.directRepository.RestoreParticipant(int(group.ID), int(id), userId); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return group, nil
+}
+
+
+func (s *directService) GetDirectGroups(userId int, query models.PageQuery) (*models.GroupPage, error) {
+	return s.directRepository.GetDirectGroupsByUserId(userId, query)
+}
+
+
+func (s *directService) BlockUser(userId, blockedId int) (*models.UserBlock, error) {
+	if userId == blockedId {
+		return nil, apperrors.NewBadRequest("You cannot block yourself")
+	}
+
+	blocked, err := s.userRepository.GetUserById(blockedId)
+	if err != nil {
+		return nil, err
+	}
+
+	block := &models.UserBlock{
+		BlockerId: uint(userId),
+		BlockedId: blocked.ID,
+	}
+
+	if err := s.directRepository.BlockUser(block); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+
+func (s *directService) UnblockUser(userId, blockedId int) error {
+	return s.directRepository.UnblockUser(userId, blockedId)
+}
+
+
+func (s *directService) GetBlockedUsers(userId int) ([]models.UserBlock, error) {
+	return s.directRepository.GetBlockedUsers(userId)
+}
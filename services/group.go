@@ -1,6 +1,13 @@
 package services
 
-import "darkoo/models"
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"log"
+	"strconv"
+	"time"
+)
 
 
 type groupService struct {
@@ -16,37 +23,237 @@ func NewGroupService(GroupRepository models.IGroupRepository) models.IGroupServi
 
 
 
-func (s *groupService) CreateGroup(group *models.Group) (*models.Group, error) {
-	return s.groupRepository.CreateGroup(group)
+func (s *groupService) CreateGroup(userId int, group *models.Group) (*models.Group, error) {
+	return s.groupRepository.CreateGroup(group, userId)
+}
+
+
+func (s *groupService) UpdateGroup(userId int, group models.Group) error {
+	if _, err := authorize(s.groupRepository, userId, int(group.ID), models.UpdateGroupPermission); err != nil {
+		return err
+	}
+
+	return s.groupRepository.UpdateGroup(userId, group)
 }
 
 
-func (s *groupService) UpdateGroup(group models.Group)error {
-	return s.groupRepository.UpdateGroup(group)
+func (s *groupService) GetGroupById(userId, id int) (*models.Group, error) {
+	group, err := s.groupRepository.GetGroupById(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if group.Visibility == models.HiddenGroupVisibility {
+		if _, err := s.groupRepository.GetMembership(id, userId); err != nil {
+			log.Printf("User %d cannot see hidden group %d\n", userId, id)
+			return nil, apperrors.NewNotFound("Group", strconv.Itoa(id))
+		}
+	}
+
+	return group, nil
 }
 
 
-func (s *groupService) GetGroupById(id int) (*models.Group, error) {
-	return s.groupRepository.GetGroupById(id)
+func (s *groupService) GetMembership(userId, groupId int) (*models.UserGroup, error) {
+	return requireMember(s.groupRepository, userId, groupId)
 }
 
 
-func (s *groupService) GetGroupsByUserId(userId, limit, page int) ([]models.Group, error) {
-	return s.groupRepository.GetGroupsByUserId(userId, limit, page)
+func (s *groupService) GetGroupsByUserId(userId int, query models.PageQuery) (*models.GroupPage, error) {
+	return s.groupRepository.GetGroupsByUserId(userId, query)
 }
 
 
 
-func (s *groupService) DeleteGroupById(id int) error {
+func (s *groupService) DeleteGroupById(userId, id int) error {
+	if _, err := authorize(s.groupRepository, userId, id, models.DeleteGroupPermission); err != nil {
+		return err
+	}
+
 	return s.groupRepository.DeleteGroupById(id)
 }
 
 
-func (s *groupService) BanUserFromGroup(groupId, userId int) error {
-	return s.groupRepository.BanUserFromGroup(groupId, userId)
+func (s *groupService) BanUserFromGroup(actorId, groupId, userId int, duration time.Duration, reason string) (*models.Sanction, error) {
+	return s.sanctionMember(actorId, groupId, userId, models.BanSanction, models.BanMembersPermission, duration, reason)
+}
+
+
+func (s *groupService) UnBanUserFromGroup(actorId, groupId, userId int) error {
+	if _, err := authorizeOver(s.groupRepository, actorId, groupId, userId, models.BanMembersPermission); err != nil {
+		return err
+	}
+
+	return s.groupRepository.LiftSanction(actorId, groupId, userId, models.BanSanction)
+}
+
+
+func (s *groupService) MuteUserInGroup(actorId, groupId, userId int, duration time.Duration, reason string) (*models.Sanction, error) {
+	return s.sanctionMember(actorId, groupId, userId, models.MuteSanction, models.MuteMembersPermission, duration, reason)
+}
+
+
+func (s *groupService) UnMuteUserInGroup(actorId, groupId, userId int) error {
+	if _, err := authorizeOver(s.groupRepository, actorId, groupId, userId, models.MuteMembersPermission); err != nil {
+		return err
+	}
+
+	return s.groupRepository.LiftSanction(actorId, groupId, userId, models.MuteSanction)
+}
+
+
+func (s *groupService) GetActiveSanctions(userId, groupId int) ([]models.Sanction, error) {
+	if _, err := authorize(s.groupRepository, userId, groupId, models.ViewSanctionsPermission); err != nil {
+		return nil, err
+	}
+
+	group, err := s.groupRepository.GetGroupById(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	sanctions, err := s.groupRepository.GetActiveSanctions(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sanctions {
+		sanctions[i].Author = userAuthor(group, sanctions[i].User)
+	}
+	hideSanctionIds(group, sanctions)
+
+	return sanctions, nil
+}
+
+
+// sanctionMember applies a ban or mute. A zero duration makes it last until lifted.
+func (s *groupService) sanctionMember(actorId, groupId, userId int, sanctionType models.SanctionType,
+	permission models.GroupPermission, duration time.Duration, reason string) (*models.Sanction, error) {
+	if _, err := authorizeOver(s.groupRepository, actorId, groupId, userId, permission); err != nil {
+		return nil, err
+	}
+
+	sanction := &models.Sanction{
+		GroupId: uint(groupId),
+		UserId: uint(userId),
+		ActorId: uint(actorId),
+		Type: sanctionType,
+		Reason: reason,
+	}
+
+	if duration > 0 {
+		expiresAt := time.Now().Add(duration)
+		sanction.ExpiresAt = &expiresAt
+	}
+
+	return s.groupRepository.SanctionMember(sanction)
+}
+
+
+func (s *groupService) KickUserFromGroup(actorId, groupId, userId int) error {
+	if _, err := authorizeOver(s.groupRepository, actorId, groupId, userId, models.KickMembersPermission); err != nil {
+		return err
+	}
+
+	return s.groupRepository.KickUserFromGroup(actorId, groupId, userId)
+}
+
+
+func (s *groupService) UpdateMemberRole(actorId, groupId, userId int, role models.GroupRole) error {
+	if !role.IsValid() || role == models.GroupOwnerRole {
+		log.Printf("Cannot assign role %q\n", role)
+		return apperrors.NewBadRequest("Role cannot be assigned")
+	}
+
+	actor, err := authorizeOver(s.groupRepository, actorId, groupId, userId, models.ManageRolesPermission)
+	if err != nil {
+		return err
+	}
+
+	if !actor.Role.Outranks(role) {
+		log.Printf("User %d cannot grant role %q\n", actorId, role)
+		return apperrors.NewAuthorization("You cannot grant a role equal to or above your own")
+	}
+
+	return s.groupRepository.UpdateMemberRole(actorId, groupId, userId, role)
+}
+
+
+func (s *groupService) TransferOwnership(actorId, groupId, newOwnerId int) error {
+	if actorId == newOwnerId {
+		return apperrors.NewBadRequest("You already own this group")
+	}
+
+	if _, err := authorize(s.groupRepository, actorId, groupId, models.TransferOwnershipPermission); err != nil {
+		return err
+	}
+
+	return s.groupRepository.TransferOwnership(groupId, actorId, newOwnerId)
 }
 
 
-func (s *groupService) UnBanUserFromGroup(groupId, userId int) error {
-	return s.groupRepository.UnBanUserFromGroup(groupId, userId)
-}
\ No newline at end of file
+func (s *groupService) GetMembershipEvents(userId, groupId, limit, page int) ([]models.MembershipEvent, error) {
+	if _, err := authorize(s.groupRepository, userId, groupId, models.ViewMembershipEventsPermission); err != nil {
+		return nil, err
+	}
+
+	group, err := s.groupRepository.GetGroupById(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := s.groupRepository.GetMembershipEvents(groupId, limit, page)
+	if err != nil {
+		return nil, err
+	}
+
+	hideMembershipEventIds(group, events)
+	return events, nil
+}
+
+
+func (s *groupService) GetModerationLogs(userId, groupId int, filter models.ModerationLogFilter, limit, page int) ([]models.ModerationLog, error) {
+	if _, err := authorize(s.groupRepository, userId, groupId, models.ViewModerationLogPermission); err != nil {
+		return nil, err
+	}
+
+	group, err := s.groupRepository.GetGroupById(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	logs, err := s.groupRepository.GetModerationLogs(groupId, filter, limit, page)
+	if err != nil {
+		return nil, err
+	}
+
+	hideModerationLogIds(group, logs)
+	return logs, nil
+}
+
+
+// ResolvePseudonym reveals the account behind a pseudonym in an anonymous group.
+// Every lookup is recorded in the group's moderation log along with its reason.
+func (s *groupService) ResolvePseudonym(actorId, groupId int, pseudonym, reason string) (*models.Author, error) {
+	if _, err := authorize(s.groupRepository, actorId, groupId, models.ResolvePseudonymsPermission); err != nil {
+		return nil, err
+	}
+
+	group, err := s.groupRepository.GetGroupById(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !group.IsAnonymous() {
+		return nil, apperrors.NewBadRequest("Members of this group are not pseudonymous")
+	}
+
+	user, err := s.groupRepository.ResolvePseudonym(actorId, group, pseudonym, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	author := models.NewAuthor(*user)
+	return &author, nil
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"time"
+)
+
+
+const inviteTokenBytes = 24
+
+
+type inviteService struct {
+	inviteRepository models.IInviteRepository
+	groupRepository  models.IGroupRepository
+}
+
+
+func NewInviteService(InviteRepository models.IInviteRepository, GroupRepository models.IGroupRepository) models.IInviteService {
+	return &inviteService{
+		inviteRepository: InviteRepository,
+		groupRepository: GroupRepository,
+	}
+}
+
+
+func (s *inviteService) CreateInvite(userId, groupId int, expiresIn time.Duration, maxUses int) (*models.GroupInvite, error) {
+	if _, err := authorize(s.groupRepository, userId, groupId, models.ManageInvitesPermission); err != nil {
+		return nil, err
+	}
+
+	token, err := generateInviteToken()
+
+	if err != nil {
+		log.Print("Could not generate invite token")
+		return nil, apperrors.NewInternal()
+	}
+
+	invite := &models.GroupInvite{
+		GroupId: uint(groupId),
+		Token: token,
+		CreatedById: uint(userId),
+		ExpiresAt: time.Now().Add(expiresIn),
+		MaxUses: maxUses,
+	}
+
+	return s.inviteRepository.CreateInvite(invite)
+}
+
+
+func (s *inviteService) GetInvitesByGroupId(userId, groupId int) ([]models.GroupInvite, error) {
+	if _, err := authorize(s.groupRepository, userId, groupId, models.ManageInvitesPermission); err != nil {
+		return nil, err
+	}
+
+	group, err := s.groupRepository.GetGroupById(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	invites, err := s.inviteRepository.GetInvitesByGroupId(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	hideInviteIds(group, invites)
+	return invites, nil
+}
+
+
+func (s *inviteService) RevokeInvite(userId, groupId, inviteId int) error {
+	if _, err := s.getGroupInvite(userId, groupId, inviteId); err != nil {
+		return err
+	}
+
+	return s.inviteRepository.RevokeInvite(inviteId)
+}
+
+
+func (s *inviteService) GetRedemptionsByInviteId(userId, groupId, inviteId int) ([]models.InviteRedemption, error) {
+	if _, err := s.getGroupInvite(userId, groupId, inviteId); err != nil {
+		return nil, err
+	}
+
+	group, err := s.groupRepository.GetGroupById(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	redemptions, err := s.inviteRepository.GetRedemptionsByInviteId(inviteId)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range redemptions {
+		redemptions[i].Author = userAuthor(group, redemptions[i].User)
+	}
+	hideRedemptionIds(group, redemptions)
+
+	return redemptions, nil
+}
+
+
+// getGroupInvite authorizes the caller and makes sure the invite belongs to the group
+func (s *inviteService) getGroupInvite(userId, groupId, inviteId int) (*models.GroupInvite, error) {
+	if _, err := authorize(s.groupRepository, userId, groupId, models.ManageInvitesPermission); err != nil {
+		return nil, err
+	}
+
+	invite, err := s.inviteRepository.GetInviteById(inviteId)
+
+	if err != nil || invite.GroupId != uint(groupId) {
+		log.Printf("Invite %d does not belong to group %d\n", inviteId, groupId)
+		return nil, apperrors.NewBadRequest("Could not find invite with provided ID")
+	}
+
+	return invite, nil
+}
+
+
+func generateInviteToken() (string, error) {
+	bytes := make([]byte, inviteTokenBytes)
+
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"log"
+)
+
+
+type joinRequestService struct {
+	joinRequestRepository models.IJoinRequestRepository
+	groupRepository       models.IGroupRepository
+}
+
+
+func NewJoinRequestService(JoinRequestRepository models.IJoinRequestRepository, GroupRepository models.IGroupRepository) models.IJoinRequestService {
+	return &joinRequestService{
+		joinRequestRepository: JoinRequestRepository,
+		groupRepository: GroupRepository,
+	}
+}
+
+
+func (s *joinRequestService) GetJoinRequestsByGroupId(userId, groupId int, status models.JoinRequestStatus) ([]models.JoinRequest, error) {
+	if _, err := authorize(s.groupRepository, userId, groupId, models.ReviewJoinRequestsPermission); err != nil {
+		return nil, err
+	}
+
+	if status == "" {
+		status = models.PendingJoinRequest
+	}
+
+	group, err := s.groupRepository.GetGroupById(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	requests, err := s.joinRequestRepository.GetJoinRequestsByGroupId(groupId, status)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range requests {
+		requests[i].Author = userAuthor(group, requests[i].User)
+	}
+	hideJoinRequestIds(group, requests)
+
+	return requests, nil
+}
+
+
+func (s *joinRequestService) ApproveJoinRequest(userId, groupId, requestId int, message string) error {
+	request, err := s.getGroupJoinRequest(userId, groupId, requestId)
+	if err != nil {
+		return err
+	}
+
+	return s.joinRequestRepository.ApproveJoinRequest(request, userId, message)
+}
+
+
+func (s *joinRequestService) RejectJoinRequest(userId, groupId, requestId int, message string) error {
+	request, err := s.getGroupJoinRequest(userId, groupId, requestId)
+	if err != nil {
+		return err
+	}
+
+	return s.joinRequestRepository.RejectJoinRequest(request, userId, message)
+}
+
+
+// getGroupJoinRequest authorizes the reviewer and makes sure the request belongs to the group
+func (s *joinRequestService) getGroupJoinRequest(userId, groupId, requestId int) (*models.JoinRequest, error) {
+	if _, err := authorize(s.groupRepository, userId, groupId, models.ReviewJoinRequestsPermission); err != nil {
+		return nil, err
+	}
+
+	request, err := s.joinRequestRepository.GetJoinRequestById(requestId)
+
+	if err != nil || request.GroupId != uint(groupId) {
+		log.Printf("Join request %d does not belong to group %d\n", requestId, groupId)
+		return nil, apperrors.NewBadRequest("Could not find join request with provided ID")
+	}
+
+	return request, nil
+}
@@ -1,45 +1,399 @@
 package services
 
-import "darkoo/models"
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+	"darkoo/storage"
+
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
 
 
 type messageService struct {
-	messageRepository models.IMessageRepository
+	messageRepository      models.IMessageRepository
+	groupRepository        models.IGroupRepository
+	reactionRepository     models.IReactionRepository
+	pollRepository         models.IPollRepository
+	notificationRepository models.INotificationRepository
+	signer                 *storage.URLSigner
 }
 
 
-func NewMessageService(messageRepository models.IMessageRepository) models.IMessageService {
+func NewMessageService(messageRepository models.IMessageRepository, groupRepository models.IGroupRepository,
+	reactionRepository models.IReactionRepository, pollRepository models.IPollRepository,
+	notificationRepository models.INotificationRepository, signer *storage.URLSigner) models.IMessageService {
 	return &messageService {
 		messageRepository : messageRepository,
+		groupRepository : groupRepository,
+		reactionRepository : reactionRepository,
+		pollRepository : pollRepository,
+		notificationRepository : notificationRepository,
+		signer : signer,
 	}
 }
 
 
+// SendMessage checks the message against its content type's schema before it
+// goes anywhere, for messages from the websocket as much as from the API
 func (s *messageService) SendMessage(message *models.Message) (*models.Message, error) {
-	return s.messageRepository.SendMessage(message)
+	if err := models.ValidateUserContent(message); err != nil {
+		return nil, apperrors.NewBadRequest(err.Error())
+	}
+
+	// Polls are created along with their message
+	if payload, ok := message.DecodePayload().(*models.PollPayload); ok {
+		message.Poll = payload.NewPoll(message.GroupId)
+	}
+
+	membership, err := requireMember(s.groupRepository, int(message.UserId), int(message.GroupId))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireUnmuted(membership); err != nil {
+		return nil, err
+	}
+
+	group, err := s.groupRepository.GetGroupById(int(message.GroupId))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.requireUnblocked(group, message); err != nil {
+		return nil, err
+	}
+
+	sentMessage, err := s.messageRepository.SendMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
+	// Members of anonymous groups don't know each other's user names to mention
+	if !group.IsAnonymous() {
+		sentMessage.Mentions = notifyMentions(s.groupRepository, s.notificationRepository, sentMessage)
+	}
+
+	signAttachment(s.signer, sentMessage.Attachment)
+
+	if sentMessage.Poll != nil {
+		sentMessage.Poll.Tally(nil, sentMessage.UserId, nil, time.Now())
+	}
+
+	return sentMessage, attachAuthor(s.groupRepository, sentMessage)
+}
+
+
+// decorateMessages fills in everything a listed message is shown with as seen by
+// userId: its author, signed attachment links, poll results and reactions
+func decorateMessages(groupRepository models.IGroupRepository, reactionRepository models.IReactionRepository,
+	pollRepository models.IPollRepository, signer *storage.URLSigner, userId int, messages []models.Message) error {
+	if err := attachAuthors(groupRepository, messages); err != nil {
+		return err
+	}
+	signAttachments(signer, messages)
+
+	if err := attachPolls(pollRepository, groupRepository, userId, messages); err != nil {
+		return err
+	}
+
+	return attachReactions(reactionRepository, userId, messages)
+}
+
+
+// requireUnblocked stops a direct conversation from continuing once any participant
+// has blocked the sender or been blocked by them
+func (s *messageService) requireUnblocked(group *models.Group, message *models.Message) error {
+	if !group.IsDirect() {
+		return nil
+	}
+
+	blocked, err := s.groupRepository.HasBlockWithMember(int(group.ID), int(message.UserId))
+	if err != nil {
+		return err
+	}
+
+	if blocked {
+		return apperrors.NewAuthorization("You cannot message one or more members of this conversation")
+	}
+
+	return nil
 }
 
 
-func (s *messageService) GetMessagesInGroup(groupId, limit, page int) ([]models.Message, error) {
-	return s.messageRepository.GetMessagesInGroup(groupId, limit, page)
+func (s *messageService) GetMessagesInGroup(userId, groupId int, query models.PageQuery) (*models.MessagePage, error) {
+	if _, err := requireMember(s.groupRepository, userId, groupId); err != nil {
+		return nil, err
+	}
+
+	page, err := s.messageRepository.GetMessagesInGroup(groupId, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, decorateMessages(s.groupRepository, s.reactionRepository, s.pollRepository, s.signer, userId, page.Items)
 }
 
 
-func (s *messageService) GetUserMessagesInGroup(userId, groupId, limit, page int) ([]models.Message, error) {
-	return s.messageRepository.GetUserMessagesInGroup(userId, groupId, limit, page)
+func (s *messageService) GetUserMessagesInGroup(userId, groupId int, query models.PageQuery) (*models.MessagePage, error) {
+	if _, err := requireMember(s.groupRepository, userId, groupId); err != nil {
+		return nil, err
+	}
+
+	page, err := s.messageRepository.GetUserMessagesInGroup(userId, groupId, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, decorateMessages(s.groupRepository, s.reactionRepository, s.pollRepository, s.signer, userId, page.Items)
 }
 
 
-func (s *messageService) DeleteMessage(id, userId, groupId int) error {
-	return s.messageRepository.DeleteMessage(id, userId, groupId)
+// GetThread returns a page of replies to a top-level message
+func (s *messageService) GetThread(userId, messageId int, query models.PageQuery) (*models.MessagePage, error) {
+	root, err := s.messageRepository.GetMessageById(messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := requireMember(s.groupRepository, userId, int(root.GroupId)); err != nil {
+		return nil, err
+	}
+
+	if root.IsReply() {
+		return nil, apperrors.NewBadRequest("Threads can only be opened on top-level messages")
+	}
+
+	page, err := s.messageRepository.GetReplies(messageId, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, decorateMessages(s.groupRepository, s.reactionRepository, s.pollRepository, s.signer, userId, page.Items)
+}
+
+
+// DeleteMessage deletes the caller's own message, or leaves a tombstone in place of
+// another member's message when the caller may moderate it.
+func (s *messageService) DeleteMessage(id, userId, groupId int, reason string) (*models.Message, error) {
+	message, err := s.messageRepository.GetMessageById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(message.GroupId) != groupId {
+		log.Printf("Message %d does not belong to group %d\n", id, groupId)
+		return nil, apperrors.NewNotFound("Message", strconv.Itoa(id))
+	}
+
+	if int(message.UserId) == userId {
+		if err := s.messageRepository.DeleteMessage(id, userId, groupId); err != nil {
+			return nil, err
+		}
+		return message, nil
+	}
+
+	actor, err := authorize(s.groupRepository, userId, groupId, models.DeleteMessagesPermission)
+	if err != nil {
+		return nil, err
+	}
+
+	// Authors who have since left the group can still be moderated
+	if author, err := s.groupRepository.GetMembership(groupId, int(message.UserId)); err == nil && !actor.Role.Outranks(author.Role) {
+		log.Printf("User %d does not outrank the author of message %d\n", userId, id)
+		return nil, apperrors.NewAuthorization("You cannot moderate a member with an equal or higher role")
+	}
+
+	removed, err := s.messageRepository.RemoveMessage(message, userId, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	return removed, attachAuthor(s.groupRepository, removed)
 }
 
 
 func (s *messageService) UpdateMessage(message models.Message) error {
+	foundMessage, err := s.messageRepository.GetMessageById(int(message.ID))
+	if err != nil {
+		return err
+	}
+
+	membership, err := requireMember(s.groupRepository, int(foundMessage.UserId), int(foundMessage.GroupId))
+	if err != nil {
+		return err
+	}
+
+	if err := requireUnmuted(membership); err != nil {
+		return err
+	}
+
+	group, err := s.groupRepository.GetGroupById(int(foundMessage.GroupId))
+	if err != nil {
+		return err
+	}
+
+	if deadline := group.EditDeadline(foundMessage.CreatedAt); deadline != nil && time.Now().After(*deadline) {
+		log.Printf("Edit window for message %d closed at %s\n", foundMessage.ID, deadline)
+		return apperrors.NewAuthorization(fmt.Sprintf("Messages in this group can only be edited within %d minutes of sending", *group.EditWindowMinutes))
+	}
+
 	return s.messageRepository.UpdateMessage(message)
 }
 
 
-func (s *messageService) GetMessageById(id int) (*models.Message, error) {
-	return s.messageRepository.GetMessageById(id)
-}
\ No newline at end of file
+// GetRevisions returns a message's edit history to its author or a moderator
+func (s *messageService) GetRevisions(userId, messageId int) ([]models.MessageRevision, error) {
+	message, err := s.messageRepository.GetMessageById(messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(message.UserId) == userId {
+		if _, err := requireMember(s.groupRepository, userId, int(message.GroupId)); err != nil {
+			return nil, err
+		}
+	} else if _, err := authorize(s.groupRepository, userId, int(message.GroupId), models.ViewRevisionsPermission); err != nil {
+		return nil, err
+	}
+
+	return s.messageRepository.GetRevisions(messageId)
+}
+
+
+func (s *messageService) GetMessageById(userId, id int) (*models.Message, error) {
+	message, err := s.messageRepository.GetMessageById(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := requireMember(s.groupRepository, userId, int(message.GroupId)); err != nil {
+		return nil, err
+	}
+
+	summaries, err := s.reactionRepository.GetReactionSummaries([]uint{ message.ID }, userId)
+	if err != nil {
+		return nil, err
+	}
+	message.Reactions = summaries[message.ID]
+
+	messages := []models.Message{ *message }
+	if err := attachPolls(s.pollRepository, s.groupRepository, userId, messages); err != nil {
+		return nil, err
+	}
+	message.Poll = messages[0].Poll
+
+	signAttachment(s.signer, message.Attachment)
+
+	return message, attachAuthor(s.groupRepository, message)
+}
+
+
+// MarkRead moves the caller's read marker in the message's group up to the message
+func (s *messageService) MarkRead(userId, messageId int) (*models.ReadReceipt, error) {
+	message, err := s.messageRepository.GetMessageById(messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := requireMember(s.groupRepository, userId, int(message.GroupId)); err != nil {
+		return nil, err
+	}
+
+	if err := s.groupRepository.MarkRead(int(message.GroupId), userId, message.Seq); err != nil {
+		return nil, err
+	}
+
+	reader, err := s.GetAuthor(int(message.GroupId), userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.ReadReceipt{
+		UserId: reader.UserId,
+		GroupId: message.GroupId,
+		MessageId: message.ID,
+		Seq: message.Seq,
+		Reader: *reader,
+	}, nil
+}
+
+
+// GetAuthor returns how the user is shown to the rest of the group
+func (s *messageService) GetAuthor(groupId, userId int) (*models.Author, error) {
+	group, err := s.groupRepository.GetGroupById(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	if group.IsAnonymous() {
+		author := group.Pseudonym(uint(userId))
+		return &author, nil
+	}
+
+	users, err := s.groupRepository.GetUsersByIds([]uint{ uint(userId) })
+	if err != nil {
+		return nil, err
+	}
+
+	if len(users) == 0 {
+		return nil, apperrors.NewNotFound("User", strconv.Itoa(userId))
+	}
+
+	author := models.NewAuthor(users[0])
+	return &author, nil
+}
+
+
+// GetSeenCount returns how many members other than the author have read the message
+func (s *messageService) GetSeenCount(userId, messageId int) (int64, error) {
+	message, err := s.messageRepository.GetMessageById(messageId)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := requireMember(s.groupRepository, userId, int(message.GroupId)); err != nil {
+		return 0, err
+	}
+
+	return s.groupRepository.CountReaders(int(message.GroupId), message.Seq, message.UserId)
+}
+
+
+// SearchMessages runs a full-text search in one group, or in all of the caller's groups
+func (s *messageService) SearchMessages(userId int, search models.MessageSearch) ([]models.SearchResult, error) {
+	if strings.TrimSpace(search.Query) == "" {
+		return nil, apperrors.NewBadRequest("A search query is required")
+	}
+
+	if search.GroupId != 0 {
+		if _, err := requireMember(s.groupRepository, userId, search.GroupId); err != nil {
+			return nil, err
+		}
+	}
+
+	results, err := s.messageRepository.SearchMessages(userId, search)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]models.Message, len(results))
+	for i := range results {
+		messages[i] = results[i].Message
+	}
+
+	if err := attachAuthors(s.groupRepository, messages); err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		results[i].Message.Author = messages[i].Author
+		signAttachment(s.signer, results[i].Message.Attachment)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"darkoo/models"
+
+	"log"
+	"regexp"
+	"strings"
+)
+
+
+// mentionPattern matches @username at the start of the text or after a non-word
+// character, so email addresses such as a@b.com are not taken as mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@(\w+)`)
+
+
+type notificationService struct {
+	notificationRepository models.INotificationRepository
+}
+
+
+func NewNotificationService(NotificationRepository models.INotificationRepository) models.INotificationService {
+	return &notificationService{
+		notificationRepository: NotificationRepository,
+	}
+}
+
+
+func (s *notificationService) GetNotifications(userId int, unreadOnly bool, query models.PageQuery) (*models.NotificationPage, error) {
+	return s.notificationRepository.GetNotifications(userId, unreadOnly, query)
+}
+
+
+func (s *notificationService) MarkRead(userId, id int) error {
+	return s.notificationRepository.MarkRead(userId, id)
+}
+
+
+func (s *notificationService) MarkAllRead(userId int) error {
+	return s.notificationRepository.MarkAllRead(userId)
+}
+
+
+func (s *notificationService) GetUnreadCount(userId int) (int64, error) {
+	return s.notificationRepository.GetUnreadCount(userId)
+}
+
+
+// parseMentions returns the distinct lowercased usernames mentioned in content
+func parseMentions(content string) []string {
+	seen := map[string]bool{}
+	var userNames []string
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		userName := strings.ToLower(match[1])
+		if !seen[userName] {
+			seen[userName] = true
+			userNames = append(userNames, userName)
+		}
+	}
+
+	return userNames
+}
+
+
+// notifyMentions stores a mention notification for each group member named in the
+// message. The sender is skipped, as is anyone who is not an active member.
+func notifyMentions(groupRepository models.IGroupRepository, notificationRepository models.INotificationRepository,
+	message *models.Message) []models.Notification {
+	userNames := parseMentions(message.Content)
+	if len(userNames) == 0 {
+		return nil
+	}
+
+	members, err := groupRepository.GetMembersByUserNames(int(message.GroupId), userNames)
+	if err != nil {
+		log.Printf("Could not resolve mentions in message %d\n", message.ID)
+		return nil
+	}
+
+	var notifications []models.Notification
+
+	for _, member := range members {
+		if member.ID == message.UserId {
+			continue
+		}
+
+		notifications = append(notifications, models.Notification{
+			UserId: member.ID,
+			GroupId: message.GroupId,
+			MessageId: message.ID,
+			ActorId: message.UserId,
+			Type: models.MentionNotification,
+			Preview: message.Preview(),
+		})
+	}
+
+	if err := notificationRepository.CreateNotifications(notifications); err != nil {
+		log.Printf("Could not store mentions in message %d\n", message.ID)
+		return nil
+	}
+
+	return notifications
+}
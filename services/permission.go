@@ -0,0 +1,75 @@
+package services
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"fmt"
+	"log"
+	"time"
+)
+
+
+// requireMember loads the caller's membership and rejects banned or non-members
+func requireMember(groupRepository models.IGroupRepository, userId, groupId int) (*models.UserGroup, error) {
+	membership, err := groupRepository.GetMembership(groupId, userId)
+
+	if err != nil || membership.IsBanned(time.Now()) {
+		log.Printf("User %d is not an active member of group %d\n", userId, groupId)
+		return nil, apperrors.NewAuthorization("You are not a member of this group")
+	}
+
+	return membership, nil
+}
+
+
+// authorize loads the caller's membership and checks it against the role permission matrix
+func authorize(groupRepository models.IGroupRepository, userId, groupId int, permission models.GroupPermission) (*models.UserGroup, error) {
+	membership, err := requireMember(groupRepository, userId, groupId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !membership.Role.Can(permission) {
+		log.Printf("User %d lacks %s permission in group %d\n", userId, permission, groupId)
+		return nil, apperrors.NewAuthorization(apperrors.Unauthorized)
+	}
+
+	return membership, nil
+}
+
+
+// authorizeOver is authorize plus a check that the caller outranks the target member
+func authorizeOver(groupRepository models.IGroupRepository, actorId, groupId, targetId int, permission models.GroupPermission) (*models.UserGroup, error) {
+	actor, err := authorize(groupRepository, actorId, groupId, permission)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := groupRepository.GetMembership(groupId, targetId)
+	if err != nil {
+		return nil, apperrors.NewBadRequest("User does not belong to this group")
+	}
+
+	if !actor.Role.Outranks(target.Role) {
+		log.Printf("User %d does not outrank user %d in group %d\n", actorId, targetId, groupId)
+		return nil, apperrors.NewAuthorization("You cannot moderate a member with an equal or higher role")
+	}
+
+	return actor, nil
+}
+
+
+// requireUnmuted rejects members who are currently muted from posting
+func requireUnmuted(membership *models.UserGroup) error {
+	if !membership.IsMuted(time.Now()) {
+		return nil
+	}
+
+	if membership.MutedUntil != nil {
+		return apperrors.NewAuthorization(fmt.Sprintf("You are muted in this group until %s", membership.MutedUntil.UTC().Format(time.RFC3339)))
+	}
+
+	return apperrors.NewAuthorization("You are muted in this group")
+}
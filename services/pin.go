@@ -0,0 +1,139 @@
+package services
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+	"darkoo/storage"
+)
+
+
+type pinService struct {
+	pinRepository      models.IPinRepository
+	messageRepository  models.IMessageRepository
+	groupRepository    models.IGroupRepository
+	reactionRepository models.IReactionRepository
+	pollRepository     models.IPollRepository
+	signer             *storage.URLSigner
+	maxPins            int
+}
+
+
+func NewPinService(PinRepository models.IPinRepository, MessageRepository models.IMessageRepository,
+	GroupRepository models.IGroupRepository, ReactionRepository models.IReactionRepository,
+	PollRepository models.IPollRepository, Signer *storage.URLSigner, maxPins int) models.IPinService {
+	return &pinService{
+		pinRepository: PinRepository,
+		messageRepository: MessageRepository,
+		groupRepository: GroupRepository,
+		reactionRepository: ReactionRepository,
+		pollRepository: PollRepository,
+		signer: Signer,
+		maxPins: maxPins,
+	}
+}
+
+
+func (s *pinService) PinMessage(userId, messageId int) (*models.PinnedMessage, error) {
+	message, err := s.messageRepository.GetMessageById(messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := authorize(s.groupRepository, userId, int(message.GroupId), models.PinMessagesPermission); err != nil {
+		return nil, err
+	}
+
+	if message.IsRemoved() {
+		return nil, apperrors.NewBadRequest("This message was removed by a moderator")
+	}
+
+	pin := &models.PinnedMessage{
+		GroupId: message.GroupId,
+		MessageId: message.ID,
+		Message: *message,
+		PinnedById: uint(userId),
+	}
+
+	pinned, err := s.pinRepository.PinMessage(pin, s.maxPins)
+	if err != nil {
+		return nil, err
+	}
+
+	pins := []models.PinnedMessage{ *pinned }
+	if err := s.decoratePins(userId, message.GroupId, pins); err != nil {
+		return nil, err
+	}
+
+	return &pins[0], nil
+}
+
+
+func (s *pinService) UnpinMessage(userId, messageId int) (*models.Message, error) {
+	message, err := s.messageRepository.GetMessageById(messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := authorize(s.groupRepository, userId, int(message.GroupId), models.PinMessagesPermission); err != nil {
+		return nil, err
+	}
+
+	if err := s.pinRepository.UnpinMessage(userId, message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+
+func (s *pinService) GetPinnedMessages(userId, groupId int) ([]models.PinnedMessage, error) {
+	if _, err := requireMember(s.groupRepository, userId, groupId); err != nil {
+		return nil, err
+	}
+
+	pins, err := s.pinRepository.GetPinnedMessages(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	return pins, s.decoratePins(userId, uint(groupId), pins)
+}
+
+
+// decoratePins shows pinned messages the same way message listings do
+func (s *pinService) decoratePins(userId int, groupId uint, pins []models.PinnedMessage) error {
+	messages := make([]models.Message, len(pins))
+	for i := range pins {
+		messages[i] = pins[i].Message
+	}
+
+	if err := decorateMessages(s.groupRepository, s.reactionRepository, s.pollRepository, s.signer, userId, messages); err != nil {
+		return err
+	}
+
+	for i := range pins {
+		pins[i].Message = messages[i]
+	}
+
+	return s.hidePinners(groupId, pins)
+}
+
+
+// hidePinners leaves out who pinned each message in anonymous groups, where
+// members are only ever shown by pseudonym
+func (s *pinService) hidePinners(groupId uint, pins []models.PinnedMessage) error {
+	group, err := s.groupRepository.GetGroupById(int(groupId))
+	if err != nil {
+		return err
+	}
+
+	if !group.IsAnonymous() {
+		return nil
+	}
+
+	for i := range pins {
+		pins[i].PinnedById = 0
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"time"
+)
+
+
+type pollService struct {
+	pollRepository    models.IPollRepository
+	messageRepository models.IMessageRepository
+	groupRepository   models.IGroupRepository
+}
+
+
+func NewPollService(PollRepository models.IPollRepository, MessageRepository models.IMessageRepository,
+	GroupRepository models.IGroupRepository) models.IPollService {
+	return &pollService{
+		pollRepository: PollRepository,
+		messageRepository: MessageRepository,
+		groupRepository: GroupRepository,
+	}
+}
+
+
+func (s *pollService) GetPoll(userId, messageId int) (*models.Poll, error) {
+	message, err := s.messageRepository.GetMessageById(messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := requireMember(s.groupRepository, userId, int(message.GroupId)); err != nil {
+		return nil, err
+	}
+
+	return s.withResults(userId, message)
+}
+
+
+// Vote replaces the caller's choice in the poll, so voting again changes a vote
+// rather than adding to it
+func (s *pollService) Vote(userId, messageId int, optionIds []uint) (*models.Poll, error) {
+	message, poll, err := s.votablePoll(userId, messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkChoice(poll, optionIds); err != nil {
+		return nil, err
+	}
+
+	if err := s.pollRepository.ReplaceVotes(poll, userId, optionIds, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return s.withResults(userId, message)
+}
+
+
+func (s *pollService) RetractVote(userId, messageId int) (*models.Poll, error) {
+	message, poll, err := s.votablePoll(userId, messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.pollRepository.ReplaceVotes(poll, userId, nil, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return s.withResults(userId, message)
+}
+
+
+// votablePoll loads the poll of a message and applies the same membership checks
+// as sending one. Closed polls take no more votes.
+func (s *pollService) votablePoll(userId, messageId int) (*models.Message, *models.Poll, error) {
+	message, err := s.messageRepository.GetMessageById(messageId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	membership, err := requireMember(s.groupRepository, userId, int(message.GroupId))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := requireUnmuted(membership); err != nil {
+		return nil, nil, err
+	}
+
+	if message.IsRemoved() {
+		return nil, nil, apperrors.NewBadRequest("This message was removed by a moderator")
+	}
+
+	poll, err := s.pollRepository.GetPollByMessageId(messageId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if poll.IsClosed(time.Now()) {
+		return nil, nil, apperrors.NewBadRequest("This poll is closed")
+	}
+
+	return message, poll, nil
+}
+
+
+func (s *pollService) withResults(userId int, message *models.Message) (*models.Poll, error) {
+	messages := []models.Message{ *message }
+
+	if err := attachPolls(s.pollRepository, s.groupRepository, userId, messages); err != nil {
+		return nil, err
+	}
+
+	if messages[0].Poll == nil {
+		return nil, apperrors.NewBadRequest("This message is not a poll")
+	}
+
+	return messages[0].Poll, nil
+}
+
+
+// checkChoice makes sure the options belong to the poll, are picked once each, and
+// that single choice polls get exactly one
+func checkChoice(poll *models.Poll, optionIds []uint) error {
+	if len(optionIds) == 0 {
+		return apperrors.NewBadRequest("Choose at least one option")
+	}
+
+	if !poll.MultipleChoice && len(optionIds) > 1 {
+		return apperrors.NewBadRequest("Only one option can be chosen in this poll")
+	}
+
+	seen := map[uint]bool{}
+	for _, optionId := range optionIds {
+		if !poll.HasOption(optionId) {
+			return apperrors.NewBadRequest("Option does not belong to this poll")
+		}
+
+		if seen[optionId] {
+			return apperrors.NewBadRequest("Each option can only be chosen once")
+		}
+		seen[optionId] = true
+	}
+
+	return nil
+}
+
+
+// attachPolls fills in the poll of each poll message with its results as seen by
+// userId. Polls of removed messages are left out along with their content.
+func attachPolls(pollRepository models.IPollRepository, groupRepository models.IGroupRepository, userId int, messages []models.Message) error {
+	ids := []uint{}
+	for _, message := range messages {
+		if message.ContentType == "poll" && !message.IsRemoved() {
+			ids = append(ids, message.ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	polls, err := pollRepository.GetPollsByMessageIds(ids)
+	if err != nil {
+		return err
+	}
+
+	pollIds := make([]uint, len(polls))
+	for i := range polls {
+		pollIds[i] = polls[i].ID
+	}
+
+	votes, err := pollRepository.GetPollVotes(pollIds)
+	if err != nil {
+		return err
+	}
+
+	votesByPoll := map[uint][]models.PollVote{}
+	for _, vote := range votes {
+		votesByPoll[vote.PollId] = append(votesByPoll[vote.PollId], vote)
+	}
+
+	authors, err := pollVoters(groupRepository, polls, votesByPoll)
+	if err != nil {
+		return err
+	}
+
+	byMessage := map[uint]*models.Poll{}
+	now := time.Now()
+
+	for i := range polls {
+		poll := &polls[i]
+		poll.Tally(votesByPoll[poll.ID], uint(userId), authors[poll.GroupId], now)
+		byMessage[poll.MessageId] = poll
+	}
+
+	for i := range messages {
+		if poll, ok := byMessage[messages[i].ID]; ok {
+			messages[i].Poll = poll
+		}
+	}
+
+	return nil
+}
+
+
+// pollVoters looks up how voters in polls that show them appear in each group
+func pollVoters(groupRepository models.IGroupRepository, polls []models.Poll, votesByPoll map[uint][]models.PollVote) (map[uint]map[uint]models.Author, error) {
+	authors := map[uint]map[uint]models.Author{}
+	userIds := []uint{}
+	seen := map[uint]bool{}
+
+	for _, poll := range polls {
+		if poll.Anonymous {
+			continue
+		}
+
+		for _, vote := range votesByPoll[poll.ID] {
+			if !seen[vote.UserId] {
+				seen[vote.UserId] = true
+				userIds = append(userIds, vote.UserId)
+			}
+		}
+	}
+
+	if len(userIds) == 0 {
+		return authors, nil
+	}
+
+	users, err := groupRepository.GetUsersByIds(userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, poll := range polls {
+		if poll.Anonymous || authors[poll.GroupId] != nil {
+			continue
+		}
+
+		group, err := groupRepository.GetGroupById(int(poll.GroupId))
+		if err != nil {
+			return nil, err
+		}
+
+		groupAuthors := map[uint]models.Author{}
+		for _, user := range users {
+			groupAuthors[user.ID] = group.AuthorOf(user)
+		}
+		authors[poll.GroupId] = groupAuthors
+	}
+
+	return authors, nil
+}
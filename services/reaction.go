@@ -0,0 +1,117 @@
+package services
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+	"darkoo/storage"
+)
+
+
+type reactionService struct {
+	reactionRepository models.IReactionRepository
+	messageRepository  models.IMessageRepository
+	groupRepository    models.IGroupRepository
+	signer             *storage.URLSigner
+}
+
+
+func NewReactionService(ReactionRepository models.IReactionRepository, MessageRepository models.IMessageRepository,
+	GroupRepository models.IGroupRepository, Signer *storage.URLSigner) models.IReactionService {
+	return &reactionService{
+		reactionRepository: ReactionRepository,
+		messageRepository: MessageRepository,
+		groupRepository: GroupRepository,
+		signer: Signer,
+	}
+}
+
+
+func (s *reactionService) AddReaction(userId, messageId int, emoji string) (*models.Message, error) {
+	message, err := s.reactableMessage(userId, messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	reaction := &models.Reaction{
+		MessageId: message.ID,
+		UserId: uint(userId),
+		Emoji: emoji,
+	}
+
+	if err := s.reactionRepository.AddReaction(reaction); err != nil {
+		return nil, err
+	}
+
+	return s.withReactions(userId, message)
+}
+
+
+func (s *reactionService) RemoveReaction(userId, messageId int, emoji string) (*models.Message, error) {
+	message, err := s.reactableMessage(userId, messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.reactionRepository.RemoveReaction(messageId, userId, emoji); err != nil {
+		return nil, err
+	}
+
+	return s.withReactions(userId, message)
+}
+
+
+func (s *reactionService) withReactions(userId int, message *models.Message) (*models.Message, error) {
+	summaries, err := s.reactionRepository.GetReactionSummaries([]uint{ message.ID }, userId)
+	if err != nil {
+		return nil, err
+	}
+	message.Reactions = summaries[message.ID]
+
+	signAttachment(s.signer, message.Attachment)
+
+	return message, attachAuthor(s.groupRepository, message)
+}
+
+
+// reactableMessage loads a message and applies the same membership checks as sending one
+func (s *reactionService) reactableMessage(userId, messageId int) (*models.Message, error) {
+	message, err := s.messageRepository.GetMessageById(messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	membership, err := requireMember(s.groupRepository, userId, int(message.GroupId))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireUnmuted(membership); err != nil {
+		return nil, err
+	}
+
+	if message.IsRemoved() {
+		return nil, apperrors.NewBadRequest("This message was removed by a moderator")
+	}
+
+	return message, nil
+}
+
+
+// attachReactions fills in the reaction summaries of each message as seen by userId
+func attachReactions(reactionRepository models.IReactionRepository, userId int, messages []models.Message) error {
+	ids := make([]uint, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+	}
+
+	summaries, err := reactionRepository.GetReactionSummaries(ids, userId)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Reactions = summaries[messages[i].ID]
+	}
+
+	return nil
+}
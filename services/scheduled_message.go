@@ -0,0 +1,192 @@
+package services
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+
+	"log"
+	"net/http"
+	"time"
+)
+
+
+const (
+	// scheduledMessageBatch is how many due messages are claimed per query
+	scheduledMessageBatch = 50
+	// staleScheduledClaim is how long a claim can go unfinished before another
+	// dispatcher takes it over
+	staleScheduledClaim = 5 * time.Minute
+	// maxScheduledAttempts is how many times sending is tried before giving up
+	maxScheduledAttempts = 5
+	// scheduledRetryBackoff is how long the first retry waits, doubling with each
+	// attempt after it
+	scheduledRetryBackoff = time.Minute
+)
+
+
+type scheduledMessageService struct {
+	scheduledMessageRepository models.IScheduledMessageRepository
+	groupRepository            models.IGroupRepository
+	messageService             models.IMessageService
+}
+
+
+func NewScheduledMessageService(ScheduledMessageRepository models.IScheduledMessageRepository,
+	GroupRepository models.IGroupRepository, MessageService models.IMessageService) models.IScheduledMessageService {
+	return &scheduledMessageService{
+		scheduledMessageRepository: ScheduledMessageRepository,
+		groupRepository: GroupRepository,
+		messageService: MessageService,
+	}
+}
+
+
+// ScheduleMessage checks the message the way sending it would, so mistakes show
+// up now rather than when it is due. Membership is checked again at send time.
+func (s *scheduledMessageService) ScheduleMessage(scheduled *models.ScheduledMessage) (*models.ScheduledMessage, error) {
+	if err := checkScheduled(scheduled); err != nil {
+		return nil, err
+	}
+
+	membership, err := requireMember(s.groupRepository, int(scheduled.UserId), int(scheduled.GroupId))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireUnmuted(membership); err != nil {
+		return nil, err
+	}
+
+	scheduled.Status = models.PendingScheduledMessage
+	return s.scheduledMessageRepository.CreateScheduledMessage(scheduled)
+}
+
+
+func (s *scheduledMessageService) GetScheduledMessage(userId, id int) (*models.ScheduledMessage, error) {
+	return s.scheduledMessageRepository.GetScheduledMessage(userId, id)
+}
+
+
+func (s *scheduledMessageService) GetScheduledMessages(userId, groupId int) ([]models.ScheduledMessage, error) {
+	return s.scheduledMessageRepository.GetScheduledMessages(userId, groupId)
+}
+
+
+// UpdateScheduledMessage replaces what a pending message says and when it goes
+// out. Its group and thread stay as they were.
+func (s *scheduledMessageService) UpdateScheduledMessage(userId, id int, changes models.ScheduledMessage) (*models.ScheduledMessage, error) {
+	scheduled, err := s.scheduledMessageRepository.GetScheduledMessage(userId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if scheduled.Status != models.PendingScheduledMessage {
+		return nil, apperrors.NewConflictWithMessage("This message is no longer pending and cannot be changed")
+	}
+
+	scheduled.Content = changes.Content
+	scheduled.ContentType = changes.ContentType
+	scheduled.AttachmentId = changes.AttachmentId
+	scheduled.Payload = changes.Payload
+	scheduled.SendAt = changes.SendAt
+
+	if err := checkScheduled(scheduled); err != nil {
+		return nil, err
+	}
+
+	if err := s.scheduledMessageRepository.UpdateScheduledMessage(scheduled); err != nil {
+		return nil, err
+	}
+
+	return scheduled, nil
+}
+
+
+func (s *scheduledMessageService) CancelScheduledMessage(userId, id int) error {
+	if _, err := s.scheduledMessageRepository.GetScheduledMessage(userId, id); err != nil {
+		return err
+	}
+
+	return s.scheduledMessageRepository.CancelScheduledMessage(userId, id)
+}
+
+
+// DispatchDue sends due messages through SendMessage, so they go through the same
+// checks as messages sent by hand at the moment they go out
+func (s *scheduledMessageService) DispatchDue(publish func(message *models.Message)) (int, error) {
+	sent := 0
+
+	for {
+		now := time.Now()
+
+		claimed, err := s.scheduledMessageRepository.ClaimDueScheduledMessages(now, now.Add(-staleScheduledClaim), scheduledMessageBatch)
+		if err != nil {
+			return sent, err
+		}
+
+		for i := range claimed {
+			message, err := s.dispatch(&claimed[i])
+			if err != nil {
+				return sent, err
+			}
+
+			if message != nil {
+				publish(message)
+				sent++
+			}
+		}
+
+		if len(claimed) < scheduledMessageBatch {
+			return sent, nil
+		}
+	}
+}
+
+
+// dispatch sends a claimed message and records how it went. Returns the message
+// when it was sent just now, and nil when it was sent before or couldn't be.
+func (s *scheduledMessageService) dispatch(scheduled *models.ScheduledMessage) (*models.Message, error) {
+	// A claim taken over from a dispatcher that stopped partway may already
+	// have been sent
+	existing, err := s.scheduledMessageRepository.GetMessageByScheduledMessageId(scheduled.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing != nil {
+		return nil, s.scheduledMessageRepository.CompleteScheduledMessage(scheduled, &existing.ID, "")
+	}
+
+	message, err := s.messageService.SendMessage(scheduled.ToMessage())
+	if err != nil {
+		if apperrors.Status(err) >= http.StatusInternalServerError && scheduled.Attempts < maxScheduledAttempts {
+			log.Printf("Could not send scheduled message with ID: %d, will retry: %v\n", scheduled.ID, err)
+			retryAt := time.Now().Add(scheduledRetryBackoff << (scheduled.Attempts - 1))
+			return nil, s.scheduledMessageRepository.ReleaseScheduledMessage(scheduled, retryAt)
+		}
+
+		log.Printf("Could not send scheduled message with ID: %d: %v\n", scheduled.ID, err)
+		return nil, s.scheduledMessageRepository.CompleteScheduledMessage(scheduled, nil, err.Error())
+	}
+
+	if err := s.scheduledMessageRepository.CompleteScheduledMessage(scheduled, &message.ID, ""); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+
+// checkScheduled validates a scheduled message against its content type, the
+// same as SendMessage does, and makes sure it is due in the future
+func checkScheduled(scheduled *models.ScheduledMessage) error {
+	if err := models.ValidateUserContent(scheduled.ToMessage()); err != nil {
+		return apperrors.NewBadRequest(err.Error())
+	}
+
+	if !scheduled.SendAt.After(time.Now()) {
+		return apperrors.NewBadRequest("Scheduled messages must be sent at a time in the future")
+	}
+
+	return nil
+}
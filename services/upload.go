@@ -0,0 +1,345 @@
+package services
+
+import (
+	"darkoo/apperrors"
+	"darkoo/models"
+	"darkoo/storage"
+
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+
+const (
+	// expiredUploadBatch is how many abandoned sessions are collected per query
+	expiredUploadBatch = 100
+	// staleUploadClaim is how long completing can go unfinished before another
+	// request may try again
+	staleUploadClaim = 10 * time.Minute
+)
+
+
+type uploadService struct {
+	uploadRepository  models.IUploadRepository
+	attachmentService models.IAttachmentService
+	storage           storage.Storage
+	signer            *storage.URLSigner
+	maxSize           int64
+	ttl               time.Duration
+}
+
+
+func NewUploadService(UploadRepository models.IUploadRepository, AttachmentService models.IAttachmentService,
+	Storage storage.Storage, Signer *storage.URLSigner, maxSize int64, ttl time.Duration) models.IUploadService {
+	return &uploadService{
+		uploadRepository: UploadRepository,
+		attachmentService: AttachmentService,
+		storage: Storage,
+		signer: Signer,
+		maxSize: maxSize,
+		ttl: ttl,
+	}
+}
+
+
+// CreateUpload opens a session for a file of a known size. The upload's Body is
+// ignored, chunks are sent separately with AppendChunk.
+func (s *uploadService) CreateUpload(userId int, upload models.Upload) (*models.UploadSession, error) {
+	if !models.IsAttachmentKind(upload.Kind) {
+		return nil, apperrors.NewBadRequest(fmt.Sprintf("Files cannot be sent as %q messages", upload.Kind))
+	}
+
+	if upload.Size > s.maxSize {
+		return nil, apperrors.NewPayloadTooLarge(s.maxSize, upload.Size)
+	}
+
+	if upload.Size <= 0 {
+		return nil, apperrors.NewBadRequest("Uploaded file is empty")
+	}
+
+	session := &models.UploadSession{
+		UserId: uint(userId),
+		Kind: upload.Kind,
+		FileName: upload.FileName,
+		DeclaredType: upload.DeclaredType,
+		Size: upload.Size,
+		ExpiresAt: time.Now().Add(s.ttl),
+	}
+
+	return s.uploadRepository.CreateUploadSession(session)
+}
+
+
+func (s *uploadService) GetUpload(userId, id int) (*models.UploadSession, error) {
+	session, err := s.uploadRepository.GetUploadSession(userId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	signAttachment(s.signer, session.Attachment)
+	return session, nil
+}
+
+
+// AppendChunk stores the bytes sent from offset onwards, which has to be where the
+// session currently is. Whatever arrived before a dropped connection is kept, so
+// the client can resume after it. The chunk that reaches the end of the file
+// turns the session into an attachment.
+func (s *uploadService) AppendChunk(userId, id int, offset int64, body io.Reader) (*models.UploadSession, error) {
+	session, err := s.uploadRepository.GetUploadSession(userId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.IsComplete() {
+		return nil, apperrors.NewConflictWithMessage(fmt.Sprintf("Upload %d has already been completed", id))
+	}
+
+	if offset != session.Offset {
+		return nil, apperrors.NewConflictWithMessage(fmt.Sprintf("Upload %d is at offset %d, not %d", id, session.Offset, offset))
+	}
+
+	// A finished upload whose attachment could not be created is retried by
+	// sending an empty chunk at the end
+	if session.Offset < session.Size {
+		if err := s.storeChunk(session, body); err != nil {
+			return nil, err
+		}
+	}
+
+	if session.Offset == session.Size {
+		if err := s.complete(session); err != nil {
+			return nil, err
+		}
+	}
+
+	return session, nil
+}
+
+
+// storeChunk spools the chunk to a temporary file first, so its size is known
+// before it is stored and a dropped connection still leaves what was received
+func (s *uploadService) storeChunk(session *models.UploadSession, body io.Reader) error {
+	remaining := session.Size - session.Offset
+
+	tmp, err := os.CreateTemp("", "darkoo-chunk-*")
+	if err != nil {
+		log.Printf("Could not buffer chunk for upload %d: %v\n", session.ID, err)
+		return apperrors.NewInternal()
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	received, readErr := io.Copy(tmp, io.LimitReader(body, remaining+1))
+	if received > remaining {
+		return apperrors.NewPayloadTooLarge(remaining, received)
+	}
+
+	if readErr != nil {
+		log.Printf("Chunk for upload %d was cut off after %d bytes: %v\n", session.ID, received, readErr)
+	}
+
+	if received == 0 {
+		if readErr != nil {
+			return apperrors.NewBadRequest("Could not read uploaded chunk")
+		}
+		return nil
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		log.Printf("Could not rewind chunk for upload %d: %v\n", session.ID, err)
+		return apperrors.NewInternal()
+	}
+
+	chunk := &models.UploadChunk{
+		Offset: session.Offset,
+		Size: received,
+		StorageKey: fmt.Sprintf("uploads/%s/%s", session.UUID, uuid.Must(uuid.NewV4())),
+	}
+
+	if err := s.storage.Put(context.Background(), chunk.StorageKey, tmp, received, "application/octet-stream"); err != nil {
+		log.Printf("Could not store chunk for upload %d: %v\n", session.ID, err)
+		return apperrors.NewInternal()
+	}
+
+	if err := s.uploadRepository.AppendUploadChunk(session, chunk, time.Now().Add(s.ttl)); err != nil {
+		s.storage.Delete(context.Background(), chunk.StorageKey)
+		return err
+	}
+
+	return nil
+}
+
+
+// complete joins the chunks into an attachment, going through the same checks as
+// a regular upload, and then removes them. The session is claimed first so that
+// concurrent final chunks don't each create an attachment.
+func (s *uploadService) complete(session *models.UploadSession) error {
+	now := time.Now()
+	if err := s.uploadRepository.ClaimUploadSession(session, now, now.Add(-staleUploadClaim)); err != nil {
+		return err
+	}
+
+	if err := s.completeClaimed(session); err != nil {
+		if apperrors.Status(err) != http.StatusConflict {
+			if err := s.uploadRepository.ReleaseUploadSession(session); err != nil {
+				log.Printf("Could not release claim on upload %d: %v\n", session.ID, err)
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+
+// completeClaimed does the work of complete. Should a taken-over claim lose to
+// the request it replaced, the attachment made here is never sent and is
+// collected along with other unsent attachments.
+func (s *uploadService) completeClaimed(session *models.UploadSession) error {
+	chunks, err := s.uploadRepository.GetUploadChunks(session.ID)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(chunks))
+	for _, chunk := range chunks {
+		keys = append(keys, chunk.StorageKey)
+	}
+
+	body := &chunkReader{ storage: s.storage, keys: keys }
+	defer body.Close()
+
+	attachment, err := s.attachmentService.UploadAttachment(int(session.UserId), models.Upload{
+		FileName: session.FileName,
+		Kind: session.Kind,
+		DeclaredType: session.DeclaredType,
+		Size: session.Size,
+		Body: body,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.uploadRepository.CompleteUploadSession(session, attachment, time.Now().Add(s.ttl)); err != nil {
+		return err
+	}
+
+	s.deleteChunks(chunks)
+	return nil
+}
+
+
+// CancelUpload abandons an upload. An attachment it already produced is kept.
+func (s *uploadService) CancelUpload(userId, id int) error {
+	session, err := s.uploadRepository.GetUploadSession(userId, id)
+	if err != nil {
+		return err
+	}
+
+	chunks, err := s.uploadRepository.GetUploadChunks(session.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.uploadRepository.DeleteUploadSession(session.ID); err != nil {
+		return err
+	}
+
+	s.deleteChunks(chunks)
+	return nil
+}
+
+
+// CollectExpiredUploads deletes sessions that have gone quiet past their expiry,
+// along with their stored chunks, and reports how many were removed
+func (s *uploadService) CollectExpiredUploads() (int, error) {
+	collected := 0
+
+	for {
+		sessions, err := s.uploadRepository.GetExpiredUploadSessions(time.Now(), expiredUploadBatch)
+		if err != nil {
+			return collected, err
+		}
+
+		for _, session := range sessions {
+			if err := s.uploadRepository.DeleteUploadSession(session.ID); err != nil {
+				return collected, err
+			}
+
+			s.deleteChunks(session.Chunks)
+			collected++
+		}
+
+		if len(sessions) < expiredUploadBatch {
+			return collected, nil
+		}
+	}
+}
+
+
+func (s *uploadService) deleteChunks(chunks []models.UploadChunk) {
+	for _, chunk := range chunks {
+		if err := s.storage.Delete(context.Background(), chunk.StorageKey); err != nil && err != storage.ErrNotFound {
+			log.Printf("Could not delete upload chunk %s: %v\n", chunk.StorageKey, err)
+		}
+	}
+}
+
+
+// chunkReader reads stored chunks back to back, opening each one only when the
+// previous one is used up
+type chunkReader struct {
+	storage storage.Storage
+	keys    []string
+	current io.ReadSeekCloser
+}
+
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.keys) == 0 {
+				return 0, io.EOF
+			}
+
+			body, err := r.storage.Open(context.Background(), r.keys[0])
+			if err != nil {
+				return 0, fmt.Errorf("could not open upload chunk %s: %w", r.keys[0], err)
+			}
+
+			r.current = body
+			r.keys = r.keys[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			err = nil
+
+			if n == 0 {
+				continue
+			}
+		}
+
+		return n, err
+	}
+}
+
+
+func (r *chunkReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+
+	return r.current.Close()
+}
+
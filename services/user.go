@@ -7,19 +7,27 @@ import (
 
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/sethvargo/go-password/password"
 )
 
 type userService struct {
-	UserRepository models.IUserRepository
+	UserRepository        models.IUserRepository
+	GroupRepository       models.IGroupRepository
+	InviteRepository      models.IInviteRepository
+	JoinRequestRepository models.IJoinRequestRepository
 }
 
 
-func NewUserService(UserRepository models.IUserRepository) models.IUserService {
+func NewUserService(UserRepository models.IUserRepository, GroupRepository models.IGroupRepository,
+	InviteRepository models.IInviteRepository, JoinRequestRepository models.IJoinRequestRepository) models.IUserService {
 	return &userService{
 		UserRepository: UserRepository,
+		GroupRepository: GroupRepository,
+		InviteRepository: InviteRepository,
+		JoinRequestRepository: JoinRequestRepository,
 	}
 }
 
@@ -76,8 +84,29 @@ func (s *userService) GetUserByEmailOrUserName(email string) (*models.User, erro
 }
 
 
-func (s *userService) GetUsersByGroupId(id, limit, page int) ([]models.User, error) {
-	return s.UserRepository.GetUsersByGroupId(id, limit, page)
+// GetUsersByGroupId lists the members of a group to its other members. Hidden
+// groups, which include direct conversations, look like they don't exist to
+// anyone outside them. Members of anonymous groups are never listed, since that
+// would tie their accounts to the group.
+func (s *userService) GetUsersByGroupId(userId, groupId int, query models.PageQuery) (*models.UserPage, error) {
+	group, err := s.GroupRepository.GetGroupById(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := requireMember(s.GroupRepository, userId, groupId); err != nil {
+		if group.Visibility == models.HiddenGroupVisibility {
+			log.Printf("User %d cannot see hidden group %d\n", userId, groupId)
+			return nil, apperrors.NewNotFound("Group", strconv.Itoa(groupId))
+		}
+		return nil, err
+	}
+
+	if group.IsAnonymous() {
+		return nil, apperrors.NewAuthorization("Members of anonymous groups are not listed")
+	}
+
+	return s.UserRepository.GetUsersByGroupId(groupId, query)
 }
 
 
@@ -273,7 +302,7 @@ func (s *userService) VerifyTOTP(userId int, totp models.VerifyTOTPRequest) erro
 	user, err := s.UserRepository.GetUserById(userId)
 
 	if err != nil {
-		log.Print("Could not verify totp. User with ID %d not found\n", userId)
+		log.Printf("Could not verify totp. User with ID %d not found\n", userId)
 		return apperrors.NewBadRequest("Could not verify totp. User not found")
 	}
 
@@ -344,13 +373,91 @@ func (s *userService) UpdateUserImageNum(userId, num int) (int, error){
 
 
 
-func (s *userService) JoinGroup(userId, groupId int) error {
-	err := s.UserRepository.JoinGroup(userId, groupId)
+// JoinGroup adds the user to the group directly, or files a join request when the
+// group requires approval and no invite was provided. The request is nil when joined.
+func (s *userService) JoinGroup(userId, groupId int, inviteToken string) (*models.JoinRequest, error) {
+	group, err := s.GroupRepository.GetGroupById(groupId)
 
 	if err != nil {
 		log.Print("Could not join group")
-		return apperrors.NewBadRequest("Could not join group")
+		return nil, apperrors.NewBadRequest("Could not join group")
 	}
 
-	return nil
-}
\ No newline at end of file
+	if group.IsDirect() {
+		return nil, apperrors.NewAuthorization("Direct conversations can only be opened by their participants")
+	}
+
+	var invite *models.GroupInvite
+
+	if inviteToken != "" {
+		invite, err = s.InviteRepository.GetInviteByToken(inviteToken)
+
+		if err != nil || invite.GroupId != group.ID || !invite.IsUsable(time.Now()) {
+			log.Printf("Invalid invite provided for group %d\n", groupId)
+			return nil, apperrors.NewBadRequest("Invite is invalid or has expired")
+		}
+	}
+
+	if group.Visibility != models.PublicGroupVisibility && invite == nil {
+		log.Printf("Group %d requires an invite to join\n", groupId)
+		return nil, apperrors.NewAuthorization("This group can only be joined with an invite")
+	}
+
+	if group.NeedsApproval() && invite == nil {
+		return s.requestToJoinGroup(userId, groupId)
+	}
+
+	err = s.UserRepository.JoinGroup(userId, groupId, invite)
+
+	if err != nil {
+		log.Print("Could not join group")
+		return nil, apperrors.GetAppError(err, "Could not join group")
+	}
+
+	return nil, nil
+}
+
+
+func (s *userService) requestToJoinGroup(userId, groupId int) (*models.JoinRequest, error) {
+	if _, err := s.GroupRepository.GetMembership(groupId, userId); err == nil {
+		log.Print("User is already a member of group")
+		return nil, apperrors.NewBadRequest("User is already a member of group")
+	}
+
+	if _, err := s.JoinRequestRepository.GetPendingJoinRequest(groupId, userId); err == nil {
+		log.Print("User already has a pending join request")
+		return nil, apperrors.NewConflict("JoinRequest", "pending")
+	}
+
+	request := &models.JoinRequest{
+		GroupId: uint(groupId),
+		UserId: uint(userId),
+		Status: models.PendingJoinRequest,
+	}
+
+	return s.JoinRequestRepository.CreateJoinRequest(request)
+}
+
+
+// LeaveGroup removes the user from the group. Owners must hand the group over
+// or delete it first, and banned members cannot leave to shed their ban.
+func (s *userService) LeaveGroup(userId, groupId int) error {
+	membership, err := s.GroupRepository.GetMembership(groupId, userId)
+
+	if err != nil {
+		log.Print("User is not a part of this group")
+		return apperrors.NewBadRequest("User is not a part of this group")
+	}
+
+	if membership.Role == models.GroupOwnerRole {
+		log.Printf("Owner %d tried to leave group %d\n", userId, groupId)
+		return apperrors.NewBadRequest("Transfer ownership or delete the group before leaving")
+	}
+
+	if membership.IsBanned(time.Now()) {
+		log.Printf("Banned user %d tried to leave group %d\n", userId, groupId)
+		return apperrors.NewBadRequest("Banned members cannot leave the group")
+	}
+
+	return s.UserRepository.LeaveGroup(userId, groupId)
+}
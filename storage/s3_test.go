@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion    = "eu-west-1"
+	testBucket    = "darkoo"
+)
+
+
+// fakeS3 is a path-style bucket that holds objects in memory and turns away any
+// request whose SigV4 signature doesn't check out
+type fakeS3 struct {
+	t       *testing.T
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	fake := &fakeS3{ t: t, objects: map[string][]byte{}, types: map[string]string{} }
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := verifySignature(r, testSecretKey); err != nil {
+		f.t.Logf("rejected %s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+		return
+	}
+
+	prefix := "/" + testBucket + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, prefix)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil || int64(len(body)) != r.ContentLength {
+			http.Error(w, "<Error><Code>IncompleteBody</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = body
+		f.types[key] = r.Header.Get("Content-Type")
+
+	case http.MethodGet:
+		body, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+
+		if spec := r.Header.Get("Range"); spec != "" {
+			start, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(spec, "bytes="), "-"), 10, 64)
+			if err != nil || start >= int64(len(body)) {
+				http.Error(w, "<Error><Code>InvalidRange</Code></Error>", http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(body)-1, len(body)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)-int(start)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(body[start:])
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+
+	case http.MethodDelete:
+		if _, ok := f.objects[key]; !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+
+// verifySignature recomputes the request's SigV4 signature from what arrived on
+// the wire, the way S3 does
+func verifySignature(r *http.Request, secretKey string) error {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+		return fmt.Errorf("missing SigV4 authorization")
+	}
+
+	fields := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+		pair := strings.SplitN(part, "=", 2)
+		if len(pair) != 2 {
+			return fmt.Errorf("malformed authorization %q", auth)
+		}
+		fields[pair[0]] = pair[1]
+	}
+
+	credential := strings.SplitN(fields["Credential"], "/", 2)
+	if len(credential) != 2 || credential[0] != testAccessKey {
+		return fmt.Errorf("unknown credential %q", fields["Credential"])
+	}
+	scope := credential[1]
+	date := strings.SplitN(scope, "/", 2)[0]
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if !strings.HasPrefix(amzDate, date) {
+		return fmt.Errorf("date %q is outside scope %q", amzDate, scope)
+	}
+
+	if scope != date+"/"+testRegion+"/s3/aws4_request" {
+		return fmt.Errorf("unexpected scope %q", scope)
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	if !sort.StringsAreSorted(signed) {
+		return fmt.Errorf("signed headers are not sorted: %v", signed)
+	}
+
+	var headers strings.Builder
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.RawQuery,
+		headers.String(),
+		fields["SignedHeaders"],
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{ "AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:]) }, "\n")
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{ date, testRegion, "s3", "aws4_request", stringToSign } {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+
+	if !hmac.Equal([]byte(hex.EncodeToString(key)), []byte(fields["Signature"])) {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	return nil
+}
+
+
+func newTestS3(t *testing.T, endpoint, secretKey string) *S3 {
+	s3, err := NewS3(S3Config{
+		Endpoint: endpoint,
+		Region: testRegion,
+		Bucket: testBucket,
+		AccessKey: testAccessKey,
+		SecretKey: secretKey,
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s3
+}
+
+
+func TestS3RoundTrip(t *testing.T) {
+	fake, server := newFakeS3(t)
+	s3 := newTestS3(t, server.URL, testSecretKey)
+	ctx := context.Background()
+	content := []byte("the quick brown fox jumps over the lazy dog")
+
+	if err := s3.Put(ctx, "attachments/fox.txt", bytes.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if got := fake.types["attachments/fox.txt"]; got != "text/plain" {
+		t.Errorf("stored content type %q, want text/plain", got)
+	}
+
+	object, err := s3.Open(ctx, "attachments/fox.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer object.Close()
+
+	got, err := io.ReadAll(object)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("read %q, want %q", got, content)
+	}
+
+	if _, err := object.Seek(16, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	got, err = io.ReadAll(object)
+	if err != nil {
+		t.Fatalf("Read after seek: %v", err)
+	}
+	if !bytes.Equal(got, content[16:]) {
+		t.Errorf("read %q after seek, want %q", got, content[16:])
+	}
+
+	if err := s3.Delete(ctx, "attachments/fox.txt"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if _, err := s3.Open(ctx, "attachments/fox.txt"); err != ErrNotFound {
+		t.Errorf("Open after Delete returned %v, want ErrNotFound", err)
+	}
+
+	if err := s3.Delete(ctx, "attachments/fox.txt"); err != nil {
+		t.Errorf("deleting a missing object returned %v", err)
+	}
+}
+
+
+func TestS3WithoutContentType(t *testing.T) {
+	_, server := newFakeS3(t)
+	s3 := newTestS3(t, server.URL, testSecretKey)
+
+	if err := s3.Put(context.Background(), "chunks/1", strings.NewReader("abc"), 3, ""); err != nil {
+		t.Fatalf("Put without content type: %v", err)
+	}
+}
+
+
+func TestS3WrongSecret(t *testing.T) {
+	_, server := newFakeS3(t)
+	s3 := newTestS3(t, server.URL, "not-the-secret")
+
+	err := s3.Put(context.Background(), "attachments/x", strings.NewReader("x"), 1, "text/plain")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Put with the wrong secret returned %v, want a 403 error", err)
+	}
+}
+
+
+func TestS3InvalidKey(t *testing.T) {
+	_, server := newFakeS3(t)
+	s3 := newTestS3(t, server.URL, testSecretKey)
+
+	for _, key := range []string{ "", "/etc/passwd", "../outside", "a/../../b", "a//b" } {
+		if err := s3.Put(context.Background(), key, strings.NewReader("x"), 1, ""); err != ErrInvalidKey {
+			t.Errorf("Put(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+
+// signedQuery signs a link and pulls its query parameters back out
+func signedQuery(t *testing.T, signer *URLSigner, attachmentId uint, variant string, now time.Time) url.Values {
+	path, _ := signer.Sign(attachmentId, variant, now)
+
+	u, err := url.Parse(path)
+	if err != nil {
+		t.Fatalf("signed path %q does not parse: %v", path, err)
+	}
+
+	return u.Query()
+}
+
+
+func TestURLSignerVerifies(t *testing.T) {
+	signer := NewURLSigner([]byte("secret"), time.Hour)
+	now := time.Unix(1700000000, 0)
+
+	path, expires := signer.Sign(42, "", now)
+	if !strings.HasPrefix(path, "/api/attachments/42/download?") {
+		t.Errorf("unexpected path %q", path)
+	}
+	if !expires.Equal(now.Add(time.Hour)) {
+		t.Errorf("expires at %s, want %s", expires, now.Add(time.Hour))
+	}
+
+	query := signedQuery(t, signer, 42, "", now)
+	if !signer.Verify(42, "", query.Get("expires"), query.Get("sig"), now) {
+		t.Error("a fresh link does not verify")
+	}
+
+	query = signedQuery(t, signer, 42, "thumb_320", now)
+	if query.Get("variant") != "thumb_320" {
+		t.Errorf("variant %q, want thumb_320", query.Get("variant"))
+	}
+	if !signer.Verify(42, query.Get("variant"), query.Get("expires"), query.Get("sig"), now) {
+		t.Error("a fresh variant link does not verify")
+	}
+}
+
+
+func TestURLSignerExpiry(t *testing.T) {
+	signer := NewURLSigner([]byte("secret"), time.Hour)
+	now := time.Unix(1700000000, 0)
+	query := signedQuery(t, signer, 42, "", now)
+
+	if !signer.Verify(42, "", query.Get("expires"), query.Get("sig"), now.Add(time.Hour)) {
+		t.Error("a link does not verify at the moment it expires")
+	}
+
+	if signer.Verify(42, "", query.Get("expires"), query.Get("sig"), now.Add(time.Hour+time.Second)) {
+		t.Error("an expired link still verifies")
+	}
+}
+
+
+func TestURLSignerTampering(t *testing.T) {
+	signer := NewURLSigner([]byte("secret"), time.Hour)
+	now := time.Unix(1700000000, 0)
+	query := signedQuery(t, signer, 42, "thumb_320", now)
+	expires, sig := query.Get("expires"), query.Get("sig")
+
+	altered := sig[:len(sig)-1] + "A"
+	if altered == sig {
+		altered = sig[:len(sig)-1] + "B"
+	}
+
+	cases := []struct {
+		name         string
+		attachmentId uint
+		variant      string
+		expires      string
+		sig          string
+	}{
+		{ "other attachment", 43, "thumb_320", expires, sig },
+		{ "other variant", 42, "thumb_640", expires, sig },
+		{ "original instead of variant", 42, "", expires, sig },
+		{ "extended expiry", 42, "thumb_320", "1800000000", sig },
+		{ "malformed expiry", 42, "thumb_320", "soon", sig },
+		{ "altered signature", 42, "thumb_320", expires, altered },
+		{ "missing signature", 42, "thumb_320", expires, "" },
+	}
+
+	for _, c := range cases {
+		if signer.Verify(c.attachmentId, c.variant, c.expires, c.sig, now) {
+			t.Errorf("%s: tampered link verifies", c.name)
+		}
+	}
+
+	other := NewURLSigner([]byte("other secret"), time.Hour)
+	if other.Verify(42, "thumb_320", expires, sig, now) {
+		t.Error("a link verifies with a different secret")
+	}
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+
+var ErrNotFound = errors.New("storage: object not found")
+
+
+var ErrInvalidKey = errors.New("storage: invalid object key")
+
+
+// Storage keeps the bytes of uploaded files. Keys are generated by the server and
+// are slash separated relative paths, such as attachments/<uuid>. Opened objects
+// are seekable so downloads can serve byte ranges.
+type Storage interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+
+// cleanKey rejects keys that would escape the storage root once joined to it
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean(key)
+
+	if key == "" || cleaned != key || strings.HasPrefix(cleaned, "/") || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidKey
+	}
+
+	return cleaned, nil
+}
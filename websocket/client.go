@@ -13,12 +13,16 @@ import (
 
 // Message represents the structure of incoming WebSocket messages
 type Message struct {
-	Action        string `json:"action"`         // Action type (joinGroup or sendMessage)
+	Action        string `json:"action"`         // Action type (joinGroup, leaveGroup, markRead or sendMessage)
 	ContentType   string `json:"contentType"`    // Message content type
-	AttachmentURL string `json:"attachmentUrl"`  // Attachment URL (if any)
+	AttachmentID  string `json:"attachmentId"`   // Uploaded attachment (if any)
 	GroupID       string `json:"groupId"`        // Group ID
 	UserID        string `json:"userId"`         // User ID
 	Content       string `json:"content"`        // Message content
+	InviteToken   string `json:"inviteToken"`    // Invite token for joining non-public groups
+	ParentID      string `json:"parentId"`       // Message being replied to (if any)
+	MessageID     string `json:"messageId"`      // Message read up to (markRead)
+	Payload       models.JSON `json:"payload"`   // Structured content, checked against the content type
 }
 
 // Client represents a WebSocket client connection
@@ -52,19 +56,26 @@ func (c *Client) ReadPump(hub *Hub) {
 
 		switch msg.Action {
 		case "joinGroup":
-			// Handle joining a group
-			userId, _ := strconv.Atoi(msg.UserID)
+			// Handle joining a group as the authenticated user
+			userId, _ := strconv.Atoi(c.ID)
 			groupId, _ := strconv.Atoi(msg.GroupID)
-			if err := hub.UserService.JoinGroup(userId, groupId); err != nil {
+			request, err := hub.UserService.JoinGroup(userId, groupId, msg.InviteToken)
+			if err != nil {
 				log.Printf("Failed to join group: %v", err)
 				continue
 			}
+			if request != nil {
+				// Pending members stay out of the group's feed until approved
+				log.Printf("User %s requested to join group %s", c.ID, msg.GroupID)
+				continue
+			}
 			c.Group = msg.GroupID
-			log.Printf("User %s joined group %s", msg.UserID, msg.GroupID)
+			log.Printf("User %s joined group %s", c.ID, msg.GroupID)
 
 			joinNotification := map[string]string{
 				"action":  "groupNotification",
-				"message": "User " + msg.UserID + " has joined group " + msg.GroupID,
+				"groupId": msg.GroupID,
+				"message": memberName(hub, groupId, userId) + " has joined group " + msg.GroupID,
 			}
 
 			notificationJSON, err := json.Marshal(joinNotification)
@@ -74,24 +85,81 @@ func (c *Client) ReadPump(hub *Hub) {
 			}
 			hub.Broadcast <- notificationJSON
 
+		case "leaveGroup":
+			// Handle leaving a group as the authenticated user
+			userId, _ := strconv.Atoi(c.ID)
+			groupId, _ := strconv.Atoi(msg.GroupID)
+			if err := hub.UserService.LeaveGroup(userId, groupId); err != nil {
+				log.Printf("Failed to leave group: %v", err)
+				continue
+			}
+			if c.Group == msg.GroupID {
+				c.Group = ""
+			}
+			log.Printf("User %s left group %s", c.ID, msg.GroupID)
+
+			leaveNotification := map[string]string{
+				"action":  "groupNotification",
+				"groupId": msg.GroupID,
+				"message": memberName(hub, groupId, userId) + " has left group " + msg.GroupID,
+			}
+
+			notificationJSON, err := json.Marshal(leaveNotification)
+			if err != nil {
+				log.Printf("Failed to marshal leave notification: %v", err)
+				continue
+			}
+			hub.Broadcast <- notificationJSON
+
+		case "markRead":
+			// Move the authenticated user's read marker up to the message
+			userId, _ := strconv.Atoi(c.ID)
+			messageId, _ := strconv.Atoi(msg.MessageID)
+			receipt, err := hub.MessageService.MarkRead(userId, messageId)
+			if err != nil {
+				log.Printf("Failed to mark message as read: %v", err)
+				continue
+			}
+			hub.PublishReadReceipt(receipt)
+
 		case "sendMessage":
 			// Handle sending a message
 			groupId, _ := strconv.Atoi(msg.GroupID)
-			userId, _ := strconv.Atoi(msg.UserID)
-			var attachmentURL *string
+			userId, _ := strconv.Atoi(c.ID)
+			var attachmentID *uint
+
+			if msg.AttachmentID != "" {
+				id, err := strconv.Atoi(msg.AttachmentID)
+				if err != nil {
+					log.Printf("Invalid attachment ID: %s", msg.AttachmentID)
+					continue
+				}
+				attachment := uint(id)
+				attachmentID = &attachment
+			}
 
-			if msg.AttachmentURL != "" {
-    			attachmentURL = &msg.AttachmentURL
+			var parentID *uint
+
+			if msg.ParentID != "" {
+				id, err := strconv.Atoi(msg.ParentID)
+				if err != nil {
+					log.Printf("Invalid parent message ID: %s", msg.ParentID)
+					continue
+				}
+				parent := uint(id)
+				parentID = &parent
 			}
 			
 
 
 			newMessage := models.Message{
 				ContentType:   msg.ContentType,
-				AttachmentUrl: attachmentURL,
+				AttachmentId:  attachmentID,
 				GroupId:       uint(groupId),
 				UserId:        uint(userId),
 				Content:       msg.Content,
+				ParentId:      parentID,
+				Payload:       msg.Payload,
 			}
 
 			sentMessage, err := hub.MessageService.SendMessage(&newMessage);
@@ -100,15 +168,9 @@ func (c *Client) ReadPump(hub *Hub) {
 				log.Printf("Failed to save message: %v", err)
 				continue
 			}
-			broadcastMessage, err := json.Marshal(sentMessage)
-
-    		if err != nil {
-        		log.Printf("Failed to marshal message: %v", err)
-        		continue
-    		}
 
-    // Broadcast the saved message to all clients
-    hub.Broadcast <- broadcastMessage
+			// Broadcast the saved message to all clients in the group
+			hub.PublishMessage(sentMessage)
 
 		default:
 			log.Printf("Unknown action: %s", msg.Action)
@@ -116,6 +178,16 @@ func (c *Client) ReadPump(hub *Hub) {
 	}
 }
 
+// memberName is how a member is named in group notifications, by pseudonym in anonymous groups
+func memberName(hub *Hub, groupId, userId int) string {
+	author, err := hub.MessageService.GetAuthor(groupId, userId)
+	if err != nil || !author.Pseudonymous {
+		return "User " + strconv.Itoa(userId)
+	}
+
+	return author.Name
+}
+
 // WritePump sends messages to the client via WebSocket
 func (c *Client) WritePump() {
 	defer func() {
@@ -3,16 +3,42 @@ package websocket
 import (
 	"log"
 	"darkoo/models"
+	"strconv"
 
 	"encoding/json"
 )
 
+
+// directMessage is an event addressed to one user's connection
+type directMessage struct {
+	userID  string
+	message []byte
+}
+
+
+// groupRemoval takes a user's connection out of a group they no longer belong to
+type groupRemoval struct {
+	userID  string
+	groupID string
+	message []byte
+}
+
+
+// Event is the envelope for server-side events pushed to the clients of a group
+type Event struct {
+	Action  string      `json:"action"`
+	GroupID string      `json:"groupId"`
+	Payload interface{} `json:"payload"`
+}
+
 // Hub manages active WebSocket clients and broadcasts messages
 type Hub struct {
 	Clients    map[string]*Client      // A map of client IDs to clients
 	Register   chan *Client            // Channel to register new clients
 	Unregister chan *Client           // Channel to unregister clients
 	Broadcast  chan []byte            // Channel for broadcasting messages to all clients
+	Direct     chan directMessage     // Channel for messages addressed to a single user
+	Remove     chan groupRemoval      // Channel for taking a user out of a group's feed
 	MessageService models.IMessageService
 	UserService	   models.IUserService             // Interface to interact with the database
 }
@@ -24,6 +50,8 @@ func NewHub(messageService models.IMessageService, userService models.IUserServi
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		Broadcast:  make(chan []byte),
+		Direct:     make(chan directMessage),
+		Remove:     make(chan groupRemoval),
 		MessageService: messageService,  // Pass the concrete implementation
 		UserService:    userService,     // Pass the concrete implementation
 	}
@@ -43,6 +71,29 @@ func (h *Hub) Start() {
             delete(h.Clients, client.ID)
             log.Printf("Client unregistered: %s", client.ID)
 
+        case direct := <-h.Direct:
+            // Deliver to the user wherever they are connected, regardless of group
+            if client, ok := h.Clients[direct.userID]; ok {
+                select {
+                case client.Send <- direct.message:
+                default:
+                    close(client.Send)
+                    delete(h.Clients, client.ID)
+                }
+            }
+
+        case removal := <-h.Remove:
+            // Stop the group's traffic at once, the connection was only checked when it opened
+            if client, ok := h.Clients[removal.userID]; ok && client.Group == removal.groupID {
+                client.Group = ""
+                select {
+                case client.Send <- removal.message:
+                default:
+                    close(client.Send)
+                    delete(h.Clients, client.ID)
+                }
+            }
+
         case message := <-h.Broadcast:
             // Broadcast the message to all clients in the same group
             var msg Message
@@ -65,3 +116,82 @@ func (h *Hub) Start() {
     }
 }
 
+
+// BroadcastToGroup pushes an event to every client connected to the group
+func (h *Hub) BroadcastToGroup(groupId uint, action string, payload interface{}) {
+	event, err := json.Marshal(Event{
+		Action:  action,
+		GroupID: strconv.Itoa(int(groupId)),
+		Payload: payload,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal %s event: %v", action, err)
+		return
+	}
+
+	h.Broadcast <- event
+}
+
+
+// PublishMessage delivers a newly sent message to its group. Replies go out as
+// threadReply events so clients can update the thread instead of the main feed.
+func (h *Hub) PublishMessage(message *models.Message) {
+	action := "newMessage"
+	if message.IsReply() {
+		action = "threadReply"
+	}
+
+	h.BroadcastToGroup(message.GroupId, action, message)
+
+	for _, mention := range message.Mentions {
+		h.SendToUser(mention.UserId, "notification", mention.GroupId, mention)
+	}
+}
+
+
+// SendToUser pushes an event to a single user if they are connected
+func (h *Hub) SendToUser(userId uint, action string, groupId uint, payload interface{}) {
+	event, err := json.Marshal(Event{
+		Action:  action,
+		GroupID: strconv.Itoa(int(groupId)),
+		Payload: payload,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal %s event: %v", action, err)
+		return
+	}
+
+	h.Direct <- directMessage{ userID: strconv.Itoa(int(userId)), message: event }
+}
+
+
+// RemoveFromGroup stops a banned or kicked member's connection from receiving
+// the group's events and tells them they were removed
+func (h *Hub) RemoveFromGroup(userId, groupId uint) {
+	event, err := json.Marshal(Event{
+		Action:  "removedFromGroup",
+		GroupID: strconv.Itoa(int(groupId)),
+	})
+	if err != nil {
+		log.Printf("Failed to marshal removedFromGroup event: %v", err)
+		return
+	}
+
+	h.Remove <- groupRemoval{ userID: strconv.Itoa(int(userId)), groupID: strconv.Itoa(int(groupId)), message: event }
+}
+
+
+// PublishPollResults pushes a poll's new tallies to its group. The caller's own
+// votes are left out, members keep track of theirs from their vote responses.
+func (h *Hub) PublishPollResults(poll *models.Poll) {
+	results := *poll
+	results.MyVotes = nil
+
+	h.BroadcastToGroup(poll.GroupId, "pollUpdated", results)
+}
+
+
+// PublishReadReceipt tells the group that a member has read up to the message
+func (h *Hub) PublishReadReceipt(receipt *models.ReadReceipt) {
+	h.BroadcastToGroup(receipt.GroupId, "readReceipt", receipt)
+}
@@ -17,39 +17,32 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// HandleWebSocket handles incoming WebSocket requests
-func HandleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	// Upgrade the HTTP connection to a WebSocket
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		http.Error(w, "Failed to upgrade to WebSocket", http.StatusInternalServerError)
-		return
-	}
+// sendBufferSize is how many outgoing messages a client may fall behind before it is dropped
+const sendBufferSize = 256
 
-	userDetails := r.Context().Value("id")
+// HandleWebSocket handles incoming WebSocket requests for an authenticated user
+func HandleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request, user *middleware.User) {
+	groupID := r.URL.Query().Get("groupId")
 
-	if userDetails == nil {
-		log.Print("User not authenticated")
-		http.Error(w, "Failed to fetch user details", http.StatusInternalServerError)
+	if groupID == "" {
+		http.Error(w, "groupId is required", http.StatusBadRequest)
 		return
 	}
 
-	// Extract user and group IDs from query params (or headers, depending on your setup)
-	userID := userDetails.(*middleware.User).ID
-	groupID := r.URL.Query().Get("groupId")
-
-	if groupID == "" {
-		http.Error(w, "userId and groupId are required", http.StatusBadRequest)
+	// Upgrade the HTTP connection to a WebSocket
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade to WebSocket: %v", err)
 		return
 	}
 
 	// Create a new client and register it with the Hub
-	userId := strconv.Itoa(int(userID))
+	userId := strconv.Itoa(int(user.ID))
 	client := &Client{
 		ID:     userId,
 		Group:  groupID,
 		Socket: conn,
-		Send:   make(chan []byte),
+		Send:   make(chan []byte, sendBufferSize),
 	}
 	hub.Register <- client
 